@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const TLS_BASE_URL = "https://localhost:"
+
+// the server gives the agents it starts the path of its CA certificate in this env var
+const ENV_CA_FILE = "MINIKUBE_CA_FILE"
+
+// the names the server CA gives the certificates, see the server
+const SERVER_COMMON_NAME = "minikube-server"
+const AGENT_COMMON_NAME_PREFIX = "agent:"
+
+const CERTIFICATE_RETRY_PERIOD = time.Minute
+
+// serverCA is nil while TLS is off
+var serverCA *x509.CertPool
+
+// agentCertificate holds the *tls.Certificate in use, a renewal swaps it under the open listener
+var agentCertificate atomic.Value
+
+// certificateFile keeps the key and the certificate of the agent, a restarted agent renews the certificate
+// with it, the server only gives the certificate of a known agent to its current one
+var certificateFile string
+
+// renewNow is signalled when the server asks for a new certificate after a rotation
+var renewNow = make(chan struct{}, 1)
+
+// the calls to the server go through it, with the agent certificate once enableTLS ran
+var serverTransport http.RoundTripper = http.DefaultTransport
+
+type CertificateRequest struct {
+	ID  string
+	CSR string
+}
+
+type CertificateResponse struct {
+	Certificate string
+	CA          string
+}
+
+// loadServerCA reads the CA certificate from caFile, from the env when no file is given,
+// without either the agent talks plain HTTP
+func loadServerCA(caFile string) *x509.CertPool {
+	if caFile == "" {
+		caFile = os.Getenv(ENV_CA_FILE)
+	}
+	if caFile == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		agentLog.fatalf("%s has no PEM certificate", caFile)
+	}
+	return pool
+}
+
+func currentCertificate() *tls.Certificate {
+	certificate, _ := agentCertificate.Load().(*tls.Certificate)
+	return certificate
+}
+
+// enableTLS has the calls to the server check its certificate and present the agent one
+func enableTLS() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    serverCA,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			// the first certificate request goes without one, with the bootstrap token
+			if certificate := currentCertificate(); certificate != nil {
+				return certificate, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if state.PeerCertificates[0].Subject.CommonName != SERVER_COMMON_NAME {
+				return errors.New("the server certificate is not the server's")
+			}
+			return nil
+		},
+	}
+	serverTransport = transport
+}
+
+// listenerTLSConfig only takes requests from the server
+func listenerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return currentCertificate(), nil
+		},
+		ClientCAs:  serverCA,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if state.PeerCertificates[0].Subject.CommonName != SERVER_COMMON_NAME {
+				return errors.New("only the server may call the agent")
+			}
+			return nil
+		},
+	}
+}
+
+// requestCertificate has the server sign a new key of the agent, the next connections use it
+func requestCertificate(baseURL string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: AGENT_COMMON_NAME_PREFIX + agentID}}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	request := CertificateRequest{ID: agentID, CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))}
+	resp := serverRequestBuilder().Post(baseURL+"/agentCertificate", request)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certificate request failed with status %d: %s", resp.StatusCode, resp.String())
+	}
+
+	var response CertificateResponse
+	if err := resp.FillUp(&response); err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.X509KeyPair([]byte(response.Certificate), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return nil, err
+	}
+	if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+		return nil, err
+	}
+
+	agentCertificate.Store(&certificate)
+	saveCertificate(append([]byte(response.Certificate), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...))
+	// idle connections to the server still carry the old certificate
+	serverTransport.(*http.Transport).CloseIdleConnections()
+	agentLog.infof("new certificate valid until %s", certificate.Leaf.NotAfter.Format(time.RFC3339))
+	return certificate.Leaf, nil
+}
+
+func saveCertificate(content []byte) {
+	if certificateFile == "" {
+		return
+	}
+	if err := ioutil.WriteFile(certificateFile, content, 0600); err != nil {
+		agentLog.warnf("the certificate is not kept across restarts: %s", err)
+	}
+}
+
+// loadCertificate reads the certificate the agent had before a restart, nil when there is none or it
+// isn't a valid certificate of this agent anymore
+func loadCertificate() *tls.Certificate {
+	content, err := ioutil.ReadFile(certificateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			agentLog.warnf("%s", err)
+		}
+		return nil
+	}
+	certificate, err := tls.X509KeyPair(content, content)
+	if err == nil {
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	}
+	if err == nil {
+		_, err = certificate.Leaf.Verify(x509.VerifyOptions{Roots: serverCA, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	}
+	if err == nil && certificate.Leaf.Subject.CommonName != AGENT_COMMON_NAME_PREFIX+agentID {
+		err = fmt.Errorf("%s is the certificate of %s", certificateFile, certificate.Leaf.Subject.CommonName)
+	}
+	if err != nil {
+		agentLog.warnf("the certificate of the previous run can't be used: %s", err)
+		return nil
+	}
+	return &certificate
+}
+
+// renewCertificate runs for the life of the agent, the certificate is renewed once two thirds of its
+// lifetime passed or when the server asks for it, the containers keep running
+func renewCertificate(baseURL string, leaf *x509.Certificate) {
+	for {
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		select {
+		case <-time.After(time.Until(leaf.NotBefore.Add(lifetime * 2 / 3))):
+		case <-renewNow:
+		}
+
+		renewed, err := requestCertificate(baseURL)
+		if err != nil {
+			agentLog.errorf("certificate renewal failed: %s", err)
+			time.Sleep(CERTIFICATE_RETRY_PERIOD)
+			continue
+		}
+		leaf = renewed
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCA makes a CA like the one of the server and a certificate of it for the agent
+func newTestCA(t *testing.T, commonName string) (*x509.CertPool, []byte) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "minikube-ca"}, NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertificate, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: commonName}, NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(time.Hour), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCertificate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCertificate)
+	return pool, append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+}
+
+func TestCertificateIsKeptAcrossRestarts(t *testing.T) {
+	previousCA, previousID, previousFile := serverCA, agentID, certificateFile
+	t.Cleanup(func() { serverCA, agentID, certificateFile = previousCA, previousID, previousFile })
+
+	agentID = "agent-0"
+	certificateFile = filepath.Join(t.TempDir(), "agent-0.pem")
+	if loadCertificate() != nil {
+		t.Fatal("a certificate without a file")
+	}
+
+	pool, content := newTestCA(t, AGENT_COMMON_NAME_PREFIX+"agent-0")
+	serverCA = pool
+	saveCertificate(content)
+	if certificate := loadCertificate(); certificate == nil || certificate.Leaf.Subject.CommonName != AGENT_COMMON_NAME_PREFIX+"agent-0" {
+		t.Fatalf("the saved certificate isn't loaded: %+v", certificate)
+	}
+
+	// the certificate of another agent, or of another CA, is left to the bootstrap token
+	agentID = "agent-1"
+	if loadCertificate() != nil {
+		t.Fatal("the certificate of agent-0 is loaded for agent-1")
+	}
+	agentID = "agent-0"
+	serverCA, _ = newTestCA(t, AGENT_COMMON_NAME_PREFIX+"agent-0")
+	if loadCertificate() != nil {
+		t.Fatal("a certificate of another CA is loaded")
+	}
+}
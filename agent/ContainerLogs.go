@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/mux"
+)
+
+// flushWriter flushes every write so followed logs reach the server as soon as docker emits them
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func newFlushWriter(responseHTTP http.ResponseWriter) *flushWriter {
+	flusher, _ := responseHTTP.(http.Flusher)
+	return &flushWriter{writer: responseHTTP, flusher: flusher}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.writer.Write(p)
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+// containerIDByName finds a container this agent created, other containers of a shared docker host
+// with the same name aren't found
+func containerIDByName(cli *client.Client, containerName string) (string, bool) {
+	filters := filters.NewArgs()
+	filters.Add("name", "^"+containerName+"$")
+	filters.Add("label", LABEL_AGENT+"="+agentID)
+	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters})
+	if err != nil {
+		agentLog.errorf("%s", err)
+		return "", false
+	}
+
+	if len(containers) == 0 {
+		return "", false
+	}
+
+	return containers[0].ID, true
+}
+
+func containerLogsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	containerName := mux.Vars(r)["name"]
+	query := r.URL.Query()
+
+	requestLog(r).infof("logs of container %s request", containerName)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not connect to docker", err))
+		return
+	}
+
+	containerID, found := containerIDByName(cli, containerName)
+	if !found {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("container %s is not managed by this agent", containerName)))
+		return
+	}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     query.Get("follow") == "true",
+		Timestamps: query.Get("timestamps") == "true",
+		Since:      query.Get("since"),
+		Tail:       query.Get("tail"),
+	}
+	if options.Tail == "" {
+		options.Tail = "all"
+	}
+
+	// the request context is cancelled when the server drops the connection, which ends a followed stream
+	reader, err := cli.ContainerLogs(r.Context(), containerID, options)
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not read container logs", err))
+		return
+	}
+	defer reader.Close()
+
+	responseHTTP.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	responseHTTP.WriteHeader(http.StatusOK)
+
+	// containers are created without a TTY, so docker multiplexes stdout and stderr into one stream
+	writer := newFlushWriter(responseHTTP)
+	if _, err := stdcopy.StdCopy(writer, writer, reader); err != nil && r.Context().Err() == nil {
+		requestLog(r).warnf("%s", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// error codes carried in APIError.Code, shared with the server
+const (
+	ERROR_INVALID_REQUEST = "InvalidRequest"
+	ERROR_NOT_FOUND       = "NotFound"
+	ERROR_FORBIDDEN       = "Forbidden"
+	ERROR_CONFLICT        = "Conflict"
+	ERROR_DOCKER          = "DockerError"
+	ERROR_INTERNAL        = "InternalError"
+)
+
+// APIError is the body of every failed response of the agent
+type APIError struct {
+	Status  int `json:"-"`
+	Code    string
+	Message string
+	Details string `json:",omitempty"`
+}
+
+func (apiError *APIError) Error() string {
+	return apiError.Message
+}
+
+func newAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// dockerError keeps the reason docker gave, e.g. an image that isn't on docker hub is a 404 and not a generic failure
+func dockerError(message string, err error) *APIError {
+	apiError := newAPIError(http.StatusInternalServerError, ERROR_DOCKER, message)
+	apiError.Details = err.Error()
+
+	switch {
+	case errdefs.IsNotFound(err):
+		apiError.Status = http.StatusNotFound
+		apiError.Code = ERROR_NOT_FOUND
+	case errdefs.IsConflict(err):
+		apiError.Status = http.StatusConflict
+		apiError.Code = ERROR_CONFLICT
+	case errdefs.IsInvalidParameter(err):
+		apiError.Status = http.StatusBadRequest
+		apiError.Code = ERROR_INVALID_REQUEST
+	}
+	dockerErrors.add(labels("code", apiError.Code), 1)
+	return apiError
+}
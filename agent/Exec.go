@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// binary frames of an exec session start with one of these bytes, text frames carry an ExecMessage
+const (
+	STREAM_STDIN  = 0
+	STREAM_STDOUT = 1
+	STREAM_STDERR = 2
+)
+
+type ExecMessage struct {
+	Type     string
+	Width    uint
+	Height   uint
+	ExitCode int
+}
+
+var upgrader = websocket.Upgrader{}
+
+// streamWriter sends every write as a binary frame tagged with its stream
+type streamWriter struct {
+	conn      *websocket.Conn
+	writeLock *sync.Mutex
+	stream    byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.writeLock.Lock()
+	defer sw.writeLock.Unlock()
+
+	if err := sw.conn.WriteMessage(websocket.BinaryMessage, append([]byte{sw.stream}, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func execExitCode(cli *client.Client, execID string) int {
+	// the output may end a moment before docker marks the process as finished
+	for i := 0; i < 10; i++ {
+		inspect, err := cli.ContainerExecInspect(context.Background(), execID)
+		if err != nil {
+			agentLog.errorf("%s", err)
+			return -1
+		}
+		if !inspect.Running {
+			return inspect.ExitCode
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return -1
+}
+
+func execEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	containerName := mux.Vars(r)["name"]
+	query := r.URL.Query()
+	command := query["cmd"]
+	tty := query.Get("tty") == "true"
+
+	if len(command) == 0 {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "no command to execute"))
+		return
+	}
+
+	requestLog(r).infof("exec %v in container %s request", command, containerName)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not connect to docker", err))
+		return
+	}
+
+	containerID, found := containerIDByName(cli, containerName)
+	if !found {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("container %s is not managed by this agent", containerName)))
+		return
+	}
+
+	ctx := context.Background()
+	execCreated, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          command,
+	})
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not create exec in container", err))
+		return
+	}
+
+	hijacked, err := cli.ContainerExecAttach(ctx, execCreated.ID, types.ExecStartCheck{Tty: tty})
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not attach to exec in container", err))
+		return
+	}
+	defer hijacked.Close()
+
+	// docker accepted the exec, from here on errors are reported over the websocket
+	conn, err := upgrader.Upgrade(responseHTTP, r, nil)
+	if err != nil {
+		requestLog(r).warnf("%s", err)
+		return
+	}
+	defer conn.Close()
+
+	var writeLock sync.Mutex
+	go func() {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				hijacked.CloseWrite()
+				return
+			}
+
+			if messageType == websocket.BinaryMessage {
+				if len(data) > 1 && data[0] == STREAM_STDIN {
+					hijacked.Conn.Write(data[1:])
+				}
+				continue
+			}
+
+			var message ExecMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+			switch message.Type {
+			case "resize":
+				cli.ContainerExecResize(ctx, execCreated.ID, types.ResizeOptions{Height: message.Height, Width: message.Width})
+			case "stdinClose":
+				hijacked.CloseWrite()
+			}
+		}
+	}()
+
+	stdout := &streamWriter{conn: conn, writeLock: &writeLock, stream: STREAM_STDOUT}
+	if tty {
+		io.Copy(stdout, hijacked.Reader)
+	} else {
+		stderr := &streamWriter{conn: conn, writeLock: &writeLock, stream: STREAM_STDERR}
+		stdcopy.StdCopy(stdout, stderr, hijacked.Reader)
+	}
+
+	exitCode := execExitCode(cli, execCreated.ID)
+	requestLog(r).infof("exec in container %s exited with code %d", containerName, exitCode)
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	conn.WriteJSON(ExecMessage{Type: "exit", ExitCode: exitCode})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// the script every container runs, copied into it on creation
+const INIT_SCRIPT_PATH = "../agent/init.sh"
+
+// ContainerFile is a file written into the container before it starts
+type ContainerFile struct {
+	Path    string
+	Content string
+}
+
+// filesArchive packs the files in a tar rooted at /. it has no entries for their directories, docker
+// creates the missing ones and an entry would reset the mode of an existing one, /tmp for example
+func filesArchive(files []ContainerFile) ([]byte, error) {
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	now := time.Now()
+
+	for _, file := range files {
+		name := strings.TrimPrefix(path.Clean("/"+file.Path), "/")
+
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(file.Content)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write([]byte(file.Content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return archive.Bytes(), nil
+}
+
+// copyFilesToContainer writes the init script and the given files into a created container
+func copyFilesToContainer(ctx context.Context, cli *client.Client, containerID string, files []ContainerFile) error {
+	initScript, err := ioutil.ReadFile(INIT_SCRIPT_PATH)
+	if err != nil {
+		return err
+	}
+	files = append([]ContainerFile{{Path: "/init.sh", Content: string(initScript)}}, files...)
+
+	archive, err := filesArchive(files)
+	if err != nil {
+		return err
+	}
+	return cli.CopyToContainer(ctx, containerID, "/", bytes.NewReader(archive), types.CopyToContainerOptions{})
+}
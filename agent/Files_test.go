@@ -0,0 +1,46 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFilesArchive(t *testing.T) {
+	archive, err := filesArchive([]ContainerFile{
+		{Path: "/init.sh", Content: "#!/bin/sh"},
+		{Path: "/tmp/conf/app.yaml", Content: "port: 80"},
+		{Path: "/tmp/conf/level", Content: "debug"},
+		// a path can't lead out of the root
+		{Path: "../../etc/../run/secrets/token", Content: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make([]string, 0)
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a directory entry would change the mode of an existing directory, /tmp is 1777
+		if header.Typeflag != tar.TypeReg || header.Mode != 0644 {
+			t.Fatalf("entry %s of type %c mode %o", header.Name, header.Typeflag, header.Mode)
+		}
+		content, _ := ioutil.ReadAll(reader)
+		entries = append(entries, fmt.Sprintf("%s=%s", header.Name, content))
+	}
+
+	expected := "[init.sh=#!/bin/sh tmp/conf/app.yaml=port: 80 tmp/conf/level=debug run/secrets/token=secret]"
+	if fmt.Sprint(entries) != expected {
+		t.Fatalf("expected %s, got %v", expected, entries)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+const DEFAULT_HEARTBEAT_PERIOD = 5 * time.Second
+
+// Heartbeat tells the server the agent is alive and whether it can run containers
+type Heartbeat struct {
+	ID      string
+	Port    int
+	Ready   bool
+	Message string
+}
+
+// HeartbeatResponse is the answer of the server when it has something to ask, it answers 204 otherwise
+type HeartbeatResponse struct {
+	RenewCertificate bool
+}
+
+// dockerReady checks the docker daemon answers, an agent without it can't run containers
+func dockerReady() (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return false, err.Error()
+	}
+	defer cli.Close()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+// sendHeartbeats runs for the life of the agent, when the server doesn't know the agent
+// anymore (it was restarted) the agent registers again
+func sendHeartbeats(period time.Duration, baseURL string) {
+	rb := serverRequestBuilder()
+	rb.Timeout = period
+
+	for {
+		time.Sleep(period)
+
+		ready, message := dockerReady()
+		resp := rb.Post(baseURL+"/agentHeartbeat", Heartbeat{ID: agentID, Port: agentPort, Ready: ready, Message: message})
+		if resp.Err != nil {
+			agentLog.warnf("heartbeat failed: %s", resp.Err)
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusNoContent:
+		case http.StatusOK:
+			var answer HeartbeatResponse
+			if err := resp.FillUp(&answer); err == nil && answer.RenewCertificate {
+				select {
+				case renewNow <- struct{}{}:
+				default:
+				}
+			}
+		case http.StatusNotFound:
+			agentLog.warnf("server doesn't know this agent, registering again")
+			if err := register(agentPort, baseURL); err != nil {
+				agentLog.errorf("%s", err)
+			}
+		default:
+			agentLog.warnf("heartbeat answered with status %d", resp.StatusCode)
+		}
+	}
+}
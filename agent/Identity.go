@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+const DEFAULT_ID_FILE = "agent.id"
+
+// the server gives the agents it starts the bootstrap token in this env var
+const ENV_BOOTSTRAP_TOKEN = "MINIKUBE_BOOTSTRAP_TOKEN"
+
+// AgentRegistration is what the agent sends the server when it starts, the ID stays the same
+// across restarts so the server knows the containers it already runs
+type AgentRegistration struct {
+	ID   string
+	Port int
+}
+
+var agentID string
+
+// bootstrapToken proves to the server this is an agent, sent with the registration and every heartbeat
+var bootstrapToken string
+
+func newUUID() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", err
+	}
+
+	// version 4, variant RFC 4122
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// loadAgentID reads the ID of the agent from idFile, on the first start it generates one and saves it there
+func loadAgentID(idFile string) string {
+	content, err := ioutil.ReadFile(idFile)
+	if err == nil && strings.TrimSpace(string(content)) != "" {
+		return strings.TrimSpace(string(content))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		agentLog.fatalf("%s", err)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		agentLog.fatalf("%s", err)
+	}
+
+	if err := ioutil.WriteFile(idFile, []byte(id+"\n"), 0600); err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	agentLog.infof("new agent id %s saved in %s", id, idFile)
+	return id
+}
+
+// loadBootstrapToken reads the token from tokenFile, from the env when no file is given
+func loadBootstrapToken(tokenFile string) string {
+	if tokenFile == "" {
+		return os.Getenv(ENV_BOOTSTRAP_TOKEN)
+	}
+
+	content, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// serverRequestBuilder sends the bootstrap token to the server, and the agent certificate once it has one
+func serverRequestBuilder() *rest.RequestBuilder {
+	rb := &rest.RequestBuilder{Headers: make(http.Header), CustomPool: &rest.CustomPool{Transport: serverTransport}}
+	if bootstrapToken != "" {
+		rb.Headers.Set("Authorization", "Bearer "+bootstrapToken)
+	}
+	return rb
+}
+
+func register(port int, baseURL string) error {
+	resp := serverRequestBuilder().Post(baseURL+"/agentPort", AgentRegistration{ID: agentID, Port: port})
+	if resp.Err != nil {
+		return resp.Err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		agentLog.infof("registered as new agent %s", agentID)
+	case http.StatusOK:
+		agentLog.infof("reconnected as agent %s", agentID)
+	default:
+		return fmt.Errorf("registration failed with status %d: %s", resp.StatusCode, resp.String())
+	}
+	return nil
+}
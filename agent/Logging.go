@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the server passes on the request ID the CLI sent, the agent logs the request under it
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+const (
+	LEVEL_DEBUG = "debug"
+	LEVEL_INFO  = "info"
+	LEVEL_WARN  = "warn"
+	LEVEL_ERROR = "error"
+)
+
+var levelOrder = map[string]int{LEVEL_DEBUG: 0, LEVEL_INFO: 1, LEVEL_WARN: 2, LEVEL_ERROR: 3}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// set from the command line, see main
+var logLevel = LEVEL_INFO
+
+// logLock keeps the lines whole, the agents of the server write to stderr, which it keeps in their log file
+var logLock sync.Mutex
+var logOutput io.Writer = os.Stderr
+
+// LogEntry is a line of the log, like the server ones with the ID of the agent
+type LogEntry struct {
+	Time      time.Time
+	Level     string
+	Component string
+	Agent     string `json:",omitempty"`
+	RequestID string `json:",omitempty"`
+	Caller    string `json:",omitempty"`
+	Message   string
+}
+
+// logger writes the entries of a request, agentLog those of no request
+type logger struct {
+	requestID string
+}
+
+var agentLog logger
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+func checkLogLevel(level string) error {
+	if _, ok := levelOrder[level]; !ok {
+		return fmt.Errorf("-log-level must be %s, %s, %s or %s", LEVEL_DEBUG, LEVEL_INFO, LEVEL_WARN, LEVEL_ERROR)
+	}
+	return nil
+}
+
+// openLogFile appends the log to path, it stays on stderr when path is empty
+func openLogFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	logLock.Lock()
+	logOutput = file
+	logLock.Unlock()
+	return nil
+}
+
+func writeLog(level string, requestID string, caller string, message string) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	if levelOrder[level] < levelOrder[logLevel] {
+		return
+	}
+	line, _ := json.Marshal(LogEntry{
+		Time:      time.Now().UTC(),
+		Level:     level,
+		Component: "agent",
+		Agent:     agentID,
+		RequestID: requestID,
+		Caller:    caller,
+		Message:   strings.TrimRight(message, "\n "),
+	})
+	logOutput.Write(append(line, '\n'))
+}
+
+func callerOf(depth int) string {
+	_, file, line, ok := runtime.Caller(depth + 1)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+func (logs logger) debugf(format string, args ...interface{}) {
+	writeLog(LEVEL_DEBUG, logs.requestID, callerOf(1), fmt.Sprintf(format, args...))
+}
+
+func (logs logger) infof(format string, args ...interface{}) {
+	writeLog(LEVEL_INFO, logs.requestID, callerOf(1), fmt.Sprintf(format, args...))
+}
+
+func (logs logger) warnf(format string, args ...interface{}) {
+	writeLog(LEVEL_WARN, logs.requestID, callerOf(1), fmt.Sprintf(format, args...))
+}
+
+func (logs logger) errorf(format string, args ...interface{}) {
+	writeLog(LEVEL_ERROR, logs.requestID, callerOf(1), fmt.Sprintf(format, args...))
+}
+
+// fatalf logs at the error level, which no log level hides, and exits
+func (logs logger) fatalf(format string, args ...interface{}) {
+	writeLog(LEVEL_ERROR, logs.requestID, callerOf(1), fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// stdLogWriter takes what the standard log package writes, set up with log.Lshortfile
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	message := string(p)
+	caller := ""
+	if parts := strings.SplitN(message, ": ", 2); len(parts) == 2 && strings.Contains(parts[0], ".go:") {
+		caller, message = parts[0], parts[1]
+	}
+	writeLog(LEVEL_WARN, "", caller, message)
+	return len(p), nil
+}
+
+func requestLog(r *http.Request) logger {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return logger{requestID: id}
+}
+
+func responseLog(responseHTTP http.ResponseWriter) logger {
+	return logger{requestID: responseHTTP.Header().Get(REQUEST_ID_HEADER)}
+}
+
+// withRequestID keeps the request ID the server sent, a request without one gets its own
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseHTTP http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		responseHTTP.Header().Set(REQUEST_ID_HEADER, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		logger{requestID: id}.debugf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(responseHTTP, r)
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// the metrics are served in the Prometheus text format, version 0.0.4, like the server ones
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// the states docker reports, every one is written even without containers in it
+var containerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+
+var pullBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// metricsLock guards the counters and histograms, the gauges are read from docker when scraped
+var metricsLock sync.Mutex
+
+type counterVec struct {
+	name   string
+	help   string
+	series map[string]float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+var imagePullDurations = &histogramVec{
+	name:    "minikube_agent_image_pull_duration_seconds",
+	help:    "Duration of the image pulls by outcome.",
+	buckets: pullBuckets,
+	series:  make(map[string]*histogram),
+}
+
+var dockerErrors = &counterVec{
+	name:   "minikube_agent_docker_errors_total",
+	help:   "Failed Docker API calls by error code.",
+	series: make(map[string]float64),
+}
+
+// labels renders the label pairs, names and values alternating
+func labels(pairs ...string) string {
+	rendered := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rendered = append(rendered, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+	return strings.Join(rendered, ",")
+}
+
+func (counter *counterVec) add(seriesLabels string, value float64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	counter.series[seriesLabels] += value
+}
+
+func (vec *histogramVec) observe(seriesLabels string, value float64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	series, ok := vec.series[seriesLabels]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(vec.buckets))}
+		vec.series[seriesLabels] = series
+	}
+	for i, bound := range vec.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func sortedKeys(series map[string]float64) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeSample(out io.Writer, name string, seriesLabels string, value float64) {
+	if seriesLabels == "" {
+		fmt.Fprintf(out, "%s %s\n", name, formatValue(value))
+		return
+	}
+	fmt.Fprintf(out, "%s{%s} %s\n", name, seriesLabels, formatValue(value))
+}
+
+func writeHeader(out io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeGauge writes a gauge from its series, rendered labels to value
+func writeGauge(out io.Writer, name string, help string, series map[string]float64) {
+	writeHeader(out, name, help, "gauge")
+	for _, key := range sortedKeys(series) {
+		writeSample(out, name, key, series[key])
+	}
+}
+
+// write must be called with metricsLock held
+func (counter *counterVec) write(out io.Writer) {
+	writeHeader(out, counter.name, counter.help, "counter")
+	for _, key := range sortedKeys(counter.series) {
+		writeSample(out, counter.name, key, counter.series[key])
+	}
+}
+
+// write must be called with metricsLock held
+func (vec *histogramVec) write(out io.Writer) {
+	writeHeader(out, vec.name, vec.help, "histogram")
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := vec.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, bound := range vec.buckets {
+			writeSample(out, vec.name+"_bucket", prefix+labels("le", formatValue(bound)), float64(series.counts[i]))
+		}
+		writeSample(out, vec.name+"_bucket", prefix+labels("le", "+Inf"), float64(series.count))
+		writeSample(out, vec.name+"_sum", key, series.sum)
+		writeSample(out, vec.name+"_count", key, float64(series.count))
+	}
+}
+
+func observeImagePull(started time.Time, err error) {
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+	}
+	imagePullDurations.observe(labels("outcome", outcome), time.Since(started).Seconds())
+}
+
+// writeContainerMetrics writes the gauges read from docker, the counts by state and the usage of the running containers
+func writeContainerMetrics(ctx context.Context, out io.Writer) {
+	byState := make(map[string]float64)
+	for _, state := range containerStates {
+		byState[labels("state", state)] = 0
+	}
+	cpu := make(map[string]float64)
+	memory := make(map[string]float64)
+	memoryLimit := make(map[string]float64)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err == nil {
+		var containers []types.Container
+		containers, err = agentContainers(ctx, cli)
+		var running []string
+		for _, container := range containers {
+			byState[labels("state", container.State)]++
+			if container.State == "running" {
+				running = append(running, listedContainerName(container))
+			}
+		}
+		for _, status := range collectStatuses(ctx, cli, running) {
+			if status.Usage == nil {
+				continue
+			}
+			cpu[labels("container", status.Name)] = status.Usage.CPUPercent
+			memory[labels("container", status.Name)] = float64(status.Usage.MemoryUsage)
+			memoryLimit[labels("container", status.Name)] = float64(status.Usage.MemoryLimit)
+		}
+	}
+	if err != nil {
+		// the gauges are written anyway, the failure shows in minikube_agent_docker_errors_total
+		apiError := dockerError("could not list the containers", err)
+		agentLog.warnf("metrics: %s: %s", apiError.Message, apiError.Details)
+	}
+
+	writeGauge(out, "minikube_agent_containers", "Containers of the agent by Docker state.", byState)
+	writeGauge(out, "minikube_agent_container_cpu_percent", "CPU usage of the running containers, 100 per CPU.", cpu)
+	writeGauge(out, "minikube_agent_container_memory_usage_bytes", "Memory usage of the running containers without the page cache.", memory)
+	writeGauge(out, "minikube_agent_container_memory_limit_bytes", "Memory limit of the running containers.", memoryLimit)
+}
+
+func metricsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var out bytes.Buffer
+	writeContainerMetrics(r.Context(), &out)
+
+	metricsLock.Lock()
+	imagePullDurations.write(&out)
+	dockerErrors.write(&out)
+	metricsLock.Unlock()
+
+	responseHTTP.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	responseHTTP.WriteHeader(http.StatusOK)
+	responseHTTP.Write(out.Bytes())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// a tmpfs on the host, the secret files never reach a disk
+const DEFAULT_SECRETS_DIR = "/dev/shm/minikube-secrets"
+
+// set from the command line, see main
+var secretsDir = DEFAULT_SECRETS_DIR
+
+func containerSecretsDir(containerName string) string {
+	return filepath.Join(secretsDir, containerName)
+}
+
+// writeSecretFiles writes the secret files of a new container under its secrets directory, one directory
+// per mount path, and returns the read-only bind mounts exposing them and the directory of this container
+// only, another container with the same name may still be using its own
+func writeSecretFiles(containerName string, files []ContainerFile) ([]mount.Mount, string, error) {
+	mounts := make([]mount.Mount, 0)
+	if len(files) == 0 {
+		return mounts, "", nil
+	}
+
+	runDir := filepath.Join(containerSecretsDir(containerName), strconv.FormatInt(time.Now().UnixNano(), 10))
+	hostDirs := make(map[string]string)
+
+	for _, file := range files {
+		mountPath := path.Dir(file.Path)
+		hostDir, ok := hostDirs[mountPath]
+		if !ok {
+			hostDir = filepath.Join(runDir, strconv.Itoa(len(hostDirs)))
+			if err := os.MkdirAll(hostDir, 0700); err != nil {
+				return nil, runDir, err
+			}
+			// the directory itself is what the container sees
+			if err := os.Chmod(hostDir, 0755); err != nil {
+				return nil, runDir, err
+			}
+			hostDirs[mountPath] = hostDir
+			mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: hostDir, Target: mountPath, ReadOnly: true})
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(hostDir, path.Base(file.Path)), []byte(file.Content), 0444); err != nil {
+			return nil, runDir, err
+		}
+	}
+	return mounts, runDir, nil
+}
+
+// removeSecretFiles removes the secrets of every container that had the name
+func removeSecretFiles(containerName string) {
+	os.RemoveAll(containerSecretsDir(containerName))
+}
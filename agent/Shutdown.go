@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// long enough for a container deletion with the default grace period to finish
+const DEFAULT_SHUTDOWN_TIMEOUT = time.Minute
+
+// serveUntilSignal serves on the listener until SIGTERM or SIGINT, then waits for the running
+// requests, the containers are left running for the next agent with the same ID
+func serveUntilSignal(server *http.Server, listener net.Listener, timeout time.Duration) {
+	// log streams never end on their own, they are cancelled when the agent stops
+	streams, cancelStreams := context.WithCancel(context.Background())
+	server.BaseContext = func(net.Listener) context.Context {
+		return streams
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErrors <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErrors <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErrors:
+		agentLog.fatalf("%s", err)
+	case received := <-signals:
+		agentLog.infof("%s received, shutting down", received)
+	}
+	signal.Stop(signals)
+	cancelStreams()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		agentLog.warnf("requests still running at shutdown: %s", err)
+	}
+	agentLog.infof("agent stopped")
+}
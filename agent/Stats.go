@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// every container the agent creates carries its ID, containers from before the label aren't counted
+const LABEL_AGENT = "minikube.agent"
+
+// the state of a container the agent doesn't have, the others are the docker states
+const CONTAINER_MISSING = "missing"
+
+// ContainerUsage is a sample of docker stats of a running container
+type ContainerUsage struct {
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	NetworkRx   uint64
+	NetworkTx   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+// ContainerStatus is what the agent tells the server about a container, Usage only for a running one
+type ContainerStatus struct {
+	Name      string
+	State     string
+	StartedAt *time.Time `json:",omitempty"`
+	Restarts  int
+	Usage     *ContainerUsage `json:",omitempty"`
+}
+
+// agentContainers lists the containers of the agent whatever their state
+func agentContainers(ctx context.Context, cli *client.Client) ([]types.Container, error) {
+	filters := filters.NewArgs()
+	filters.Add("label", LABEL_AGENT+"="+agentID)
+	return cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filters})
+}
+
+// listedContainerName is the name docker lists the container with, without its leading slash
+func listedContainerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return container.ID
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+// cpuPercent is computed like docker stats does, the CPU time of the container over the CPU time
+// of the host between the two samples, 100 per CPU
+func cpuPercent(stats *types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// memoryUsage leaves out the page cache the kernel may take back, like docker stats
+func memoryUsage(stats *types.StatsJSON) uint64 {
+	usage := stats.MemoryStats.Usage
+	cache, ok := stats.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		// cgroup v2
+		cache = stats.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < usage {
+		return usage - cache
+	}
+	return usage
+}
+
+func usageFromStats(stats *types.StatsJSON) *ContainerUsage {
+	usage := &ContainerUsage{
+		CPUPercent:  cpuPercent(stats),
+		MemoryUsage: memoryUsage(stats),
+		MemoryLimit: stats.MemoryStats.Limit,
+	}
+	for _, network := range stats.Networks {
+		usage.NetworkRx += network.RxBytes
+		usage.NetworkTx += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			usage.BlockRead += entry.Value
+		case "write":
+			usage.BlockWrite += entry.Value
+		}
+	}
+	return usage
+}
+
+// containerStats reads one sample, docker waits for a second one so the CPU usage has a base
+func containerStats(ctx context.Context, cli *client.Client, containerID string) (*types.StatsJSON, error) {
+	response, err := cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// containerStatus inspects the container, a container whose stats fail is reported without usage
+func containerStatus(ctx context.Context, cli *client.Client, name string) ContainerStatus {
+	status := ContainerStatus{Name: name, State: CONTAINER_MISSING}
+	containerJSON, err := cli.ContainerInspect(ctx, name)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			apiError := dockerError(fmt.Sprintf("could not inspect container %s", name), err)
+			agentLog.warnf("%s: %s", apiError.Message, apiError.Details)
+		}
+		return status
+	}
+
+	status.State = containerJSON.State.Status
+	status.Restarts = containerJSON.RestartCount
+	if startedAt, err := time.Parse(time.RFC3339Nano, containerJSON.State.StartedAt); err == nil && !startedAt.IsZero() {
+		status.StartedAt = &startedAt
+	}
+	if !containerJSON.State.Running {
+		return status
+	}
+
+	stats, err := containerStats(ctx, cli, containerJSON.ID)
+	if err != nil {
+		apiError := dockerError(fmt.Sprintf("could not read the stats of container %s", name), err)
+		agentLog.warnf("%s: %s", apiError.Message, apiError.Details)
+		return status
+	}
+	status.Usage = usageFromStats(stats)
+	return status
+}
+
+// collectStatuses asks docker about the containers at the same time, stats take a second each
+func collectStatuses(ctx context.Context, cli *client.Client, names []string) []ContainerStatus {
+	statuses := make([]ContainerStatus, len(names))
+	var wait sync.WaitGroup
+	for i, name := range names {
+		wait.Add(1)
+		go func(i int, name string) {
+			defer wait.Done()
+			statuses[i] = containerStatus(ctx, cli, name)
+		}(i, name)
+	}
+	wait.Wait()
+	return statuses
+}
+
+// containerStatsEndPoint reports the containers named in the query, the server knows which ones the agent runs
+func containerStatsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+	requestLog(r).infof("stats of %d containers request", len(names))
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		requestLog(r).errorf("%s", err)
+		respondWithError(responseHTTP, dockerError("could not connect to docker", err))
+		return
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, collectStatuses(r.Context(), cli, names))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// seconds between SIGTERM and SIGKILL when the configuration doesn't say
+const DEFAULT_TERMINATION_GRACE_PERIOD = 30
+
+// the pre-stop hook of a container is kept in a label, so it is known on delete whoever asks for it
+const LABEL_PRE_STOP = "minikube.preStop"
+
+// PreStopHook runs before the container gets SIGTERM, either a command inside it or an HTTP GET to it
+type PreStopHook struct {
+	Exec []string     `json:",omitempty"`
+	HTTP *HTTPGetHook `json:",omitempty"`
+}
+
+type HTTPGetHook struct {
+	Path string
+	Port int
+}
+
+func terminationGracePeriod(container Container) int {
+	if container.TerminationGracePeriod <= 0 {
+		return DEFAULT_TERMINATION_GRACE_PERIOD
+	}
+	return container.TerminationGracePeriod
+}
+
+// terminationLabels are the labels a container is created with for its graceful stop
+func terminationLabels(container Container) map[string]string {
+	labels := make(map[string]string)
+	if container.PreStop != nil {
+		hook, _ := json.Marshal(container.PreStop)
+		labels[LABEL_PRE_STOP] = string(hook)
+	}
+	return labels
+}
+
+// runPreStopHook runs the hook of the container, a failed hook is logged and the stop goes on
+func runPreStopHook(ctx context.Context, cli *client.Client, containerJSON types.ContainerJSON, logs logger) {
+	label, ok := containerJSON.Config.Labels[LABEL_PRE_STOP]
+	if !ok {
+		return
+	}
+
+	var hook PreStopHook
+	if err := json.Unmarshal([]byte(label), &hook); err != nil {
+		logs.warnf("pre-stop hook of %s is invalid: %s", containerJSON.Name, err)
+		return
+	}
+
+	var err error
+	if len(hook.Exec) != 0 {
+		err = execPreStopHook(ctx, cli, containerJSON.ID, hook.Exec)
+	} else if hook.HTTP != nil {
+		err = httpPreStopHook(ctx, containerJSON, hook.HTTP)
+	}
+
+	if err != nil {
+		logs.warnf("pre-stop hook of %s failed: %s", containerJSON.Name, err)
+		return
+	}
+	logs.infof("pre-stop hook of %s done", containerJSON.Name)
+}
+
+func execPreStopHook(ctx context.Context, cli *client.Client, containerID string, command []string) error {
+	exec, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{Cmd: command, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return err
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer attach.Close()
+
+	// the output ends when the command exits, the context bounds it by the grace period
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, attach.Reader)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("command exited with code %d", inspect.ExitCode)
+	}
+	return nil
+}
+
+func httpPreStopHook(ctx context.Context, containerJSON types.ContainerJSON, hook *HTTPGetHook) error {
+	if containerJSON.NetworkSettings == nil || containerJSON.NetworkSettings.IPAddress == "" {
+		return fmt.Errorf("container has no IP address")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s:%d%s", containerJSON.NetworkSettings.IPAddress, hook.Port, hook.Path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// stopContainerGracefully runs the pre-stop hook, sends SIGTERM and SIGKILL once the grace period
+// the container was created with is over, the hook counts in the grace period
+func stopContainerGracefully(cli *client.Client, containerID string, logs logger) error {
+	containerJSON, err := cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return err
+	}
+
+	gracePeriod := time.Duration(DEFAULT_TERMINATION_GRACE_PERIOD) * time.Second
+	if containerJSON.Config.StopTimeout != nil {
+		gracePeriod = time.Duration(*containerJSON.Config.StopTimeout) * time.Second
+	}
+
+	if containerJSON.State == nil || !containerJSON.State.Running {
+		return nil
+	}
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	runPreStopHook(ctx, cli, containerJSON, logs)
+	cancel()
+
+	remaining := gracePeriod - time.Since(started)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	logs.infof("stopping %s, SIGKILL in %s", containerJSON.Name, remaining.Round(time.Second))
+	return cli.ContainerStop(context.Background(), containerID, &remaining)
+}
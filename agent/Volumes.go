@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+const (
+	VOLUME_TYPE_VOLUME = "volume"
+	VOLUME_TYPE_BIND   = "bind"
+	VOLUME_TYPE_TMPFS  = "tmpfs"
+)
+
+// labels of the named volumes the agent creates, a volume without LABEL_VOLUME_OWNER isn't touched on delete
+const LABEL_VOLUME_OWNER = "minikube.container"
+const LABEL_VOLUME_RETAIN = "minikube.retain"
+
+// set from the command line, the host directories bind mounts may come from, none by default
+var allowedHostPaths []string
+
+// VolumeMount is a named volume, a host bind mount or a tmpfs mounted at Target
+type VolumeMount struct {
+	Type     string
+	Source   string `json:",omitempty"`
+	Target   string
+	ReadOnly bool `json:",omitempty"`
+	// Retain keeps a named volume when its container is deleted, the replica gets it back when created again
+	Retain bool `json:",omitempty"`
+	// SizeMB limits a tmpfs, unlimited when zero
+	SizeMB int `json:",omitempty"`
+}
+
+// volumeName is the docker volume of a replica, every replica gets its own
+func volumeName(container Container, source string) string {
+	return fmt.Sprintf("%s-%s", generateContainerName(container), source)
+}
+
+// allowedHostPath resolves the symlinks of path and returns it when it is one of the allowed host
+// directories or inside one, a link a container made in an allowed directory can't lead out of it.
+// a path that doesn't exist isn't allowed
+func allowedHostPath(path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	for _, allowed := range allowedHostPaths {
+		allowed, err := filepath.EvalSymlinks(allowed)
+		if err != nil {
+			continue
+		}
+		if resolved == allowed || strings.HasPrefix(resolved, allowed+string(filepath.Separator)) {
+			return resolved, true
+		}
+	}
+	return "", false
+}
+
+// containerMounts turns the volumes of the container into docker mounts
+func containerMounts(container Container) ([]mount.Mount, *APIError) {
+	mounts := make([]mount.Mount, 0, len(container.Volumes))
+	for _, volume := range container.Volumes {
+		containerMount := mount.Mount{Target: volume.Target, ReadOnly: volume.ReadOnly}
+
+		switch volume.Type {
+		case VOLUME_TYPE_VOLUME:
+			containerMount.Type = mount.TypeVolume
+			containerMount.Source = volumeName(container, volume.Source)
+			// docker creates the volume with these labels the first time it is mounted
+			containerMount.VolumeOptions = &mount.VolumeOptions{Labels: map[string]string{
+				LABEL_VOLUME_OWNER:  generateContainerName(container),
+				LABEL_VOLUME_RETAIN: fmt.Sprintf("%t", volume.Retain),
+			}}
+
+		case VOLUME_TYPE_BIND:
+			source, ok := allowedHostPath(volume.Source)
+			if !ok {
+				return nil, newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, fmt.Sprintf("host path %s is not allowed on this agent", volume.Source))
+			}
+			// the resolved path is mounted and not the links the check followed
+			containerMount.Type = mount.TypeBind
+			containerMount.Source = source
+
+		case VOLUME_TYPE_TMPFS:
+			containerMount.Type = mount.TypeTmpfs
+			containerMount.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: int64(volume.SizeMB) * 1024 * 1024}
+
+		default:
+			return nil, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, fmt.Sprintf("unknown volume type %s", volume.Type))
+		}
+
+		mounts = append(mounts, containerMount)
+	}
+	return mounts, nil
+}
+
+// removeContainerVolumes removes the named volumes of a removed container unless they are retained
+func removeContainerVolumes(cli *client.Client, mountPoints []types.MountPoint, logs logger) {
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Type != mount.TypeVolume {
+			continue
+		}
+
+		volume, err := cli.VolumeInspect(context.Background(), mountPoint.Name)
+		if err != nil {
+			logs.warnf("could not inspect volume %s: %s", mountPoint.Name, err)
+			continue
+		}
+		if _, ours := volume.Labels[LABEL_VOLUME_OWNER]; !ours || volume.Labels[LABEL_VOLUME_RETAIN] == "true" {
+			continue
+		}
+
+		if err := cli.VolumeRemove(context.Background(), mountPoint.Name, false); err != nil {
+			logs.warnf("could not remove volume %s: %s", mountPoint.Name, err)
+			continue
+		}
+		logs.infof("volume %s removed", mountPoint.Name)
+	}
+}
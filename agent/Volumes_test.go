@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowedHostPath(t *testing.T) {
+	root := t.TempDir()
+	for _, directory := range []string{"data/logs", "data2", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// links a container with a read-write bind of data could have made
+	for link, target := range map[string]string{"data/root": "/", "data/up": "..", "data/other": filepath.Join(root, "other"), "data/inside": "logs"} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := allowedHostPaths
+	allowedHostPaths = []string{filepath.Join(root, "data")}
+	t.Cleanup(func() { allowedHostPaths = previous })
+
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	for _, test := range []struct {
+		path     string
+		allowed  bool
+		resolved string
+	}{
+		{root + "/data", true, resolvedRoot + "/data"},
+		{root + "/data/logs", true, resolvedRoot + "/data/logs"},
+		{root + "/data/logs/", true, resolvedRoot + "/data/logs"},
+		{root + "/data/inside", true, resolvedRoot + "/data/logs"},
+		{root + "/data/../other", false, ""},
+		{root + "/data/logs/../../other", false, ""},
+		// a sibling sharing the prefix of the allowed directory
+		{root + "/data2", false, ""},
+		{root + "/data/root", false, ""},
+		{root + "/data/root/etc", false, ""},
+		{root + "/data/up", false, ""},
+		{root + "/data/other", false, ""},
+		{root + "/data/missing", false, ""},
+		{"data/logs", false, ""},
+		{root, false, ""},
+	} {
+		resolved, allowed := allowedHostPath(test.path)
+		if allowed != test.allowed || resolved != test.resolved {
+			t.Errorf("%s: expected %t %s, got %t %s", test.path, test.allowed, test.resolved, allowed, resolved)
+		}
+	}
+}
+
+func TestBindMountsUseTheResolvedPath(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "data", "logs"), 0755)
+	os.Symlink("logs", filepath.Join(root, "data", "current"))
+	os.Symlink("/", filepath.Join(root, "data", "root"))
+
+	previous := allowedHostPaths
+	allowedHostPaths = []string{filepath.Join(root, "data")}
+	t.Cleanup(func() { allowedHostPaths = previous })
+
+	mounts, apiError := containerMounts(Container{ConfigurationName: "web", Index: 1, Volumes: []VolumeMount{{Type: VOLUME_TYPE_BIND, Source: filepath.Join(root, "data", "current"), Target: "/logs"}}})
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	if apiError != nil || len(mounts) != 1 || mounts[0].Source != filepath.Join(resolvedRoot, "data", "logs") {
+		t.Fatalf("bind mount of a link inside the allowed directory: %v %+v", apiError, mounts)
+	}
+
+	_, apiError = containerMounts(Container{ConfigurationName: "web", Index: 1, Volumes: []VolumeMount{{Type: VOLUME_TYPE_BIND, Source: filepath.Join(root, "data", "root"), Target: "/host"}}})
+	if apiError == nil || apiError.Code != ERROR_FORBIDDEN {
+		t.Fatalf("bind mount of a link out of the allowed directory: %v", apiError)
+	}
+}
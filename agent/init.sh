@@ -1,10 +1,14 @@
-#!/busybox
-
-START=$(date -u +%s)
-
-while true
-do
-  CURRENT=$(date -u +%s)
-  echo $(($CURRENT - $START)) seconds since the container was started
-  sleep 10
+#!/busybox
+
+START=$(date -u +%s)
+
+# stop right away on SIGTERM instead of waiting for the grace period to end in SIGKILL
+trap 'echo stopping; exit 0' TERM
+
+while true
+do
+  CURRENT=$(date -u +%s)
+  echo $(($CURRENT - $START)) seconds since the container was started
+  sleep 10 &
+  wait $!
 done
\ No newline at end of file
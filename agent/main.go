@@ -1,219 +1,311 @@
-package main
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net"
-	"net/http"
-	"os"
-	"os/exec"
-	"strconv"
-	"time"
-
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
-	"github.com/gorilla/mux"
-	"github.com/mercadolibre/golang-restclient/rest"
-)
-
-const BASE_URL = "http://localhost:"
-
-var agentPort int
-var cli *client.Client
-var portServer int
-
-type Configuration struct {
-	Name   string `yaml:"Name"`
-	Amount int    `yaml:"Amount"`
-	Image  string `yaml:"Image"`
-}
-
-type Container struct {
-	Index             int
-	ConfigurationName string
-	Image             string
-}
-
-func generateContainerName(container Container) string {
-	return fmt.Sprintf("%s%s", container.ConfigurationName, strconv.Itoa(container.Index))
-}
-
-func listenOnFreePort() net.Listener {
-	listener, err := net.Listen("tcp", ":0")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return listener
-}
-
-func respondWithJSON(responseHTTP http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
-	responseHTTP.Header().Set("Content-Type", "application/json")
-	responseHTTP.WriteHeader(code)
-	responseHTTP.Write(response)
-}
-
-func respondWithError(responseHTTP http.ResponseWriter, code int, message string) {
-	respondWithJSON(responseHTTP, code, message)
-}
-
-func runContainerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-
-	var container Container
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&container); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-	defer r.Body.Close()
-
-	log.Printf("run container with image %s index %d request \n", container.Image, container.Index)
-
-	containerSucceed := runContainer(container.Image, generateContainerName(container))
-	if containerSucceed != true {
-		respondWithError(responseHTTP, http.StatusBadRequest, "could not create container")
-		return
-	}
-
-	respondWithJSON(responseHTTP, http.StatusCreated, "container created")
-}
-
-func deleteContainerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	var containerName string
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&containerName); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	defer r.Body.Close()
-	if removeContainerByName(containerName) {
-		respondWithJSON(responseHTTP, http.StatusCreated, containerName)
-		return
-	}
-
-	respondWithError(responseHTTP, http.StatusBadRequest, fmt.Sprintf("agent havent succeed to remove container %s", containerName))
-}
-
-func sendPort(portAgent string, baseURL string) {
-	resp := rest.Post(baseURL+"/agentPort", portAgent)
-	if !(resp.StatusCode == http.StatusCreated) {
-		log.Fatal(resp.Err)
-	}
-}
-
-func agentStatusToServerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	responseHTTP.WriteHeader(http.StatusCreated)
-}
-
-func copyFileToContainer(containerID string, hostPath, insideContainerFilename string) {
-	cmd := exec.Command("docker", "cp", hostPath, fmt.Sprintf("%s:/%s", containerID, insideContainerFilename))
-	err := cmd.Run()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func removeContainerByName(containerName string) bool {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	filters := filters.NewArgs()
-	filters.Add("name", "^"+containerName+"$")
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters})
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	for _, container := range containers {
-		duration := time.Duration(0)
-		err = cli.ContainerStop(context.Background(), container.ID, &duration)
-		if err != nil {
-			log.Println(err)
-			return false
-		}
-		log.Printf("killed: %s\n", container.ID)
-
-		err = cli.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{Force: true})
-		if err != nil {
-			log.Println(err)
-			return false
-		}
-		log.Printf("removed: %s\n", container.ID)
-	}
-
-	return true
-}
-
-func runContainer(imageName string, name string) bool {
-	ctx := context.Background()
-
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	reader, err := cli.ImagePull(ctx, "docker.io/library/"+imageName, types.ImagePullOptions{})
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	io.Copy(os.Stdout, reader)
-
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-
-		Image: imageName,
-		Cmd:   []string{"/bin/sh", "/init.sh"},
-		Tty:   false,
-	}, nil, nil, nil, name)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-
-	copyFileToContainer(resp.ID, "../agent/init.sh", "init.sh")
-	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		log.Println(err)
-		return false
-	}
-
-	return true
-}
-
-func main() {
-	log.SetFlags(log.LstdFlags | log.Llongfile)
-
-	portServer, err := strconv.Atoi(os.Args[1])
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	r := mux.NewRouter()
-	api := r.PathPrefix("/").Subrouter()
-
-	api.HandleFunc("/runContainer", runContainerEndPoint).Methods(http.MethodPost)
-	api.HandleFunc("/deleteContainer", deleteContainerEndPoint).Methods(http.MethodPost)
-	api.HandleFunc("/isAgentActive", agentStatusToServerEndPoint).Methods(http.MethodGet)
-
-	portListener := listenOnFreePort()
-	agentPort := strconv.Itoa(portListener.Addr().(*net.TCPAddr).Port)
-
-	sendPort(agentPort, fmt.Sprintf("%s%d", BASE_URL, portServer))
-
-	log.Println("agent mode")
-	log.Println(fmt.Sprintf("Waiting for connections on %d", portListener.Addr().(*net.TCPAddr).Port))
-
-	log.Fatal(http.Serve(portListener, r))
-}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
+)
+
+const BASE_URL = "http://localhost:"
+const DEFAULT_NAMESPACE = "default"
+
+var agentPort int
+var cli *client.Client
+var portServer int
+
+type Configuration struct {
+	Name   string `yaml:"Name"`
+	Amount int    `yaml:"Amount"`
+	Image  string `yaml:"Image"`
+}
+
+type Container struct {
+	Index                  int
+	ConfigurationName      string
+	Namespace              string
+	Image                  string
+	TerminationGracePeriod int
+	PreStop                *PreStopHook
+	Volumes                []VolumeMount
+	// the config maps of the container resolved by the server
+	Files []ContainerFile
+	// SecretFiles are only kept in memory, in the secrets directory
+	SecretFiles []ContainerFile
+	Env         []string
+}
+
+// generateContainerName follows the server, the default namespace keeps the names from before namespaces
+func generateContainerName(container Container) string {
+	name := fmt.Sprintf("%s%s", container.ConfigurationName, strconv.Itoa(container.Index))
+	if container.Namespace == "" || container.Namespace == DEFAULT_NAMESPACE {
+		return name
+	}
+	return container.Namespace + "_" + name
+}
+
+func listenOnFreePort() net.Listener {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		agentLog.fatalf("%s", err)
+	}
+
+	return listener
+}
+
+func respondWithJSON(responseHTTP http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	responseHTTP.Header().Set("Content-Type", "application/json")
+	responseHTTP.WriteHeader(code)
+	responseHTTP.Write(response)
+}
+
+func respondWithError(responseHTTP http.ResponseWriter, apiError *APIError) {
+	responseLog(responseHTTP).warnf("error happened: %s (%s)", apiError.Message, apiError.Code)
+	respondWithJSON(responseHTTP, apiError.Status, apiError)
+}
+
+func runContainerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+
+	var container Container
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&container); err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	requestLog(r).infof("run container with image %s index %d request", container.Image, container.Index)
+
+	if apiError := runContainer(container, requestLog(r)); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	respondWithJSON(responseHTTP, http.StatusCreated, "container created")
+}
+
+func deleteContainerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var containerName string
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&containerName); err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "Invalid request payload"))
+		return
+	}
+
+	defer r.Body.Close()
+	if apiError := removeContainerByName(containerName, requestLog(r)); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	respondWithJSON(responseHTTP, http.StatusOK, containerName)
+}
+
+func agentStatusToServerEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	responseHTTP.WriteHeader(http.StatusOK)
+}
+
+func removeContainerByName(containerName string, logs logger) *APIError {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		logs.errorf("%s", err)
+		return dockerError("could not connect to docker", err)
+	}
+
+	filters := filters.NewArgs()
+	filters.Add("name", "^"+containerName+"$")
+	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters})
+	if err != nil {
+		logs.errorf("%s", err)
+		return dockerError("could not list containers", err)
+	}
+
+	for _, container := range containers {
+		err = stopContainerGracefully(cli, container.ID, logs)
+		if err != nil {
+			logs.errorf("%s", err)
+			return dockerError(fmt.Sprintf("could not stop container %s", containerName), err)
+		}
+		logs.infof("killed: %s", container.ID)
+
+		err = cli.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			logs.errorf("%s", err)
+			return dockerError(fmt.Sprintf("could not remove container %s", containerName), err)
+		}
+		logs.infof("removed: %s", container.ID)
+		removeContainerVolumes(cli, container.Mounts, logs)
+	}
+	removeSecretFiles(containerName)
+
+	return nil
+}
+
+func runContainer(containerToRun Container, logs logger) *APIError {
+	ctx := context.Background()
+	imageName := containerToRun.Image
+	name := generateContainerName(containerToRun)
+	stopTimeout := terminationGracePeriod(containerToRun)
+
+	mounts, apiError := containerMounts(containerToRun)
+	if apiError != nil {
+		return apiError
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		logs.errorf("%s", err)
+		return dockerError("could not connect to docker", err)
+	}
+
+	pullStarted := time.Now()
+	reader, err := cli.ImagePull(ctx, "docker.io/library/"+imageName, types.ImagePullOptions{})
+	if err != nil {
+		observeImagePull(pullStarted, err)
+		logs.errorf("%s", err)
+		return dockerError(fmt.Sprintf("could not pull image %s", imageName), err)
+	}
+
+	// the pull is over once its progress is read to the end, the progress itself would only clutter the log
+	_, err = io.Copy(ioutil.Discard, reader)
+	reader.Close()
+	observeImagePull(pullStarted, err)
+	logs.infof("image %s pulled in %s", imageName, time.Since(pullStarted).Round(time.Millisecond))
+
+	secretMounts, secretsRunDir, err := writeSecretFiles(name, containerToRun.SecretFiles)
+	if err != nil {
+		logs.errorf("%s", err)
+		os.RemoveAll(secretsRunDir)
+		return newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, fmt.Sprintf("could not write the secrets of container %s", name))
+	}
+	mounts = append(mounts, secretMounts...)
+
+	containerLabels := terminationLabels(containerToRun)
+	containerLabels[LABEL_AGENT] = agentID
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+
+		Image:       imageName,
+		Cmd:         []string{"/bin/sh", "/init.sh"},
+		Tty:         false,
+		Env:         containerToRun.Env,
+		Labels:      containerLabels,
+		StopTimeout: &stopTimeout,
+	}, &container.HostConfig{Mounts: mounts}, nil, nil, name)
+	if err != nil {
+		logs.errorf("%s", err)
+		os.RemoveAll(secretsRunDir)
+		return dockerError(fmt.Sprintf("could not create container %s", name), err)
+	}
+
+	if err := copyFilesToContainer(ctx, cli, resp.ID, containerToRun.Files); err != nil {
+		logs.errorf("%s", err)
+		// a container that never started is removed so the name is free for the next try
+		cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+		os.RemoveAll(secretsRunDir)
+		return dockerError(fmt.Sprintf("could not copy files into container %s", name), err)
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		logs.errorf("%s", err)
+		return dockerError(fmt.Sprintf("could not start container %s", name), err)
+	}
+
+	return nil
+}
+
+func main() {
+	idFile := flag.String("id-file", DEFAULT_ID_FILE, "file keeping the agent id across restarts")
+	flag.StringVar(&certificateFile, "certificate-file", "", "file keeping the key and certificate of the agent across restarts, the ID file with .pem when empty")
+	tokenFile := flag.String("bootstrap-token-file", "", "file with the bootstrap token of the server, "+ENV_BOOTSTRAP_TOKEN+" when empty")
+	caFile := flag.String("ca-file", "", "CA certificate of the server, "+ENV_CA_FILE+" when empty, plain HTTP without either")
+	heartbeatPeriod := flag.Duration("heartbeat-period", DEFAULT_HEARTBEAT_PERIOD, "how often the agent tells the server it is alive")
+	flag.StringVar(&secretsDir, "secrets-dir", DEFAULT_SECRETS_DIR, "directory on a tmpfs the secret files of the containers are kept in")
+	allowedPaths := flag.String("allowed-host-paths", "", "comma separated host directories containers may bind mount, none when empty")
+	shutdownTimeout := flag.Duration("shutdown-timeout", DEFAULT_SHUTDOWN_TIMEOUT, "how long a stopping agent waits for running requests")
+	flag.StringVar(&logLevel, "log-level", LEVEL_INFO, "lowest level logged: debug, info, warn or error")
+	logFile := flag.String("log-file", "", "file the agent log is appended to as JSON lines, stderr when empty")
+	flag.Parse()
+
+	if err := checkLogLevel(logLevel); err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	if err := openLogFile(*logFile); err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(stdLogWriter{})
+
+	portServer, err := strconv.Atoi(flag.Arg(0))
+	if err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	agentID = loadAgentID(*idFile)
+	bootstrapToken = loadBootstrapToken(*tokenFile)
+	serverCA = loadServerCA(*caFile)
+	if *allowedPaths != "" {
+		allowedHostPaths = strings.Split(*allowedPaths, ",")
+	}
+
+	r := mux.NewRouter()
+	r.Use(withRequestID)
+	api := r.PathPrefix("/").Subrouter()
+
+	api.HandleFunc("/runContainer", runContainerEndPoint).Methods(http.MethodPost)
+	api.HandleFunc("/deleteContainer", deleteContainerEndPoint).Methods(http.MethodPost)
+	api.HandleFunc("/isAgentActive", agentStatusToServerEndPoint).Methods(http.MethodGet)
+	api.HandleFunc("/containerLogs/{name}", containerLogsEndPoint).Methods(http.MethodGet)
+	api.HandleFunc("/exec/{name}", execEndPoint).Methods(http.MethodGet)
+	api.HandleFunc("/metrics", metricsEndPoint).Methods(http.MethodGet)
+	api.HandleFunc("/containerStats", containerStatsEndPoint).Methods(http.MethodGet)
+
+	portListener := listenOnFreePort()
+	agentPort = portListener.Addr().(*net.TCPAddr).Port
+
+	httpServer := &http.Server{Handler: r}
+	serverURL := fmt.Sprintf("%s%d", BASE_URL, portServer)
+	if serverCA != nil {
+		// the certificate comes before the registration, the server calls the agent over TLS right after it
+		enableTLS()
+		serverURL = fmt.Sprintf("%s%d", TLS_BASE_URL, portServer)
+		if certificateFile == "" {
+			certificateFile = strings.TrimSuffix(*idFile, filepath.Ext(*idFile)) + ".pem"
+		}
+		// a restarted agent the server still knows renews the certificate it had
+		if certificate := loadCertificate(); certificate != nil {
+			agentCertificate.Store(certificate)
+		}
+		leaf, err := requestCertificate(serverURL)
+		if err != nil {
+			agentLog.fatalf("%s", err)
+		}
+		go renewCertificate(serverURL, leaf)
+		httpServer.TLSConfig = listenerTLSConfig()
+	}
+
+	if err := register(agentPort, serverURL); err != nil {
+		agentLog.fatalf("%s", err)
+	}
+	go sendHeartbeats(*heartbeatPeriod, serverURL)
+
+	agentLog.infof("agent mode")
+	agentLog.infof("Waiting for connections on %d", portListener.Addr().(*net.TCPAddr).Port)
+
+	serveUntilSignal(httpServer, portListener, *shutdownTimeout)
+}
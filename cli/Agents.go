@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+type AgentProcess struct {
+	Number   int
+	PID      int
+	Running  bool
+	Restarts int
+	LastExit string
+	IDFile   string
+	LogFile  string
+}
+
+type AgentPool struct {
+	Size      int
+	Processes []AgentProcess
+}
+
+func printAgentPool(pool AgentPool) {
+	fmt.Printf("agent pool size: %d\n", pool.Size)
+	for _, process := range pool.Processes {
+		processStatus := "running"
+		if !process.Running {
+			processStatus = fmt.Sprintf("not running (%s)", process.LastExit)
+		}
+		fmt.Printf("agent %d pid %d is %s, restarts: %d, logs: %s\n", process.Number, process.PID, processStatus, process.Restarts, process.LogFile)
+	}
+}
+
+func agentPoolRespond(resp *rest.Response) {
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var pool AgentPool
+	if err := resp.FillUp(&pool); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	printAgentPool(pool)
+}
+
+func agents(params []string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	switch {
+	case len(params) == 1 && params[0] == "pool":
+		agentPoolRespond(rb.Get(apiURL + "/agents/pool"))
+
+	case len(params) == 2 && params[0] == "scale":
+		size, err := strconv.Atoi(params[1])
+		if err != nil || size < 0 {
+			fmt.Println("the pool size must be a number of agents")
+			os.Exit(1)
+		}
+		agentPoolRespond(rb.Put(apiURL+"/agents/pool", AgentPool{Size: size}))
+
+	default:
+		printHelp()
+	}
+}
+
+type DrainOptions struct {
+	MaxUnavailable int
+}
+
+func agentURL(id string) string {
+	return fmt.Sprintf("%s/agents/%s", apiURL, url.PathEscape(id))
+}
+
+// agent cordons, uncordons or drains a single agent
+func agent(command string, id string, params []string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	switch command {
+	case "cordon", "uncordon":
+		resp := rb.Post(agentURL(id)+"/"+command, nil)
+		if resp.Err != nil {
+			fmt.Println(resp.Err)
+			os.Exit(1)
+		}
+		if resp.StatusCode != http.StatusOK {
+			exitWithAPIError(resp.StatusCode, resp.Bytes())
+		}
+		fmt.Printf("agent %s %sed\n", id, command)
+
+	case "drain":
+		flagSet := flag.NewFlagSet("drain", flag.ExitOnError)
+		maxUnavailable := flagSet.Int("max-unavailable", 1, "replicas of a configuration moved at the same time")
+		wait := flagSet.Bool("wait", false, "wait until the operation ends and show its progress")
+		timeout := flagSet.Duration("timeout", 0, "give up waiting after this long, 0 waits forever")
+		flagSet.Parse(params)
+
+		resp := rb.Post(agentURL(id)+"/drain", DrainOptions{MaxUnavailable: *maxUnavailable})
+		if resp.Err != nil {
+			fmt.Println(resp.Err)
+			os.Exit(1)
+		}
+		operationRespond(resp, operationOptions{wait: *wait, timeout: *timeout})
+
+	default:
+		printHelp()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditEntry struct {
+	Time        time.Time
+	User        string
+	Method      string
+	Endpoint    string
+	Kind        string
+	Namespace   string
+	Name        string
+	Replica     int      `json:",omitempty"`
+	Command     []string `json:",omitempty"`
+	OperationID string
+	Before      json.RawMessage `json:",omitempty"`
+	After       json.RawMessage `json:",omitempty"`
+	Outcome     string
+	Status      int    `json:",omitempty"`
+	Error       string `json:",omitempty"`
+}
+
+func (entry *AuditEntry) target() string {
+	name := entry.Name
+	if entry.Namespace != "" && name != "" {
+		name = entry.Namespace + "/" + name
+	}
+	if name == "" {
+		return entry.Kind
+	}
+	if entry.Replica != 0 {
+		// an exec session
+		return fmt.Sprintf("%s %s replica %d: %s", entry.Kind, name, entry.Replica, strings.Join(entry.Command, " "))
+	}
+	return entry.Kind + " " + name
+}
+
+// audit shows the audit log of the server, --since and --until take a time or a duration before now,
+// --configuration is a configuration of the namespace of the command
+func audit(params []string) {
+	query := url.Values{}
+	flagSet := flag.NewFlagSet("audit", flag.ExitOnError)
+	since := flagSet.String("since", "", "only entries after this RFC3339 time or this long ago, like 2h")
+	until := flagSet.String("until", "", "only entries before this RFC3339 time or this long ago")
+	user := flagSet.String("user", "", "only the requests of this user")
+	configuration := flagSet.String("configuration", "", "only the requests about this configuration")
+	kind := flagSet.String("kind", "", "only the requests about this kind: Configuration, ConfigMap, Secret, Quota, Agent, AgentPool or Certificates")
+	limit := flagSet.Int("limit", 0, "at most this many entries, the newest ones")
+	asJSON := flagSet.Bool("json", false, "print the entries as JSON lines, with the spec before and after the request")
+	flagSet.Parse(params)
+
+	for key, value := range map[string]string{"since": *since, "until": *until, "user": *user, "configuration": *configuration, "kind": *kind} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+	if (*configuration != "" || namespaceFlagSet) && !allNamespaces {
+		query.Set("namespace", namespace)
+	}
+
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	resp := rb.Get(apiURL + "/audit?" + query.Encode())
+	exitUnlessStatus(resp, http.StatusOK)
+
+	var entries []AuditEntry
+	if err := resp.FillUp(&entries); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		for _, entry := range entries {
+			line, _ := json.Marshal(entry)
+			fmt.Println(string(line))
+		}
+		return
+	}
+
+	fmt.Printf("%-20s %-12s %-7s %-36s %-32s %-9s %s\n", "TIME", "USER", "METHOD", "ENDPOINT", "TARGET", "OUTCOME", "ERROR")
+	for _, entry := range entries {
+		user := entry.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Printf("%-20s %-12s %-7s %-36s %-32s %-9s %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), user, entry.Method, entry.Endpoint, entry.target(), entry.Outcome, entry.Error)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+type CertificatesStatus struct {
+	CAExpires     time.Time
+	ServerExpires time.Time
+	RotatedAt     *time.Time
+}
+
+func printCertificatesStatus(resp *rest.Response) {
+	exitUnlessStatus(resp, http.StatusOK)
+
+	var status CertificatesStatus
+	if err := resp.FillUp(&status); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-22s %s\n", "CA valid until", status.CAExpires.Local().Format(time.RFC3339))
+	fmt.Printf("%-22s %s\n", "server valid until", status.ServerExpires.Local().Format(time.RFC3339))
+	if status.RotatedAt != nil {
+		fmt.Printf("%-22s %s\n", "last rotation", status.RotatedAt.Local().Format(time.RFC3339))
+	}
+}
+
+func showCertificates() {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	printCertificatesStatus(rb.Get(apiURL + "/certificates"))
+}
+
+// rotateCertificates replaces the server certificate, the agents renew theirs with their next heartbeat
+func rotateCertificates() {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	printCertificatesStatus(rb.Post(apiURL+"/certificates/rotate", nil))
+	fmt.Println("the agents renew their certificates with their next heartbeat")
+}
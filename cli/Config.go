@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mercadolibre/golang-restclient/rest"
+	"gopkg.in/yaml.v2"
+)
+
+// the config file of the CLI, relative to the home directory
+const CLI_CONFIG_PATH = ".minikube/config.yaml"
+
+// CLIConfig is the config file of the CLI, like a kubeconfig a context names the server,
+// the user and the namespace the commands use
+type CLIConfig struct {
+	CurrentContext string       `yaml:"CurrentContext"`
+	Contexts       []CLIContext `yaml:"Contexts"`
+	Clusters       []CLICluster `yaml:"Clusters"`
+	Users          []CLIUser    `yaml:"Users"`
+	// Namespace is used when neither the command line nor the context give one
+	Namespace string `yaml:"Namespace"`
+}
+
+type CLIContext struct {
+	Name      string `yaml:"Name"`
+	Cluster   string `yaml:"Cluster"`
+	User      string `yaml:"User"`
+	Namespace string `yaml:"Namespace"`
+}
+
+// CLICluster is a server, the CLI only trusts the CA of its CertificateAuthority file over https
+type CLICluster struct {
+	Name                 string `yaml:"Name"`
+	Server               string `yaml:"Server"`
+	CertificateAuthority string `yaml:"CertificateAuthority"`
+}
+
+// CLIUser holds the API token given by the server admin, inline or in a file
+type CLIUser struct {
+	Name      string `yaml:"Name"`
+	Token     string `yaml:"Token"`
+	TokenFile string `yaml:"TokenFile"`
+}
+
+// sent as a bearer token with every request, none when empty
+var authToken string
+
+// the CA certificate the server certificate has to be signed by, the system ones when empty
+var caFile = DEFAULT_CA_FILE
+
+// carries every request to the server, see useTLS
+var transport = http.DefaultTransport.(*http.Transport).Clone()
+
+func loadCLIConfig() CLIConfig {
+	var config CLIConfig
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return config
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(home, CLI_CONFIG_PATH))
+	if err != nil {
+		return config
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		exitWithConfigError("%s", err)
+	}
+	return config
+}
+
+func exitWithConfigError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: %s: %s\n", CLI_CONFIG_PATH, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// useContext sets the server, the token and the namespace of the context, the current one when name is empty
+func useContext(config CLIConfig, name string) {
+	if config.Namespace != "" {
+		namespace = config.Namespace
+	}
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		return
+	}
+
+	var context *CLIContext
+	for i := range config.Contexts {
+		if config.Contexts[i].Name == name {
+			context = &config.Contexts[i]
+		}
+	}
+	if context == nil {
+		exitWithConfigError("there is no context %s", name)
+	}
+
+	if context.Cluster != "" {
+		found := false
+		for _, cluster := range config.Clusters {
+			if cluster.Name == context.Cluster {
+				apiURL = strings.TrimSuffix(cluster.Server, "/") + API_PREFIX
+				caFile = cluster.CertificateAuthority
+				found = true
+			}
+		}
+		if !found {
+			exitWithConfigError("context %s names unknown cluster %s", name, context.Cluster)
+		}
+	}
+
+	if context.User != "" {
+		found := false
+		for _, user := range config.Users {
+			if user.Name != context.User {
+				continue
+			}
+			found = true
+			authToken = user.Token
+			if user.TokenFile != "" {
+				content, err := ioutil.ReadFile(user.TokenFile)
+				if err != nil {
+					exitWithConfigError("%s", err)
+				}
+				authToken = strings.TrimSpace(string(content))
+			}
+		}
+		if !found {
+			exitWithConfigError("context %s names unknown user %s", name, context.User)
+		}
+	}
+
+	if context.Namespace != "" {
+		namespace = context.Namespace
+	}
+}
+
+// parseGlobalFlags takes the flags every command has out of the command line, they may be anywhere
+// before a "--": --context, -n/--namespace and -A/--all-namespaces
+func parseGlobalFlags(params []string) []string {
+	contextName := ""
+	flagNamespace := ""
+
+	remaining := make([]string, 0, len(params))
+	for i := 0; i < len(params); i++ {
+		param := params[i]
+		switch {
+		case param == "--":
+			remaining = append(remaining, params[i:]...)
+			i = len(params)
+
+		case param == "-A" || param == "--all-namespaces":
+			allNamespaces = true
+
+		case param == "-n" || param == "--namespace" || param == "--context":
+			if i+1 == len(params) {
+				fmt.Fprintf(os.Stderr, "Error: %s needs a value\n", param)
+				os.Exit(1)
+			}
+			if param == "--context" {
+				contextName = params[i+1]
+			} else {
+				flagNamespace = params[i+1]
+			}
+			i++
+
+		case strings.HasPrefix(param, "-n=") || strings.HasPrefix(param, "--namespace="):
+			flagNamespace = param[strings.Index(param, "=")+1:]
+
+		case strings.HasPrefix(param, "--context="):
+			contextName = strings.TrimPrefix(param, "--context=")
+
+		default:
+			remaining = append(remaining, param)
+		}
+	}
+
+	useContext(loadCLIConfig(), contextName)
+	useTLS()
+	if flagNamespace != "" {
+		namespace = flagNamespace
+		namespaceFlagSet = true
+	}
+	return remaining
+}
+
+// the server logs the requests of a command under this ID and passes it on to the agents
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// requestID is the same for every request of the command
+var requestID = newRequestID()
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+// requestHeader is the header of every request to the server, the token and the request ID
+func requestHeader() http.Header {
+	header := make(http.Header)
+	if authToken != "" {
+		header.Set("Authorization", "Bearer "+authToken)
+	}
+	header.Set(REQUEST_ID_HEADER, requestID)
+	return header
+}
+
+// the name the CA of the cluster gives the server certificate, the agents get certificates of the same CA
+const SERVER_COMMON_NAME = "minikube-server"
+
+// useTLS pins the CA of the cluster, the server certificate is only trusted when that CA signed it for the server
+func useTLS() {
+	if !strings.HasPrefix(apiURL, "https://") || caFile == "" {
+		return
+	}
+
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: the CA certificate of the server: %s\n", err)
+		os.Exit(1)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		fmt.Fprintf(os.Stderr, "Error: %s has no PEM certificate\n", caFile)
+		os.Exit(1)
+	}
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if state.PeerCertificates[0].Subject.CommonName != SERVER_COMMON_NAME {
+				return errors.New("the certificate is not the server's")
+			}
+			return nil
+		},
+	}
+}
+
+// newRequestBuilder sends the token of the user with the request
+func newRequestBuilder() *rest.RequestBuilder {
+	return &rest.RequestBuilder{Headers: requestHeader(), CustomPool: &rest.CustomPool{Transport: transport}}
+}
+
+func websocketDialer() *websocket.Dialer {
+	return &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 45 * time.Second, TLSClientConfig: transport.TLSClientConfig}
+}
+
+// streamGet is a GET whose body is read as it comes, the rest client would buffer it
+func streamGet(url string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header = requestHeader()
+	return (&http.Client{Transport: transport}).Do(request)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+type ConfigMap struct {
+	Name       string
+	Data       map[string]string
+	Namespaces []string `json:",omitempty"`
+}
+
+type ConfigMapRef struct {
+	Name      string `yaml:"Name"`
+	MountPath string `yaml:"MountPath" json:",omitempty"`
+	Env       bool   `yaml:"Env" json:",omitempty"`
+}
+
+// fromFiles collects the --from-file flags, each one [key=]path
+type fromFiles []string
+
+func (files *fromFiles) String() string {
+	return strings.Join(*files, ",")
+}
+
+func (files *fromFiles) Set(value string) error {
+	*files = append(*files, value)
+	return nil
+}
+
+// readConfigMapData reads every [key=]path into a key, the file name is the key when none is given
+func readConfigMapData(files fromFiles) map[string]string {
+	data := make(map[string]string)
+	for _, file := range files {
+		key, path := filepath.Base(file), file
+		if i := strings.Index(file, "="); i != -1 {
+			key, path = file[:i], file[i+1:]
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		data[key] = string(content)
+	}
+	return data
+}
+
+// splitNamespaces reads the --namespaces flag, a comma separated list
+func splitNamespaces(value string) []string {
+	namespaces := make([]string, 0)
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// sharedWith is what get shows of the namespaces a config map or secret is shared with
+func sharedWith(namespaces []string) string {
+	if len(namespaces) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (shared with %s)", strings.Join(namespaces, ", "))
+}
+
+func configMapURL(name string) string {
+	return fmt.Sprintf("%s/configmaps/%s", apiURL, url.PathEscape(name))
+}
+
+func exitUnlessStatus(resp *rest.Response, expectedStatus int) {
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != expectedStatus {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+}
+
+func createConfigMap(name string, params []string) {
+	var files fromFiles
+	flagSet := flag.NewFlagSet("create configmap", flag.ExitOnError)
+	flagSet.Var(&files, "from-file", "[key=]path of a file to add, the file name is the key by default, can be repeated")
+	namespaces := flagSet.String("namespaces", "", "comma separated namespaces whose configurations tokens limited to namespaces may give it to")
+	flagSet.Parse(params)
+
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	configMap := ConfigMap{Name: name, Data: readConfigMapData(files), Namespaces: splitNamespaces(*namespaces)}
+	exitUnlessStatus(rb.Post(apiURL+"/configmaps", configMap), http.StatusCreated)
+	fmt.Printf("configmap %s created\n", name)
+}
+
+func deleteConfigMap(name string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	exitUnlessStatus(rb.Delete(configMapURL(name)), http.StatusNoContent)
+	fmt.Printf("configmap %s deleted\n", name)
+}
+
+func printConfigMap(configMap ConfigMap, withData bool) {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("configmap %s: %s%s\n", configMap.Name, strings.Join(keys, ", "), sharedWith(configMap.Namespaces))
+	if !withData {
+		return
+	}
+	for _, key := range keys {
+		fmt.Printf("--- %s\n%s\n", key, configMap.Data[key])
+	}
+}
+
+// getConfigMaps lists the config maps with their keys, or shows one of them with its data
+func getConfigMaps(params []string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	if len(params) == 0 {
+		resp := rb.Get(apiURL + "/configmaps")
+		exitUnlessStatus(resp, http.StatusOK)
+
+		var configMapArray []ConfigMap
+		if err := resp.FillUp(&configMapArray); err != nil {
+			fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if len(configMapArray) == 0 {
+			fmt.Println("there are no configmaps")
+		}
+		for _, configMap := range configMapArray {
+			printConfigMap(configMap, false)
+		}
+		return
+	}
+
+	resp := rb.Get(configMapURL(params[0]))
+	exitUnlessStatus(resp, http.StatusOK)
+
+	var configMap ConfigMap
+	if err := resp.FillUp(&configMap); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	printConfigMap(configMap, true)
+}
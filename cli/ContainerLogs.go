@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+func getConfigurationAgent(name string) (ConfigurationAgent, bool) {
+	rb := newRequestBuilder()
+	rb.DisableTimeout = true
+
+	var configurationAgent ConfigurationAgent
+	resp := rb.Get(configurationURL(name) + "?" + namespaceQuery().Encode())
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.Response.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	if err := resp.FillUp(&configurationAgent); err != nil {
+		fmt.Println("Json fill up failed. Error: " + err.Error())
+		return configurationAgent, false
+	}
+	return configurationAgent, true
+}
+
+// streamReplicaLogs copies the logs of one replica to stdout, prefixing each line when prefix isn't empty
+func streamReplicaLogs(name string, replica int, query url.Values, prefix string, outputLock *sync.Mutex) {
+	query.Set("replica", strconv.Itoa(replica))
+	query.Set("namespace", namespace)
+	resp, err := streamGet(fmt.Sprintf("%s/logs?%s", configurationURL(name), query.Encode()))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		exitWithAPIError(resp.StatusCode, body)
+	}
+
+	if prefix == "" {
+		io.Copy(os.Stdout, resp.Body)
+		return
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		outputLock.Lock()
+		fmt.Printf("%s%s\n", prefix, scanner.Text())
+		outputLock.Unlock()
+	}
+}
+
+func logs(name string, params []string) {
+	flagSet := flag.NewFlagSet("logs", flag.ExitOnError)
+	replica := flagSet.Int("replica", 0, "replica index to show, all replicas are merged when omitted")
+	follow := flagSet.Bool("f", false, "follow the logs output")
+	tail := flagSet.String("tail", "all", "number of lines to show from the end of the logs")
+	since := flagSet.String("since", "", "show logs since a timestamp or relative duration (e.g. 10m)")
+	timestamps := flagSet.Bool("timestamps", false, "show timestamps")
+	flagSet.Parse(params)
+
+	query := url.Values{}
+	query.Set("follow", strconv.FormatBool(*follow))
+	query.Set("timestamps", strconv.FormatBool(*timestamps))
+	query.Set("tail", *tail)
+	if *since != "" {
+		query.Set("since", *since)
+	}
+
+	var outputLock sync.Mutex
+	if *replica != 0 {
+		streamReplicaLogs(name, *replica, query, "", &outputLock)
+		return
+	}
+
+	configurationAgent, ok := getConfigurationAgent(name)
+	if !ok {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= configurationAgent.Configuration.Amount; i++ {
+		wg.Add(1)
+		go func(replica int) {
+			defer wg.Done()
+			replicaQuery, _ := url.ParseQuery(query.Encode())
+			streamReplicaLogs(name, replica, replicaQuery, fmt.Sprintf("[%s%d] ", name, replica), &outputLock)
+		}(i)
+	}
+	wg.Wait()
+}
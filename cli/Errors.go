@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// APIError is the body of every failed response of the server
+type APIError struct {
+	Code     string
+	Message  string
+	Details  string
+	Failures []ReplicaFailure
+}
+
+type ReplicaFailure struct {
+	Container string
+	AgentPort int
+	Message   string
+}
+
+// exitWithAPIError prints the error the server answered with and exits with a non-zero status,
+// the request ID finds the request in the server and agent logs
+func exitWithAPIError(statusCode int, body []byte) {
+	var apiError APIError
+	if err := json.Unmarshal(body, &apiError); err != nil || apiError.Message == "" {
+		fmt.Fprintf(os.Stderr, "Error: server answered with status %d: %s\n", statusCode, body)
+	} else {
+		printAPIError(apiError)
+	}
+	fmt.Fprintf(os.Stderr, "  request ID: %s\n", requestID)
+	os.Exit(1)
+}
+
+func exitWithError(apiError APIError) {
+	printAPIError(apiError)
+	os.Exit(1)
+}
+
+func printAPIError(apiError APIError) {
+	fmt.Fprintf(os.Stderr, "Error: %s (%s)\n", apiError.Message, apiError.Code)
+	if apiError.Details != "" {
+		fmt.Fprintf(os.Stderr, "  %s\n", apiError.Details)
+	}
+
+	if len(apiError.Failures) != 0 {
+		fmt.Fprintln(os.Stderr, "  failed replicas:")
+		for _, failure := range apiError.Failures {
+			fmt.Fprintf(os.Stderr, "    %s on agent port %d: %s\n", failure.Container, failure.AgentPort, failure.Message)
+		}
+	}
+}
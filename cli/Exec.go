@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+)
+
+// binary frames of an exec session start with one of these bytes, text frames carry an ExecMessage
+const (
+	STREAM_STDIN  = 0
+	STREAM_STDOUT = 1
+	STREAM_STDERR = 2
+)
+
+type ExecMessage struct {
+	Type     string
+	Width    uint
+	Height   uint
+	ExitCode int
+}
+
+func sendTerminalSize(conn *websocket.Conn, writeLock *sync.Mutex) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return
+	}
+
+	writeLock.Lock()
+	defer writeLock.Unlock()
+	conn.WriteJSON(ExecMessage{Type: "resize", Width: uint(width), Height: uint(height)})
+}
+
+func pumpStdin(conn *websocket.Conn, writeLock *sync.Mutex) {
+	buffer := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if n > 0 {
+			writeLock.Lock()
+			writeErr := conn.WriteMessage(websocket.BinaryMessage, append([]byte{STREAM_STDIN}, buffer[:n]...))
+			writeLock.Unlock()
+			if writeErr != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			writeLock.Lock()
+			conn.WriteJSON(ExecMessage{Type: "stdinClose"})
+			writeLock.Unlock()
+			return
+		}
+	}
+}
+
+func execInContainer(name string, params []string) {
+	stdinFd := int(os.Stdin.Fd())
+
+	flagSet := flag.NewFlagSet("exec", flag.ExitOnError)
+	replica := flagSet.Int("replica", 1, "replica index to execute the command in")
+	tty := flagSet.Bool("t", term.IsTerminal(stdinFd), "allocate a TTY, the default when stdin is a terminal")
+	flagSet.Parse(params)
+
+	command := flagSet.Args()
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
+	}
+
+	query := url.Values{}
+	query.Set("replica", strconv.Itoa(*replica))
+	query.Set("tty", strconv.FormatBool(*tty))
+	query.Set("namespace", namespace)
+	query["cmd"] = command
+
+	execURL := fmt.Sprintf("%s/exec?%s", strings.Replace(configurationURL(name), "http", "ws", 1), query.Encode())
+	conn, resp, err := websocketDialer().Dial(execURL, requestHeader())
+	if err != nil {
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			exitWithAPIError(resp.StatusCode, body)
+		}
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var writeLock sync.Mutex
+	var oldState *term.State
+	if *tty {
+		oldState, _ = term.MakeRaw(stdinFd)
+
+		sendTerminalSize(conn, &writeLock)
+		resizeSignals := make(chan os.Signal, 1)
+		signal.Notify(resizeSignals, syscall.SIGWINCH)
+		go func() {
+			for range resizeSignals {
+				sendTerminalSize(conn, &writeLock)
+			}
+		}()
+	}
+
+	go pumpStdin(conn, &writeLock)
+
+	exitCode := 0
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		if messageType == websocket.BinaryMessage && len(data) > 0 {
+			if data[0] == STREAM_STDERR {
+				os.Stderr.Write(data[1:])
+			} else {
+				os.Stdout.Write(data[1:])
+			}
+			continue
+		}
+
+		var message ExecMessage
+		if err := json.Unmarshal(data, &message); err == nil && message.Type == "exit" {
+			exitCode = message.ExitCode
+		}
+	}
+
+	conn.Close()
+	if oldState != nil {
+		term.Restore(stdinFd, oldState)
+	}
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
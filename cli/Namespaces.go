@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+const DEFAULT_NAMESPACE = "default"
+
+// the namespace of the commands, from -n/--namespace, the context or the config file
+var namespace = DEFAULT_NAMESPACE
+var namespaceFlagSet bool
+
+// set by -A/--all-namespaces, the status and get commands show every namespace
+var allNamespaces bool
+
+// namespaceQuery is the query of a request about the configurations of the namespace
+func namespaceQuery() url.Values {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	return query
+}
+
+// listQuery is namespaceQuery, or every namespace with -A
+func listQuery() url.Values {
+	if allNamespaces {
+		return url.Values{"allNamespaces": []string{"true"}}
+	}
+	return namespaceQuery()
+}
+
+// configurationNamespace puts the configuration of a YAML file in the namespace of the command,
+// a namespace in the file wins over the config file but has to agree with -n
+func configurationNamespace(configuration *Configuration) {
+	if configuration.Namespace == "" {
+		configuration.Namespace = namespace
+		return
+	}
+	if namespaceFlagSet && configuration.Namespace != namespace {
+		fmt.Fprintf(os.Stderr, "Error: the configuration is in namespace %s, not %s\n", configuration.Namespace, namespace)
+		os.Exit(1)
+	}
+}
+
+// qualifiedName prefixes the name with its namespace when every namespace is listed
+func qualifiedName(objectNamespace string, name string) string {
+	if !allNamespaces || objectNamespace == "" {
+		return name
+	}
+	return objectNamespace + "/" + name
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+	"golang.org/x/term"
+)
+
+const OPERATION_POLL_PERIOD = 500 * time.Millisecond
+
+type ReplicaProgress struct {
+	Action    string
+	Container string
+	AgentPort int
+	Status    string
+	Message   string
+}
+
+type Operation struct {
+	ID                string
+	Type              string
+	ConfigurationName string
+	Namespace         string
+	AgentID           string
+	Status            string
+	Replicas          []ReplicaProgress
+	Error             *APIError
+	Started           time.Time
+	Finished          *time.Time
+}
+
+type operationOptions struct {
+	wait    bool
+	timeout time.Duration
+}
+
+func parseOperationFlags(command string, params []string) operationOptions {
+	var options operationOptions
+
+	flagSet := flag.NewFlagSet(command, flag.ExitOnError)
+	flagSet.BoolVar(&options.wait, "wait", false, "wait until the operation ends and show its progress")
+	flagSet.DurationVar(&options.timeout, "timeout", 0, "give up waiting after this long, 0 waits forever")
+	flagSet.Parse(params)
+	return options
+}
+
+// target is what the operation works on, a configuration or an agent
+func (operation *Operation) target() string {
+	if operation.AgentID != "" {
+		return "agent " + operation.AgentID
+	}
+	if operation.Namespace != "" {
+		return operation.Namespace + "/" + operation.ConfigurationName
+	}
+	return operation.ConfigurationName
+}
+
+func (operation *Operation) progress() string {
+	done, failed := 0, 0
+	for _, replica := range operation.Replicas {
+		switch replica.Status {
+		case "Succeeded":
+			done++
+		case "Failed":
+			failed++
+		}
+	}
+	return fmt.Sprintf("%s of %s: %d/%d replicas done, %d failed", operation.Type, operation.target(), done, len(operation.Replicas), failed)
+}
+
+func getOperation(id string) Operation {
+	rb := newRequestBuilder()
+	rb.Timeout = 10 * time.Second
+
+	resp := rb.Get(fmt.Sprintf("%s/operations/%s", apiURL, url.PathEscape(id)))
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var operation Operation
+	if err := resp.FillUp(&operation); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return operation
+}
+
+// waitForOperation polls the operation and redraws its progress until it ends or the timeout passes
+func waitForOperation(operation Operation, timeout time.Duration) Operation {
+	interactive := term.IsTerminal(int(os.Stdout.Fd()))
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	lastProgress := ""
+	for {
+		if progress := operation.progress(); progress != lastProgress {
+			if interactive {
+				fmt.Printf("\r\033[K%s", progress)
+			} else {
+				fmt.Println(progress)
+			}
+			lastProgress = progress
+		}
+
+		if operation.Status != "Running" {
+			break
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			if interactive {
+				fmt.Println()
+			}
+			fmt.Fprintf(os.Stderr, "Error: operation %s is still running after %s, follow it with: operation %s --wait\n", operation.ID, timeout, operation.ID)
+			os.Exit(1)
+		}
+
+		time.Sleep(OPERATION_POLL_PERIOD)
+		operation = getOperation(operation.ID)
+	}
+
+	if interactive {
+		fmt.Println()
+	}
+	return operation
+}
+
+func printOperation(operation Operation) {
+	fmt.Printf("operation %s: %s of %s is %s\n", operation.ID, operation.Type, operation.target(), operation.Status)
+	for _, replica := range operation.Replicas {
+		fmt.Printf("  %-6s %-20s agent port %-6d %s %s\n", replica.Action, replica.Container, replica.AgentPort, replica.Status, replica.Message)
+	}
+
+	if operation.Error != nil {
+		exitWithError(*operation.Error)
+	}
+}
+
+// operationRespond handles the answer of a create, update or delete, which starts an operation on the server
+func operationRespond(resp *rest.Response, options operationOptions) {
+	if resp.StatusCode >= http.StatusBadRequest {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var operation Operation
+	if err := resp.FillUp(&operation); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	resp.Body.Close()
+
+	if !options.wait {
+		fmt.Printf("operation %s started: %s of %s, follow it with: operation %s --wait\n", operation.ID, operation.Type, operation.target(), operation.ID)
+		return
+	}
+
+	operation = waitForOperation(operation, options.timeout)
+	if operation.Error != nil {
+		exitWithError(*operation.Error)
+	}
+	fmt.Printf("%s of %s succeeded\n", operation.Type, operation.target())
+}
+
+func showOperation(id string, params []string) {
+	options := parseOperationFlags("operation", params)
+
+	operation := getOperation(id)
+	if options.wait {
+		operation = waitForOperation(operation, options.timeout)
+	}
+	printOperation(operation)
+}
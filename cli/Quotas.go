@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+type Resources struct {
+	CPUMillis int `yaml:"CPUMillis" json:",omitempty"`
+	MemoryMB  int `yaml:"MemoryMB" json:",omitempty"`
+}
+
+type Quota struct {
+	MaxConfigurations int
+	MaxReplicas       int
+	MaxCPUMillis      int
+	MaxMemoryMB       int
+}
+
+type QuotaUsage struct {
+	Configurations int
+	Replicas       int
+	CPUMillis      int
+	MemoryMB       int
+}
+
+type QuotaStatus struct {
+	Namespace string
+	Quota     Quota
+	Used      QuotaUsage
+}
+
+func quotaURL() string {
+	return fmt.Sprintf("%s/namespaces/%s/quota", apiURL, url.PathEscape(namespace))
+}
+
+func quotaLimit(max int) string {
+	if max == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(max)
+}
+
+func printQuotaStatus(resp *rest.Response) {
+	exitUnlessStatus(resp, http.StatusOK)
+
+	var status QuotaStatus
+	if err := resp.FillUp(&status); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("namespace %s\n", status.Namespace)
+	fmt.Printf("  %-16s %8s / %s\n", "configurations", strconv.Itoa(status.Used.Configurations), quotaLimit(status.Quota.MaxConfigurations))
+	fmt.Printf("  %-16s %8s / %s\n", "replicas", strconv.Itoa(status.Used.Replicas), quotaLimit(status.Quota.MaxReplicas))
+	fmt.Printf("  %-16s %8s / %s\n", "cpu millis", strconv.Itoa(status.Used.CPUMillis), quotaLimit(status.Quota.MaxCPUMillis))
+	fmt.Printf("  %-16s %8s / %s\n", "memory MB", strconv.Itoa(status.Used.MemoryMB), quotaLimit(status.Quota.MaxMemoryMB))
+}
+
+func showQuota() {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	printQuotaStatus(rb.Get(quotaURL()))
+}
+
+// setQuota replaces the quota of the namespace, a limit left out is unlimited, without any the namespace is unlimited
+func setQuota(params []string) {
+	var quota Quota
+	flagSet := flag.NewFlagSet("set quota", flag.ExitOnError)
+	flagSet.IntVar(&quota.MaxConfigurations, "configurations", 0, "maximum number of configurations, 0 is unlimited")
+	flagSet.IntVar(&quota.MaxReplicas, "replicas", 0, "maximum replicas of all the configurations, 0 is unlimited")
+	flagSet.IntVar(&quota.MaxCPUMillis, "cpu", 0, "maximum CPU millis requested by all the replicas, 0 is unlimited")
+	flagSet.IntVar(&quota.MaxMemoryMB, "memory", 0, "maximum memory MB requested by all the replicas, 0 is unlimited")
+	flagSet.Parse(params)
+
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	printQuotaStatus(rb.Put(quotaURL(), quota))
+}
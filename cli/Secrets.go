@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type Secret struct {
+	Name       string
+	Data       map[string]string
+	Namespaces []string `json:",omitempty"`
+}
+
+// SecretInfo is what the server tells about a secret, never its values
+type SecretInfo struct {
+	Name       string
+	Keys       []string
+	Namespaces []string
+}
+
+type SecretRef struct {
+	Name      string `yaml:"Name"`
+	MountPath string `yaml:"MountPath" json:",omitempty"`
+	Env       bool   `yaml:"Env" json:",omitempty"`
+}
+
+func secretURL(name string) string {
+	return fmt.Sprintf("%s/secrets/%s", apiURL, url.PathEscape(name))
+}
+
+func createSecret(name string, params []string) {
+	var files fromFiles
+	var literals fromFiles
+	flagSet := flag.NewFlagSet("create secret", flag.ExitOnError)
+	flagSet.Var(&files, "from-file", "[key=]path of a file to add, the file name is the key by default, can be repeated")
+	flagSet.Var(&literals, "from-literal", "key=value to add, can be repeated")
+	namespaces := flagSet.String("namespaces", "", "comma separated namespaces whose configurations tokens limited to namespaces may give it to")
+	flagSet.Parse(params)
+
+	data := readConfigMapData(files)
+	for _, literal := range literals {
+		i := strings.Index(literal, "=")
+		if i == -1 {
+			fmt.Printf("--from-literal %s is not key=value\n", literal)
+			os.Exit(1)
+		}
+		data[literal[:i]] = literal[i+1:]
+	}
+
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	exitUnlessStatus(rb.Post(apiURL+"/secrets", Secret{Name: name, Data: data, Namespaces: splitNamespaces(*namespaces)}), http.StatusCreated)
+	fmt.Printf("secret %s created\n", name)
+}
+
+func deleteSecret(name string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+	exitUnlessStatus(rb.Delete(secretURL(name)), http.StatusNoContent)
+	fmt.Printf("secret %s deleted\n", name)
+}
+
+// getSecrets lists the secrets, or one of them, with their keys only
+func getSecrets(params []string) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	infos := make([]SecretInfo, 0)
+	if len(params) == 0 {
+		resp := rb.Get(apiURL + "/secrets")
+		exitUnlessStatus(resp, http.StatusOK)
+		if err := resp.FillUp(&infos); err != nil {
+			fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	} else {
+		resp := rb.Get(secretURL(params[0]))
+		exitUnlessStatus(resp, http.StatusOK)
+		var info SecretInfo
+		if err := resp.FillUp(&info); err != nil {
+			fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		infos = append(infos, info)
+	}
+
+	if len(infos) == 0 {
+		fmt.Println("there are no secrets")
+	}
+	for _, info := range infos {
+		fmt.Printf("secret %s: %s%s\n", info.Name, strings.Join(info.Keys, ", "), sharedWith(info.Namespaces))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// the server asks the agents, docker takes a second for every sample
+const STATS_TIMEOUT = time.Minute
+
+type ContainerUsage struct {
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	NetworkRx   uint64
+	NetworkTx   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+type ReplicaStatus struct {
+	Namespace     string
+	Configuration string
+	Replica       int
+	Container     string
+	AgentID       string
+	AgentPort     int
+	State         string
+	StartedAt     *time.Time
+	Restarts      int
+	Usage         *ContainerUsage
+	Error         string
+}
+
+func fetchReplicaStatuses(statsURL string) []ReplicaStatus {
+	rb := newRequestBuilder()
+	rb.Timeout = STATS_TIMEOUT
+	resp := rb.Get(statsURL)
+	exitUnlessStatus(resp, http.StatusOK)
+
+	var replicas []ReplicaStatus
+	if err := resp.FillUp(&replicas); err != nil {
+		fmt.Printf("Json fill up failed. Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return replicas
+}
+
+// formatBytes is the size with a binary unit, like docker stats
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	value := float64(size)
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[i])
+}
+
+// formatUptime is the time the replica runs for, in its two largest units
+func formatUptime(replica ReplicaStatus) string {
+	if replica.State != "running" || replica.StartedAt == nil {
+		return "-"
+	}
+	uptime := time.Since(*replica.StartedAt)
+	switch {
+	case uptime < time.Minute:
+		return fmt.Sprintf("%ds", int(uptime.Seconds()))
+	case uptime < time.Hour:
+		return fmt.Sprintf("%dm%ds", int(uptime.Minutes()), int(uptime.Seconds())%60)
+	case uptime < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(uptime.Hours()), int(uptime.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%dh", int(uptime.Hours())/24, int(uptime.Hours())%24)
+}
+
+// usageColumns are the CPU, MEMORY, NET I/O and BLOCK I/O columns, dashes without a sample
+func usageColumns(replica ReplicaStatus) (string, string, string, string) {
+	usage := replica.Usage
+	if usage == nil {
+		return "-", "-", "-", "-"
+	}
+	memory := formatBytes(usage.MemoryUsage)
+	if usage.MemoryLimit > 0 {
+		memory += " / " + formatBytes(usage.MemoryLimit)
+	}
+	return fmt.Sprintf("%.1f%%", usage.CPUPercent), memory,
+		formatBytes(usage.NetworkRx) + " / " + formatBytes(usage.NetworkTx),
+		formatBytes(usage.BlockRead) + " / " + formatBytes(usage.BlockWrite)
+}
+
+func replicaAgent(replica ReplicaStatus) string {
+	if replica.AgentID == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%d)", replica.AgentID, replica.AgentPort)
+}
+
+// printReplicaTable prints a row per replica, the errors of the agents that didn't answer after the table
+func printReplicaTable(replicas []ReplicaStatus, withConfiguration bool) {
+	format := "%-8s %-22s %-12s %-8s %-8s %-7s %-21s %-21s %s\n"
+	if withConfiguration {
+		format = "%-32s " + format
+		fmt.Printf(format, "CONFIGURATION", "REPLICA", "AGENT", "STATE", "UPTIME", "RESTARTS", "CPU", "MEMORY", "NET I/O", "BLOCK I/O")
+	} else {
+		fmt.Printf(format, "REPLICA", "AGENT", "STATE", "UPTIME", "RESTARTS", "CPU", "MEMORY", "NET I/O", "BLOCK I/O")
+	}
+
+	errors := make([]string, 0)
+	for _, replica := range replicas {
+		cpu, memory, network, block := usageColumns(replica)
+		columns := []interface{}{replica.Replica, replicaAgent(replica), replica.State, formatUptime(replica), replica.Restarts, cpu, memory, network, block}
+		if withConfiguration {
+			columns = append([]interface{}{qualifiedName(replica.Namespace, replica.Configuration)}, columns...)
+		}
+		fmt.Printf(format, columns...)
+		if replica.Error != "" {
+			errors = append(errors, fmt.Sprintf("%s: %s", replica.Container, replica.Error))
+		}
+	}
+	for _, message := range errors {
+		fmt.Println(message)
+	}
+}
+
+// top shows the replicas of the namespace, or of the configuration, the busiest first
+func top(params []string) {
+	name := ""
+	if len(params) > 0 && params[0] != "" && params[0][0] != '-' {
+		name = params[0]
+		params = params[1:]
+	}
+	flagSet := flag.NewFlagSet("top", flag.ExitOnError)
+	sortBy := flagSet.String("sort", "cpu", "order of the replicas: cpu or memory")
+	flagSet.Parse(params)
+	if *sortBy != "cpu" && *sortBy != "memory" {
+		fmt.Fprintf(os.Stderr, "Error: --sort is cpu or memory, not %s\n", *sortBy)
+		os.Exit(1)
+	}
+
+	statsURL := apiURL + "/stats?" + listQuery().Encode()
+	if name != "" {
+		statsURL = configurationURL(name) + "/stats?" + namespaceQuery().Encode()
+	}
+	replicas := fetchReplicaStatuses(statsURL)
+	if len(replicas) == 0 {
+		fmt.Println("No replica in the system")
+		return
+	}
+
+	// the replicas without a sample go last
+	sort.SliceStable(replicas, func(i, j int) bool {
+		first, second := replicas[i].Usage, replicas[j].Usage
+		if first == nil || second == nil {
+			return first != nil
+		}
+		if *sortBy == "memory" {
+			return first.MemoryUsage > second.MemoryUsage
+		}
+		return first.CPUPercent > second.CPUPercent
+	})
+	printReplicaTable(replicas, true)
+}
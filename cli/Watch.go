@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const WATCH_RECONNECT_DELAY = time.Second
+
+type WatchEvent struct {
+	Type            string
+	Kind            string
+	Name            string
+	Namespace       string
+	ResourceVersion uint64
+	Object          json.RawMessage
+}
+
+type ContainerStatus struct {
+	Container
+	AgentPort int
+}
+
+var watchKinds = map[string]string{
+	"all":            "",
+	"configurations": "Configuration",
+	"configuration":  "Configuration",
+	"containers":     "Container",
+	"container":      "Container",
+	"agents":         "Agent",
+	"agent":          "Agent",
+}
+
+func describeWatchObject(event WatchEvent) string {
+	switch event.Kind {
+	case "Configuration":
+		var configuration Configuration
+		json.Unmarshal(event.Object, &configuration)
+		return fmt.Sprintf("image: %s, amount: %d", configuration.Image, configuration.Amount)
+
+	case "Container":
+		var container ContainerStatus
+		json.Unmarshal(event.Object, &container)
+		return fmt.Sprintf("image: %s, agent port: %d", container.Image, container.AgentPort)
+
+	case "Agent":
+		var agent Agent
+		json.Unmarshal(event.Object, &agent)
+		return fmt.Sprintf("%s, port: %d, containers: %d", agentState(agent), agent.Port, len(agent.MapContainerName))
+	}
+	return ""
+}
+
+// readWatchStream prints the events of one watch connection, it returns the last resource version seen
+// and whether the stream ended because the server asked for a fresh watch
+func readWatchStream(query url.Values, follow bool, resourceVersion uint64) (uint64, bool) {
+	if resourceVersion != 0 {
+		query.Set("resourceVersion", strconv.FormatUint(resourceVersion, 10))
+	} else {
+		query.Del("resourceVersion")
+	}
+
+	resp, err := streamGet(fmt.Sprintf("%s/watch?%s", apiURL, query.Encode()))
+	if err != nil {
+		if !follow {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return resourceVersion, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone && follow {
+		return 0, true
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		exitWithAPIError(resp.StatusCode, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var event WatchEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			continue
+		}
+		resourceVersion = event.ResourceVersion
+
+		if event.Type == "SYNCED" {
+			if !follow {
+				return resourceVersion, false
+			}
+			continue
+		}
+
+		if follow {
+			fmt.Printf("%-9s %-14s %-20s %s\n", event.Type, event.Kind, qualifiedName(event.Namespace, event.Name), describeWatchObject(event))
+		} else {
+			fmt.Printf("%-14s %-20s %s\n", event.Kind, qualifiedName(event.Namespace, event.Name), describeWatchObject(event))
+		}
+	}
+	return resourceVersion, false
+}
+
+func get(params []string) {
+	kind, ok := watchKinds[params[0]]
+	if !ok {
+		printHelp()
+		return
+	}
+
+	params = params[1:]
+	name := ""
+	if len(params) != 0 && !strings.HasPrefix(params[0], "-") {
+		name = params[0]
+		params = params[1:]
+	}
+
+	flagSet := flag.NewFlagSet("get", flag.ExitOnError)
+	watch := flagSet.Bool("watch", false, "keep printing the changes as they happen")
+	flagSet.Parse(params)
+
+	// the server sends every namespace to a watch without one
+	query := url.Values{}
+	if !allNamespaces {
+		query.Set("namespace", namespace)
+	}
+	if kind != "" {
+		query.Set("kinds", kind)
+	}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	resourceVersion, _ := readWatchStream(query, false, 0)
+	if !*watch {
+		return
+	}
+
+	// the server closes the stream of a watcher that falls behind, it resumes from the last event it printed
+	for {
+		var expired bool
+		resourceVersion, expired = readWatchStream(query, true, resourceVersion)
+		if expired {
+			resourceVersion, _ = readWatchStream(query, false, 0)
+		}
+		time.Sleep(WATCH_RECONNECT_DELAY)
+	}
+}
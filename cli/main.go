@@ -1,279 +1,428 @@
-package main
-
-import (
-	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
-
-	"github.com/mercadolibre/golang-restclient/rest"
-	"gopkg.in/yaml.v2"
-)
-
-const SERVER_URL = "http://localhost:1234"
-
-type ConfigurationAgent struct {
-	Configuration Configuration
-	AgentArray    []Agent
-}
-
-type Agent struct {
-	MapContainerName map[string]*Container
-	Port             int
-	Active           bool
-}
-
-type Configuration struct {
-	Name   string `yaml:"Name"`
-	Amount int    `yaml:"Amount"`
-	Image  string `yaml:"Image"`
-}
-
-type Container struct {
-	Index             int
-	ConfigurationName string
-	Image             string
-}
-
-func printAgentStatus(agents []Agent) {
-	if 0 == len(agents) {
-		fmt.Printf("No agents in system")
-		return
-	}
-
-	var agentStatus string
-	for i, agent := range agents {
-		if agent.Active {
-			agentStatus = "active"
-		} else {
-			agentStatus = "not active"
-		}
-		fmt.Printf("Agent %d on port: %d is %s \n", i, agent.Port, agentStatus)
-	}
-}
-
-func printConfigurationWithAgentDivision(configurationAgent ConfigurationAgent) {
-	fmt.Printf("Configuration name: %s \n", configurationAgent.Configuration.Name)
-	fmt.Printf("Image %s\n", configurationAgent.Configuration.Image)
-	fmt.Printf("Amount %d\n", configurationAgent.Configuration.Amount)
-
-	for _, agent := range configurationAgent.AgentArray {
-		fmt.Printf("agent on port: %d is responisble to the containers below: \n", agent.Port)
-		for containerName, container := range agent.MapContainerName {
-			if container.ConfigurationName == configurationAgent.Configuration.Name {
-				fmt.Printf("container name %s\n", containerName)
-			}
-		}
-	}
-}
-
-func printAllConfigurationStatus(configurationArray []Configuration) {
-	if 0 == len(configurationArray) {
-		fmt.Println("No configuration in the system")
-		return
-
-	}
-
-	for _, configuration := range configurationArray {
-		fmt.Printf("configuration name: %s, amount: %d , image: %s \n",
-			configuration.Name, configuration.Amount, configuration.Image)
-	}
-}
-
-func (c *Configuration) getContentFromYAML(fileName string) *Configuration {
-	yamlFile, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		log.Fatalf("yamlFile.Get err   #%v ", err)
-	}
-
-	err = yaml.Unmarshal(yamlFile, c)
-	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
-	}
-
-	return c
-}
-
-func envStatus() {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Get(SERVER_URL + "/envStatus")
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	if resp.Response.StatusCode == http.StatusCreated {
-		var configurationArray []Configuration
-		err := resp.FillUp(&configurationArray)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
-		}
-
-		printAllConfigurationStatus(configurationArray)
-	}
-
-	resp.Body.Close()
-}
-
-func create(Info Configuration) {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Post(fmt.Sprintf("%s/create", SERVER_URL), Info)
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	stringRespond(resp)
-}
-
-func delete(name string) {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Post(fmt.Sprintf("%s/delete", SERVER_URL), name)
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	stringRespond(resp)
-}
-
-func update(Info Configuration) {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Post(fmt.Sprintf("%s/update", SERVER_URL), Info)
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	stringRespond(resp)
-}
-
-func envNameStatus(name string) {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Post(SERVER_URL+"/envNameStatus", name)
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	if resp.Response.StatusCode == http.StatusCreated {
-		var configurationAgent ConfigurationAgent
-		err := resp.FillUp(&configurationAgent)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
-		}
-
-		printConfigurationWithAgentDivision(configurationAgent)
-
-	} else {
-
-		var errorMessage string
-		err := resp.FillUp(&errorMessage)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
-		}
-		fmt.Printf("%s \n", errorMessage)
-	}
-
-	resp.Body.Close()
-}
-
-func agentsStatus() {
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-
-	resp := rb.Get(SERVER_URL + "/agentsStatus")
-	if resp.Err != nil {
-		fmt.Println(resp.Err)
-		return
-	}
-
-	if resp.Response.StatusCode == http.StatusCreated {
-		var agents []Agent
-		err := resp.FillUp(&agents)
-		if err != nil {
-			log.Println(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
-		}
-		printAgentStatus(agents)
-	}
-
-	resp.Body.Close()
-}
-
-func printHelp() {
-	fmt.Println("Please enter valid request, you are only allowed the commands below:")
-	fmt.Println("create <YAML file path>")
-	fmt.Println("delete <YAML file path>")
-	fmt.Println("update <YAML file path>")
-	fmt.Println("Show env status")
-	fmt.Println("Show env <Name> status")
-	fmt.Println("Show agent status")
-}
-
-func stringRespond(resp *rest.Response) {
-	var message string
-	err := resp.FillUp(&message)
-	if err != nil {
-		log.Println("Json fill up failed. Error: " + err.Error())
-		return
-	}
-
-	fmt.Println(message)
-	resp.Body.Close()
-}
-
-func doAction(params []string) {
-	if len(params) == 2 {
-		switch params[0] {
-		case "create":
-			yamlStruct := Configuration{}
-			yamlStruct.getContentFromYAML(params[1])
-			create(yamlStruct)
-			return
-
-		case "delete":
-			delete(params[1])
-			return
-
-		case "update":
-			yamlStruct := Configuration{}
-			yamlStruct.getContentFromYAML(params[1])
-			update(yamlStruct)
-			return
-		}
-	}
-
-	if len(params) == 3 && params[0] == "Show" && params[2] == "status" {
-		if params[1] == "env" {
-			envStatus()
-			return
-		}
-		if params[1] == "agent" {
-			agentsStatus()
-			return
-		}
-	}
-
-	if len(params) == 4 && params[0] == "Show" && params[1] == "env" && params[3] == "status" {
-		envNameStatus(params[2])
-		return
-	}
-
-	printHelp()
-}
-
-func main() {
-	argsWithoutProg := os.Args[1:]
-	doAction(argsWithoutProg)
-}
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DEFAULT_SERVER_URL = "https://localhost:1234"
+
+// the CA of a server started from ../server with its default -pki-dir
+const DEFAULT_CA_FILE = "../server/pki/ca.crt"
+const API_PREFIX = "/api/v1"
+
+// the API of the server of the context, see useContext
+var apiURL = DEFAULT_SERVER_URL + API_PREFIX
+
+type ConfigurationAgent struct {
+	Configuration Configuration
+	AgentArray    []Agent
+}
+
+type Agent struct {
+	ID               string
+	MapContainerName map[string]*Container
+	Port             int
+	Active           bool
+	State            string
+	Message          string
+	Unschedulable    bool
+}
+
+type Configuration struct {
+	Name                   string         `yaml:"Name"`
+	Namespace              string         `yaml:"Namespace" json:",omitempty"`
+	Amount                 int            `yaml:"Amount"`
+	Image                  string         `yaml:"Image"`
+	TerminationGracePeriod int            `yaml:"TerminationGracePeriod" json:",omitempty"`
+	PreStop                *PreStopHook   `yaml:"PreStop" json:",omitempty"`
+	Volumes                []VolumeMount  `yaml:"Volumes" json:",omitempty"`
+	ConfigMaps             []ConfigMapRef `yaml:"ConfigMaps" json:",omitempty"`
+	Secrets                []SecretRef    `yaml:"Secrets" json:",omitempty"`
+	Resources              Resources      `yaml:"Resources"`
+}
+
+type PreStopHook struct {
+	Exec []string     `yaml:"Exec" json:",omitempty"`
+	HTTP *HTTPGetHook `yaml:"HTTP" json:",omitempty"`
+}
+
+type HTTPGetHook struct {
+	Path string `yaml:"Path"`
+	Port int    `yaml:"Port"`
+}
+
+type VolumeMount struct {
+	Type     string `yaml:"Type"`
+	Source   string `yaml:"Source" json:",omitempty"`
+	Target   string `yaml:"Target"`
+	ReadOnly bool   `yaml:"ReadOnly" json:",omitempty"`
+	Retain   bool   `yaml:"Retain" json:",omitempty"`
+	SizeMB   int    `yaml:"SizeMB" json:",omitempty"`
+}
+
+type Container struct {
+	Index             int
+	ConfigurationName string
+	Namespace         string
+	Image             string
+}
+
+func printAgentStatus(agents []Agent) {
+	if 0 == len(agents) {
+		fmt.Printf("No agents in system")
+		return
+	}
+
+	for _, agent := range agents {
+		fmt.Printf("Agent %s on port: %d is %s %s\n", agent.ID, agent.Port, agentState(agent), agent.Message)
+	}
+}
+
+func agentState(agent Agent) string {
+	if agent.Unschedulable {
+		return agent.State + ", cordoned"
+	}
+	if agent.State != "" {
+		return agent.State
+	}
+	if agent.Active {
+		return "active"
+	}
+	return "not active"
+}
+
+// printConfigurationWithAgentDivision prints the configuration and a row per replica with the agent running it
+func printConfigurationWithAgentDivision(configurationAgent ConfigurationAgent, replicas []ReplicaStatus) {
+	fmt.Printf("Configuration name: %s \n", configurationAgent.Configuration.Name)
+	fmt.Printf("Namespace %s\n", configurationAgent.Configuration.Namespace)
+	fmt.Printf("Image %s\n", configurationAgent.Configuration.Image)
+	fmt.Printf("Amount %d\n", configurationAgent.Configuration.Amount)
+	fmt.Println()
+
+	printReplicaTable(replicas, false)
+}
+
+func printAllConfigurationStatus(configurationArray []Configuration) {
+	if 0 == len(configurationArray) {
+		fmt.Println("No configuration in the system")
+		return
+
+	}
+
+	for _, configuration := range configurationArray {
+		fmt.Printf("configuration name: %s, amount: %d , image: %s \n",
+			qualifiedName(configuration.Namespace, configuration.Name), configuration.Amount, configuration.Image)
+	}
+}
+
+// configurationURL is the path of the configuration, the requests add the namespace to their query
+func configurationURL(name string) string {
+	return fmt.Sprintf("%s/configurations/%s", apiURL, url.PathEscape(name))
+}
+
+func (c *Configuration) getContentFromYAML(fileName string) *Configuration {
+	yamlFile, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		log.Fatalf("yamlFile.Get err   #%v ", err)
+	}
+
+	err = yaml.Unmarshal(yamlFile, c)
+	if err != nil {
+		log.Fatalf("Unmarshal: %v", err)
+	}
+
+	return c
+}
+
+func envStatus() {
+	rb := newRequestBuilder()
+	rb.DisableTimeout = true
+
+	resp := rb.Get(apiURL + "/configurations?" + listQuery().Encode())
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	if resp.Response.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var configurationArray []Configuration
+	err := resp.FillUp(&configurationArray)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
+	}
+
+	printAllConfigurationStatus(configurationArray)
+
+	resp.Body.Close()
+}
+
+func create(Info Configuration, options operationOptions) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	configurationNamespace(&Info)
+	query := url.Values{"namespace": []string{Info.Namespace}}
+	resp := rb.Post(apiURL+"/configurations?"+query.Encode(), Info)
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	operationRespond(resp, options)
+}
+
+func delete(name string, options operationOptions) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	resp := rb.Delete(configurationURL(name) + "?" + namespaceQuery().Encode())
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	operationRespond(resp, options)
+}
+
+func update(Info Configuration, options operationOptions) {
+	rb := newRequestBuilder()
+	rb.Timeout = 30 * time.Second
+
+	configurationNamespace(&Info)
+	query := url.Values{"namespace": []string{Info.Namespace}}
+	// like the update route it replaces, a configuration that doesn't exist isn't created
+	rb.Headers.Set("If-Match", "*")
+	resp := rb.Put(configurationURL(Info.Name)+"?"+query.Encode(), Info)
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	operationRespond(resp, options)
+}
+
+func envNameStatus(name string) {
+	rb := newRequestBuilder()
+	rb.DisableTimeout = true
+
+	resp := rb.Get(configurationURL(name) + "?" + namespaceQuery().Encode())
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	if resp.Response.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var configurationAgent ConfigurationAgent
+	err := resp.FillUp(&configurationAgent)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
+	}
+
+	resp.Body.Close()
+
+	replicas := fetchReplicaStatuses(configurationURL(name) + "/stats?" + namespaceQuery().Encode())
+	printConfigurationWithAgentDivision(configurationAgent, replicas)
+}
+
+func agentsStatus() {
+	rb := newRequestBuilder()
+	rb.DisableTimeout = true
+
+	resp := rb.Get(apiURL + "/agents")
+	if resp.Err != nil {
+		fmt.Println(resp.Err)
+		os.Exit(1)
+	}
+
+	if resp.Response.StatusCode != http.StatusOK {
+		exitWithAPIError(resp.StatusCode, resp.Bytes())
+	}
+
+	var agents []Agent
+	err := resp.FillUp(&agents)
+	if err != nil {
+		log.Println(fmt.Sprintf("Json fill up failed. Error: %s", err.Error()))
+	}
+	printAgentStatus(agents)
+
+	resp.Body.Close()
+}
+
+func printHelp() {
+	fmt.Println("Please enter valid request, you are only allowed the commands below:")
+	fmt.Println("every command takes [--context <Name>] [-n|--namespace <Namespace>], the status, get and top commands also [-A|--all-namespaces]")
+	fmt.Println("create <YAML file path> [--wait] [--timeout D]")
+	fmt.Println("delete <Name> [--wait] [--timeout D]")
+	fmt.Println("update <YAML file path> [--wait] [--timeout D]")
+	fmt.Println("operation <ID> [--wait] [--timeout D]")
+	fmt.Println("Show env status")
+	fmt.Println("Show env <Name> status")
+	fmt.Println("Show agent status")
+	fmt.Println("top [Name] [--sort cpu|memory]")
+	fmt.Println("logs <Name> [--replica N] [-f] [--tail N] [--since T] [--timestamps]")
+	fmt.Println("exec <Name> [--replica N] [-t] -- <command>")
+	fmt.Println("get <configurations|containers|agents|all> [Name] [--watch]")
+	fmt.Println("create configmap <Name> --from-file [key=]<path> ... [--namespaces ns1,ns2]")
+	fmt.Println("delete configmap <Name>")
+	fmt.Println("get configmaps [Name]")
+	fmt.Println("create secret <Name> [--from-file [key=]<path>] [--from-literal key=value] ... [--namespaces ns1,ns2]")
+	fmt.Println("delete secret <Name>")
+	fmt.Println("get secrets [Name]")
+	fmt.Println("show quota")
+	fmt.Println("set quota [--configurations N] [--replicas N] [--cpu MILLIS] [--memory MB]")
+	fmt.Println("show certificates")
+	fmt.Println("rotate certificates")
+	fmt.Println("audit [--since T] [--until T] [--user U] [--configuration Name] [--kind K] [--limit N] [--json]")
+	fmt.Println("agents pool")
+	fmt.Println("agents scale <N>")
+	fmt.Println("agent <cordon|uncordon> <ID>")
+	fmt.Println("agent drain <ID> [--max-unavailable N] [--wait] [--timeout D]")
+}
+
+func doAction(params []string) {
+	if len(params) >= 2 && params[0] == "logs" {
+		logs(params[1], params[2:])
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "exec" {
+		execInContainer(params[1], params[2:])
+		return
+	}
+
+	if len(params) >= 3 && params[0] == "agent" {
+		agent(params[1], params[2], params[3:])
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "agents" {
+		agents(params[1:])
+		return
+	}
+
+	if len(params) == 2 && params[0] == "show" && params[1] == "quota" {
+		showQuota()
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "set" && params[1] == "quota" {
+		setQuota(params[2:])
+		return
+	}
+
+	if len(params) == 2 && params[0] == "show" && params[1] == "certificates" {
+		showCertificates()
+		return
+	}
+
+	if len(params) == 2 && params[0] == "rotate" && params[1] == "certificates" {
+		rotateCertificates()
+		return
+	}
+
+	if len(params) >= 1 && params[0] == "top" {
+		top(params[1:])
+		return
+	}
+
+	if len(params) >= 1 && params[0] == "audit" {
+		audit(params[1:])
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "get" && (params[1] == "configmaps" || params[1] == "configmap") {
+		getConfigMaps(params[2:])
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "get" && (params[1] == "secrets" || params[1] == "secret") {
+		getSecrets(params[2:])
+		return
+	}
+
+	if len(params) >= 3 && params[0] == "create" && params[1] == "secret" {
+		createSecret(params[2], params[3:])
+		return
+	}
+
+	if len(params) == 3 && params[0] == "delete" && params[1] == "secret" {
+		deleteSecret(params[2])
+		return
+	}
+
+	if len(params) >= 3 && params[0] == "create" && params[1] == "configmap" {
+		createConfigMap(params[2], params[3:])
+		return
+	}
+
+	if len(params) == 3 && params[0] == "delete" && params[1] == "configmap" {
+		deleteConfigMap(params[2])
+		return
+	}
+
+	if len(params) >= 2 && params[0] == "get" {
+		get(params[1:])
+		return
+	}
+
+	if len(params) >= 2 {
+		switch params[0] {
+		case "create":
+			yamlStruct := Configuration{}
+			yamlStruct.getContentFromYAML(params[1])
+			create(yamlStruct, parseOperationFlags("create", params[2:]))
+			return
+
+		case "delete":
+			delete(params[1], parseOperationFlags("delete", params[2:]))
+			return
+
+		case "update":
+			yamlStruct := Configuration{}
+			yamlStruct.getContentFromYAML(params[1])
+			update(yamlStruct, parseOperationFlags("update", params[2:]))
+			return
+
+		case "operation":
+			showOperation(params[1], params[2:])
+			return
+		}
+	}
+
+	if len(params) == 3 && params[0] == "Show" && params[2] == "status" {
+		if params[1] == "env" {
+			envStatus()
+			return
+		}
+		if params[1] == "agent" {
+			agentsStatus()
+			return
+		}
+	}
+
+	if len(params) == 4 && params[0] == "Show" && params[1] == "env" && params[3] == "status" {
+		envNameStatus(params[2])
+		return
+	}
+
+	printHelp()
+}
+
+func main() {
+	argsWithoutProg := parseGlobalFlags(os.Args[1:])
+	doAction(argsWithoutProg)
+}
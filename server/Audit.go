@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const DEFAULT_AUDIT_LOG = "audit.log"
+
+// a query answers at most this many entries, the newest ones, unless it asks for another limit
+const DEFAULT_AUDIT_QUERY_LIMIT = 1000
+
+// what an audit entry is about
+const (
+	AUDIT_KIND_CONFIGURATION = "Configuration"
+	AUDIT_KIND_CONFIGMAP     = "ConfigMap"
+	AUDIT_KIND_SECRET        = "Secret"
+	AUDIT_KIND_QUOTA         = "Quota"
+	AUDIT_KIND_AGENT         = "Agent"
+	AUDIT_KIND_AGENT_POOL    = "AgentPool"
+	AUDIT_KIND_CERTIFICATES  = "Certificates"
+)
+
+const (
+	AUDIT_SUCCEEDED = "Succeeded"
+	AUDIT_FAILED    = "Failed"
+)
+
+// AuditEntry is a line of the audit log, written once the outcome of the request is known,
+// for a configuration that is when its operation ends
+type AuditEntry struct {
+	Time time.Time
+	// empty when the request had no valid token
+	User      string `json:",omitempty"`
+	Method    string
+	Endpoint  string
+	Kind      string
+	Namespace string `json:",omitempty"`
+	Name      string `json:",omitempty"`
+	// the replica and the command of an exec session
+	Replica     int      `json:",omitempty"`
+	Command     []string `json:",omitempty"`
+	OperationID string   `json:",omitempty"`
+	// the server log has the same one
+	RequestID string `json:",omitempty"`
+	// the spec before and after the request, a secret only shows its keys
+	Before  json.RawMessage `json:",omitempty"`
+	After   json.RawMessage `json:",omitempty"`
+	Outcome string
+	Status  int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
+
+	// set when an operation writes the entry instead of audited
+	deferred bool
+}
+
+// set from the command line, see main, auditing is off when it is empty
+var auditLogFile = DEFAULT_AUDIT_LOG
+
+// auditLog is only appended to, auditLock keeps the lines whole
+var auditLock sync.Mutex
+var auditLog *os.File
+
+type auditKey struct{}
+
+func openAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	auditLock.Lock()
+	auditLog = file
+	auditLock.Unlock()
+	return nil
+}
+
+func writeAudit(entry *AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		serverLog.errorf("audit entry not written: %s", err)
+		return
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+	if auditLog == nil {
+		return
+	}
+	if _, err := auditLog.Write(append(line, '\n')); err != nil {
+		serverLog.errorf("audit log write failed: %s", err)
+	}
+}
+
+// snapshot is the spec kept in the audit entry, nil when there is none
+func snapshot(spec interface{}) json.RawMessage {
+	if spec == nil {
+		return nil
+	}
+	specJSON, _ := json.Marshal(spec)
+	return specJSON
+}
+
+// configurationSnapshot is the configuration as it is now, nil when it doesn't exist
+func configurationSnapshot(key string) json.RawMessage {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	if configurationAgent, ok := mapConfigurationToAgents[key]; ok {
+		return snapshot(configurationAgent.Configuration)
+	}
+	return nil
+}
+
+// auditOf is the audit entry of a request that went through audited, nil otherwise,
+// the entry methods accept nil
+func auditOf(r *http.Request) *AuditEntry {
+	entry, _ := r.Context().Value(auditKey{}).(*AuditEntry)
+	return entry
+}
+
+func (entry *AuditEntry) target(namespace string, name string) {
+	if entry == nil {
+		return
+	}
+	entry.Namespace = namespace
+	entry.Name = name
+}
+
+func (entry *AuditEntry) recordBefore(spec json.RawMessage) {
+	if entry != nil {
+		entry.Before = spec
+	}
+}
+
+func (entry *AuditEntry) recordAfter(spec json.RawMessage) {
+	if entry != nil {
+		entry.After = spec
+	}
+}
+
+func (entry *AuditEntry) execIn(replica int, command []string) {
+	if entry != nil {
+		entry.Replica = replica
+		entry.Command = command
+	}
+}
+
+// sessionStarted writes the entry of an exec session once the client is connected, not when it ends
+func (entry *AuditEntry) sessionStarted() {
+	if entry == nil {
+		return
+	}
+	entry.deferred = true
+	entry.Outcome = AUDIT_SUCCEEDED
+	entry.Status = http.StatusSwitchingProtocols
+	writeAudit(entry)
+}
+
+// deferTo leaves the entry to the operation, it is written by operationDone
+func (entry *AuditEntry) deferTo(operation *Operation) {
+	if entry == nil {
+		return
+	}
+	entry.deferred = true
+	entry.OperationID = operation.ID
+}
+
+func (entry *AuditEntry) operationDone(apiError *APIError) {
+	if entry == nil {
+		return
+	}
+	entry.Outcome = AUDIT_SUCCEEDED
+	if apiError != nil {
+		entry.Outcome = AUDIT_FAILED
+		entry.Status = apiError.Status
+		entry.Error = apiError.Message
+	}
+	writeAudit(entry)
+}
+
+// statusRecorder keeps the status of the answer and the body of an error
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets the websocket of an exec session through
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (recorder *statusRecorder) Write(content []byte) (int, error) {
+	if recorder.status >= http.StatusBadRequest {
+		recorder.body.Write(content)
+	}
+	return recorder.ResponseWriter.Write(content)
+}
+
+// audited writes an audit entry for the request once the handler answered, or leaves it to the
+// operation the handler started, it wraps allow so the refused requests are audited too
+func audited(kind string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(responseHTTP http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		entry := &AuditEntry{
+			Time:      time.Now().UTC(),
+			Method:    r.Method,
+			Endpoint:  r.URL.Path,
+			Kind:      kind,
+			Namespace: vars["namespace"],
+			Name:      vars["name"],
+			RequestID: requestIDOf(r),
+		}
+		if id, ok := vars["id"]; ok {
+			entry.Name = id
+		}
+		// the handler corrects it once it read the body, a refused request keeps this one
+		if kind == AUDIT_KIND_CONFIGURATION {
+			entry.Namespace = r.URL.Query().Get("namespace")
+			if entry.Namespace == "" {
+				entry.Namespace = DEFAULT_NAMESPACE
+			}
+		}
+		if token, apiError := authenticate(r); apiError == nil {
+			entry.User = token.Name
+		}
+
+		recorder := &statusRecorder{ResponseWriter: responseHTTP, status: http.StatusOK}
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+		if entry.deferred {
+			return
+		}
+
+		entry.Status = recorder.status
+		entry.Outcome = AUDIT_SUCCEEDED
+		if recorder.status >= http.StatusBadRequest {
+			entry.Outcome = AUDIT_FAILED
+			var apiError APIError
+			if err := json.Unmarshal(recorder.body.Bytes(), &apiError); err == nil {
+				entry.Error = apiError.Message
+			}
+		}
+		writeAudit(entry)
+	}
+}
+
+// parseAuditTime takes a timestamp or a duration before now, like 2h
+func parseAuditTime(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().UTC().Add(-duration), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// auditFilter is the query of auditEndPoint, an empty field matches everything
+type auditFilter struct {
+	since         time.Time
+	until         time.Time
+	user          string
+	kind          string
+	namespace     string
+	configuration string
+}
+
+func (filter *auditFilter) matches(entry *AuditEntry) bool {
+	switch {
+	case !filter.since.IsZero() && entry.Time.Before(filter.since):
+		return false
+	case !filter.until.IsZero() && entry.Time.After(filter.until):
+		return false
+	case filter.user != "" && entry.User != filter.user:
+		return false
+	case filter.kind != "" && entry.Kind != filter.kind:
+		return false
+	case filter.namespace != "" && entry.Namespace != filter.namespace:
+		return false
+	case filter.configuration != "" && (entry.Kind != AUDIT_KIND_CONFIGURATION || entry.Name != filter.configuration):
+		return false
+	}
+	return true
+}
+
+// auditEndPoint reads the audit log back, the newest matching entries last
+func auditEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	if auditLogFile == "" {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, "the audit log is off"))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := auditFilter{
+		user:          query.Get("user"),
+		kind:          query.Get("kind"),
+		namespace:     query.Get("namespace"),
+		configuration: query.Get("configuration"),
+	}
+	var err error
+	if value := query.Get("since"); value != "" {
+		if filter.since, err = parseAuditTime(value); err != nil {
+			respondWithError(responseHTTP, invalidRequestError(fmt.Sprintf("since: %s", err)))
+			return
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.until, err = parseAuditTime(value); err != nil {
+			respondWithError(responseHTTP, invalidRequestError(fmt.Sprintf("until: %s", err)))
+			return
+		}
+	}
+	limit := DEFAULT_AUDIT_QUERY_LIMIT
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			respondWithError(responseHTTP, invalidRequestError("limit must be a positive number"))
+			return
+		}
+	}
+
+	file, err := os.Open(auditLogFile)
+	if err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, err.Error()))
+		return
+	}
+	defer file.Close()
+
+	// a line being appended right now isn't whole yet, it doesn't parse and is left out
+	entries := make([]AuditEntry, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry AuditEntry
+		if len(line) != 0 && json.Unmarshal(line, &entry) == nil && filter.matches(&entry) {
+			entries = append(entries, entry)
+			if len(entries) > limit {
+				entries = entries[1:]
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, entries)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func useAuditLog(t *testing.T) {
+	auditLogFile = filepath.Join(t.TempDir(), "audit.log")
+	if err := openAuditLog(auditLogFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		auditLock.Lock()
+		auditLog.Close()
+		auditLog = nil
+		auditLock.Unlock()
+		auditLogFile = DEFAULT_AUDIT_LOG
+	})
+}
+
+func queryAudit(t *testing.T, auditURL string) []AuditEntry {
+	status, body := doRequestWithToken(t, "admin-token", http.MethodGet, auditURL, nil)
+	if status != http.StatusOK {
+		t.Fatalf("audit query: status %d %s", status, body)
+	}
+	var entries []AuditEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	useAuditLog(t)
+	server := newTestServer(t, newFakeAgent(t))
+	useTokens(t, []APIToken{
+		{Name: "ops", Token: "admin-token", Role: ROLE_ADMIN},
+		{Name: "dashboard", Token: "viewer-token", Role: ROLE_VIEWER},
+	}, "")
+	testToken = "admin-token"
+	t.Cleanup(func() { testToken = "" })
+	api := server.URL + API_V1_PREFIX
+
+	status, body := doRequestWithToken(t, "admin-token", http.MethodPost, api+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	status, body = doRequestWithToken(t, "admin-token", http.MethodPut, api+"/configurations/web", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("update: %+v", operation)
+	}
+	if status, _ := doRequestWithToken(t, "viewer-token", http.MethodDelete, api+"/configurations/web", nil); status != http.StatusForbidden {
+		t.Fatalf("delete by a viewer: status %d", status)
+	}
+	secret := Secret{Name: "db", Data: map[string]string{"PASSWORD": "hunter2"}}
+	if status, body := doRequestWithToken(t, "admin-token", http.MethodPost, api+"/secrets", secret); status != http.StatusCreated {
+		t.Fatalf("create secret: status %d %s", status, body)
+	}
+
+	entries := queryAudit(t, api+"/audit")
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %+v", entries)
+	}
+
+	created, updated, refused, secretCreated := entries[0], entries[1], entries[2], entries[3]
+	if created.User != "ops" || created.Kind != AUDIT_KIND_CONFIGURATION || created.Name != "web" || created.Namespace != DEFAULT_NAMESPACE ||
+		created.Outcome != AUDIT_SUCCEEDED || created.OperationID == "" || created.Before != nil || created.After == nil {
+		t.Fatalf("create entry: %+v", created)
+	}
+	var before, after Configuration
+	json.Unmarshal(updated.Before, &before)
+	json.Unmarshal(updated.After, &after)
+	if updated.Method != http.MethodPut || before.Amount != 1 || after.Amount != 2 {
+		t.Fatalf("update entry: %+v", updated)
+	}
+	if refused.User != "dashboard" || refused.Outcome != AUDIT_FAILED || refused.Status != http.StatusForbidden || refused.Error == "" {
+		t.Fatalf("refused delete entry: %+v", refused)
+	}
+	if secretCreated.Kind != AUDIT_KIND_SECRET || string(secretCreated.After) != `{"Name":"db","Keys":["PASSWORD"]}` {
+		t.Fatalf("secret entry: %+v", secretCreated)
+	}
+
+	// the filters
+	if entries := queryAudit(t, api+"/audit?user=dashboard"); len(entries) != 1 || entries[0].Status != http.StatusForbidden {
+		t.Fatalf("by user: %+v", entries)
+	}
+	if entries := queryAudit(t, api+"/audit?configuration=web&namespace="+DEFAULT_NAMESPACE); len(entries) != 3 {
+		t.Fatalf("by configuration: %+v", entries)
+	}
+	if entries := queryAudit(t, api+"/audit?since=1h&limit=1"); len(entries) != 1 || entries[0].Kind != AUDIT_KIND_SECRET {
+		t.Fatalf("newest entry: %+v", entries)
+	}
+	if entries := queryAudit(t, api+"/audit?until=2000-01-01T00:00:00Z"); len(entries) != 0 {
+		t.Fatalf("until: %+v", entries)
+	}
+	if status, _ := doRequestWithToken(t, "viewer-token", http.MethodGet, api+"/audit", nil); status != http.StatusForbidden {
+		t.Fatalf("audit query by a viewer: status %d", status)
+	}
+}
+
+func TestExecSessionsAreAudited(t *testing.T) {
+	useAuditLog(t)
+	server := newTestServer(t, newFakeAgent(t))
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	useTokens(t, []APIToken{{Name: "ops", Token: "admin-token", Role: ROLE_ADMIN}}, "")
+	testToken = "admin-token"
+	t.Cleanup(func() { testToken = "" })
+
+	// the entry is written when the session starts, the session is still open
+	conn, status, message := dialExec(t, server.URL, API_V1_PREFIX+"/configurations/web/exec?replica=2&cmd=cat&cmd=/etc/passwd")
+	if conn == nil {
+		t.Fatalf("exec: status %d %s", status, message)
+	}
+	if conn, status, _ := dialExec(t, server.URL, "/exec/web?replica=3&cmd=sh"); conn != nil || status != http.StatusNotFound {
+		t.Fatalf("exec of an unknown replica: status %d", status)
+	}
+
+	entries := queryAudit(t, server.URL+API_V1_PREFIX+"/audit?configuration=web")
+	if len(entries) != 3 {
+		t.Fatalf("expected the create and the two exec entries, got %+v", entries)
+	}
+	session, refused := entries[1], entries[2]
+	if session.User != "ops" || session.Replica != 2 || len(session.Command) != 2 || session.Command[1] != "/etc/passwd" ||
+		session.Outcome != AUDIT_SUCCEEDED || session.Status != http.StatusSwitchingProtocols {
+		t.Fatalf("exec entry: %+v", session)
+	}
+	if refused.Endpoint != "/exec/web" || refused.Replica != 3 || refused.Outcome != AUDIT_FAILED || refused.Status != http.StatusNotFound {
+		t.Fatalf("refused exec entry: %+v", refused)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ROLE_VIEWER = "viewer"
+	ROLE_EDITOR = "editor"
+	ROLE_ADMIN  = "admin"
+)
+
+const DEFAULT_TOKENS_FILE = "tokens.json"
+const DEFAULT_BOOTSTRAP_TOKEN_FILE = "bootstrap.token"
+
+// the agents the server starts get the bootstrap token from this env var
+const ENV_BOOTSTRAP_TOKEN = "MINIKUBE_BOOTSTRAP_TOKEN"
+
+// a role may do everything the roles below it may
+var roleRank = map[string]int{ROLE_VIEWER: 1, ROLE_EDITOR: 2, ROLE_ADMIN: 3}
+
+// APIToken is a credential of a CLI user, sent as "Authorization: Bearer <Token>"
+type APIToken struct {
+	Name  string
+	Token string
+	Role  string
+	// Namespaces limits the token to the configurations of these namespaces, every namespace when empty
+	Namespaces []string `json:",omitempty"`
+}
+
+// set from the command line, see main, authentication is off when tokensFile is empty
+var tokensFile = DEFAULT_TOKENS_FILE
+var bootstrapTokenFile = DEFAULT_BOOTSTRAP_TOKEN_FILE
+
+// apiTokens is nil when authentication is off, it is only written before the server starts
+var apiTokens []APIToken
+
+// bootstrapToken proves a request comes from an agent, anyone may register when it is empty
+var bootstrapToken string
+
+type callerKey struct{}
+
+// anonymous is the caller of every request while authentication is off
+var anonymous = &APIToken{Name: "anonymous", Role: ROLE_ADMIN}
+
+func newToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// loadTokens reads the tokens file, on the first start it is created with a single admin token
+func loadTokens(path string) ([]APIToken, error) {
+	tokens := make([]APIToken, 0)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, APIToken{Name: "admin", Token: token, Role: ROLE_ADMIN})
+		content, _ := json.MarshalIndent(tokens, "", " ")
+		serverLog.infof("tokens file %s created with an admin token, put it in the CLI config file", path)
+		return tokens, ioutil.WriteFile(path, content, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, token := range tokens {
+		if token.Name == "" || token.Token == "" {
+			return nil, fmt.Errorf("%s: every token needs a Name and a Token", path)
+		}
+		if _, ok := roleRank[token.Role]; !ok {
+			return nil, fmt.Errorf("%s: token %s has unknown role %q", path, token.Name, token.Role)
+		}
+	}
+	return tokens, nil
+}
+
+// loadBootstrapToken reads the bootstrap token file, a missing one is created with a new random token
+func loadBootstrapToken(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		token, err := newToken()
+		if err != nil {
+			return "", err
+		}
+		serverLog.infof("bootstrap token file %s created", path)
+		return token, ioutil.WriteFile(path, []byte(token+"\n"), 0600)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func tokensEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func unauthorizedError(message string) *APIError {
+	return newAPIError(http.StatusUnauthorized, ERROR_UNAUTHORIZED, message)
+}
+
+func forbiddenError(message string) *APIError {
+	return newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, message)
+}
+
+// authenticate finds the token the request was sent with
+func authenticate(r *http.Request) (*APIToken, *APIError) {
+	if apiTokens == nil {
+		return anonymous, nil
+	}
+
+	sent := bearerToken(r)
+	if sent == "" {
+		return nil, unauthorizedError("the request has no bearer token")
+	}
+	for i := range apiTokens {
+		if tokensEqual(apiTokens[i].Token, sent) {
+			return &apiTokens[i], nil
+		}
+	}
+	return nil, unauthorizedError("unknown token")
+}
+
+// caller is the token of a request that went through allow, anonymous otherwise
+func caller(r *http.Request) *APIToken {
+	if token, ok := r.Context().Value(callerKey{}).(*APIToken); ok {
+		return token
+	}
+	return anonymous
+}
+
+func (token *APIToken) canAccess(namespace string) bool {
+	if len(token.Namespaces) == 0 {
+		return true
+	}
+	for _, allowed := range token.Namespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func respondUnauthorized(responseHTTP http.ResponseWriter, apiError *APIError) {
+	if apiError.Status == http.StatusUnauthorized {
+		responseHTTP.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	respondWithError(responseHTTP, apiError)
+}
+
+// allow lets the handler run for tokens with at least the role, namespaced handlers check the
+// namespace of the request themselves through requestNamespace
+func allow(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(responseHTTP http.ResponseWriter, r *http.Request) {
+		token, apiError := authenticate(r)
+		if apiError == nil && roleRank[token.Role] < roleRank[role] {
+			apiError = forbiddenError(fmt.Sprintf("%s is a %s, this needs %s", token.Name, token.Role, role))
+		}
+		if apiError != nil {
+			respondUnauthorized(responseHTTP, apiError)
+			return
+		}
+		handler(responseHTTP, r.WithContext(context.WithValue(r.Context(), callerKey{}, token)))
+	}
+}
+
+// allowCluster is allow for the resources shared by every namespace, a token limited to
+// namespaces can't change them
+func allowCluster(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return allow(role, func(responseHTTP http.ResponseWriter, r *http.Request) {
+		if token := caller(r); len(token.Namespaces) != 0 {
+			respondWithError(responseHTTP, forbiddenError(fmt.Sprintf("%s is limited to namespaces %s", token.Name, strings.Join(token.Namespaces, ", "))))
+			return
+		}
+		handler(responseHTTP, r)
+	})
+}
+
+// agentOnly lets only the agents, which have the bootstrap token or an agent certificate, call the handler
+func agentOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(responseHTTP http.ResponseWriter, r *http.Request) {
+		if bootstrapToken != "" && agentFromCertificate(r) == "" && !tokensEqual(bearerToken(r), bootstrapToken) {
+			respondUnauthorized(responseHTTP, unauthorizedError("agents have to send the bootstrap token"))
+			return
+		}
+		handler(responseHTTP, r)
+	}
+}
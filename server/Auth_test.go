@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func useTokens(t *testing.T, tokens []APIToken, bootstrap string) {
+	apiTokens, bootstrapToken = tokens, bootstrap
+	t.Cleanup(func() {
+		apiTokens, bootstrapToken = nil, ""
+	})
+}
+
+func TestRolesAndNamespaces(t *testing.T) {
+	server := newTestServer(t, newFakeAgent(t))
+	useTokens(t, []APIToken{
+		{Name: "ops", Token: "admin-token", Role: ROLE_ADMIN},
+		{Name: "dashboard", Token: "viewer-token", Role: ROLE_VIEWER},
+		{Name: "team-a", Token: "team-a-token", Role: ROLE_EDITOR, Namespaces: []string{"team-a"}},
+	}, "bootstrap-token")
+	api := server.URL + API_V1_PREFIX
+
+	cases := []struct {
+		name    string
+		token   string
+		method  string
+		url     string
+		payload interface{}
+		status  int
+	}{
+		{"no token", "", http.MethodGet, api + "/configurations", nil, http.StatusUnauthorized},
+		{"unknown token", "guess", http.MethodGet, api + "/configurations", nil, http.StatusUnauthorized},
+		{"viewer reads", "viewer-token", http.MethodGet, api + "/configurations", nil, http.StatusOK},
+		{"viewer can't create", "viewer-token", http.MethodPost, api + "/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"}, http.StatusForbidden},
+		{"editor creates in its namespace", "team-a-token", http.MethodPost, api + "/configurations", Configuration{Name: "web", Namespace: "team-a", Amount: 1, Image: "alpine"}, http.StatusAccepted},
+		{"editor can't create elsewhere", "team-a-token", http.MethodPost, api + "/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"}, http.StatusForbidden},
+		{"editor can't read elsewhere", "team-a-token", http.MethodGet, api + "/configurations?namespace=team-b", nil, http.StatusForbidden},
+		{"namespaced token can't change config maps", "team-a-token", http.MethodPost, api + "/configmaps", ConfigMap{Name: "settings", Data: map[string]string{"a": "b"}}, http.StatusForbidden},
+		{"editor can't set quotas", "team-a-token", http.MethodPut, api + "/namespaces/team-a/quota", Quota{MaxReplicas: 1}, http.StatusForbidden},
+		{"admin sets quotas", "admin-token", http.MethodPut, api + "/namespaces/team-a/quota", Quota{MaxReplicas: 5}, http.StatusOK},
+		{"user token can't register agents", "admin-token", http.MethodPost, server.URL + "/agentPort", Agent{ID: "intruder", Port: 1}, http.StatusUnauthorized},
+		{"legacy routes need a token too", "", http.MethodGet, server.URL + "/envStatus", nil, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if status, body := doRequestWithToken(t, c.token, c.method, c.url, c.payload); status != c.status {
+			t.Errorf("%s: expected status %d, got %d %s", c.name, c.status, status, body)
+		}
+	}
+}
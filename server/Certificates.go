@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+const DEFAULT_PKI_DIR = "pki"
+const CA_CERT_FILE = "ca.crt"
+const CA_KEY_FILE = "ca.key"
+const SERVER_CERT_FILE = "server.crt"
+const SERVER_KEY_FILE = "server.key"
+
+const CA_VALIDITY = 10 * 365 * 24 * time.Hour
+const DEFAULT_CERT_VALIDITY = 30 * 24 * time.Hour
+const DEFAULT_TLS_HOSTS = "localhost,127.0.0.1"
+
+// the agents only take requests from a certificate with this name, the server only takes
+// agent certificates named AGENT_COMMON_NAME_PREFIX + the agent ID
+const SERVER_COMMON_NAME = "minikube-server"
+const AGENT_COMMON_NAME_PREFIX = "agent:"
+
+// the agents the server starts find the CA certificate through this env var
+const ENV_CA_FILE = "MINIKUBE_CA_FILE"
+
+// set from the command line, see main, TLS is off when pkiDir is empty
+var pkiDir = DEFAULT_PKI_DIR
+var certValidity = DEFAULT_CERT_VALIDITY
+var tlsHosts = DEFAULT_TLS_HOSTS
+
+// certificateAuthority signs the certificates of the server and of the agents
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+}
+
+// ca is nil while TLS is off, it is only written before the server starts
+var ca *certificateAuthority
+
+// serverCertificate holds the *tls.Certificate in use, a rotation swaps it under the open listener
+var serverCertificate atomic.Value
+
+// pendingRenewals holds the agents a rotation asked for a new certificate, they are told with their
+// next heartbeat and leave it once the new certificate is issued
+var rotationLock sync.Mutex
+var rotatedAt time.Time
+var pendingRenewals = make(map[string]bool)
+
+// the calls to the agents go through them, over mutual TLS once enableTLS ran
+var agentTransport http.RoundTripper = http.DefaultTransport
+var agentDialer = websocket.DefaultDialer
+
+// CertificateRequest is sent by an agent for its first certificate and to renew it
+type CertificateRequest struct {
+	ID  string
+	CSR string
+}
+
+type CertificateResponse struct {
+	Certificate string
+	CA          string
+}
+
+// HeartbeatResponse asks an agent to renew its certificate after a rotation, other heartbeats are answered 204
+type HeartbeatResponse struct {
+	RenewCertificate bool
+}
+
+// CertificatesStatus is shown and returned by a rotation
+type CertificatesStatus struct {
+	CAExpires     time.Time
+	ServerExpires time.Time
+	RotatedAt     *time.Time `json:",omitempty"`
+}
+
+func newPrivateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodePEM(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func readPEM(path string, blockType string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+func newCertificateAuthority(certificate *x509.Certificate, key *ecdsa.PrivateKey) *certificateAuthority {
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &certificateAuthority{certificate: certificate, key: key, pool: pool}
+}
+
+// loadCA reads the CA of the directory, on the first start it is created
+func loadCA(dir string) (*certificateAuthority, error) {
+	certificateDER, err := readPEM(filepath.Join(dir, CA_CERT_FILE), "CERTIFICATE")
+	if os.IsNotExist(err) {
+		return createCA(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := readPEM(filepath.Join(dir, CA_KEY_FILE), "EC PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+	return newCertificateAuthority(certificate, key), nil
+}
+
+func createCA(dir string) (*certificateAuthority, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	key, err := newPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "minikube-ca"},
+		NotBefore:             now,
+		NotAfter:              now.Add(CA_VALIDITY),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, CA_KEY_FILE), encodePEM("EC PRIVATE KEY", keyDER), 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, CA_CERT_FILE), encodePEM("CERTIFICATE", der), 0644); err != nil {
+		return nil, err
+	}
+	serverLog.infof("certificate authority created in %s", dir)
+	return newCertificateAuthority(certificate, key), nil
+}
+
+// issue signs a certificate for the public key, good for both ends of a TLS connection
+func (authority *certificateAuthority) issue(commonName string, publicKey interface{}, hosts []string) (*x509.Certificate, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, authority.certificate, publicKey, authority.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// needsRenewal is true once two thirds of the lifetime of the certificate passed
+func needsRenewal(certificate *x509.Certificate) bool {
+	lifetime := certificate.NotAfter.Sub(certificate.NotBefore)
+	return time.Now().After(certificate.NotBefore.Add(lifetime * 2 / 3))
+}
+
+func renewalPending(agentID string) bool {
+	rotationLock.Lock()
+	defer rotationLock.Unlock()
+	return pendingRenewals[agentID]
+}
+
+func currentServerCertificate() *tls.Certificate {
+	return serverCertificate.Load().(*tls.Certificate)
+}
+
+// loadServerCertificate keeps the certificate of the pki directory while it is fresh and signed by the CA
+func loadServerCertificate() error {
+	certificate, err := tls.LoadX509KeyPair(filepath.Join(pkiDir, SERVER_CERT_FILE), filepath.Join(pkiDir, SERVER_KEY_FILE))
+	if err == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err == nil && !needsRenewal(leaf) && leaf.CheckSignatureFrom(ca.certificate) == nil {
+			certificate.Leaf = leaf
+			serverCertificate.Store(&certificate)
+			return nil
+		}
+	}
+	return issueServerCertificate()
+}
+
+func issueServerCertificate() error {
+	key, err := newPrivateKey()
+	if err != nil {
+		return err
+	}
+	leaf, err := ca.issue(SERVER_COMMON_NAME, &key.PublicKey, strings.Split(tlsHosts, ","))
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(pkiDir, SERVER_KEY_FILE), encodePEM("EC PRIVATE KEY", keyDER), 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkiDir, SERVER_CERT_FILE), encodePEM("CERTIFICATE", leaf.Raw), 0644); err != nil {
+		return err
+	}
+
+	certificate := &tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	serverCertificate.Store(certificate)
+	serverLog.infof("server certificate issued, valid until %s", leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// enableTLS loads the CA and the server certificate of pkiDir, from then on the server is served
+// over HTTPS and the calls to the agents go over mutual TLS
+func enableTLS() error {
+	authority, err := loadCA(pkiDir)
+	if err != nil {
+		return err
+	}
+	ca = authority
+	if err := loadServerCertificate(); err != nil {
+		return err
+	}
+
+	clientConfig := agentTLSConfig()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = clientConfig
+	agentTransport = transport
+	agentDialer = &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 45 * time.Second, TLSClientConfig: clientConfig}
+	return nil
+}
+
+// serverTLSConfig asks for a client certificate without requiring one, the agents send theirs
+// and the CLI users their token
+func serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return currentServerCertificate(), nil
+		},
+		ClientCAs:  ca.pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+}
+
+// agentTLSConfig is the client end of the calls to the agents, it presents the server certificate
+// and only talks to certificates the CA issued to agents
+func agentTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    ca.pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return currentServerCertificate(), nil
+		},
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if name := state.PeerCertificates[0].Subject.CommonName; !strings.HasPrefix(name, AGENT_COMMON_NAME_PREFIX) {
+				return fmt.Errorf("%s is not an agent certificate", name)
+			}
+			return nil
+		},
+	}
+}
+
+func agentBaseURL() string {
+	if ca != nil {
+		return TLS_BASE_URL
+	}
+	return BASE_URL
+}
+
+func agentWebsocketBaseURL() string {
+	if ca != nil {
+		return TLS_WEBSOCKET_BASE_URL
+	}
+	return WEBSOCKET_BASE_URL
+}
+
+func agentHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: agentTransport, Timeout: timeout}
+}
+
+func agentRequestBuilder(timeout time.Duration) *rest.RequestBuilder {
+	return &rest.RequestBuilder{Timeout: timeout, CustomPool: &rest.CustomPool{Transport: agentTransport}}
+}
+
+// agentFromCertificate is the ID of the agent whose certificate the request came with, empty without one
+func agentFromCertificate(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !strings.HasPrefix(name, AGENT_COMMON_NAME_PREFIX) {
+		return ""
+	}
+	return strings.TrimPrefix(name, AGENT_COMMON_NAME_PREFIX)
+}
+
+// checkAgentIdentity refuses an agent certificate speaking for another agent
+func checkAgentIdentity(r *http.Request, agentID string) *APIError {
+	if id := agentFromCertificate(r); id != "" && id != agentID {
+		return forbiddenError(fmt.Sprintf("the certificate of agent %s can't be used for agent %s", id, agentID))
+	}
+	return nil
+}
+
+func tlsOffError() *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, "the server runs without TLS")
+}
+
+// agentCertificateEndPoint signs the key of an agent, the agent proves itself with the bootstrap
+// token the first time and with its current certificate when it renews it
+func agentCertificateEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	if ca == nil {
+		respondWithError(responseHTTP, tlsOffError())
+		return
+	}
+
+	var request CertificateRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	if request.ID == "" {
+		respondWithError(responseHTTP, invalidRequestError("an agent asks for a certificate with its ID"))
+		return
+	}
+	if apiError := checkAgentIdentity(r, request.ID); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	// the bootstrap token alone only gets the certificate of a new agent, or of a lost one which runs no container
+	// anymore, the others renew theirs with the current one
+	if agentFromCertificate(r) == "" {
+		stateLock.RLock()
+		agent := agentByID(request.ID)
+		registered := agent != nil && agent.State != AGENT_LOST
+		stateLock.RUnlock()
+		if registered {
+			respondWithError(responseHTTP, forbiddenError(fmt.Sprintf("agent %s is registered, it renews its certificate with the current one", request.ID)))
+			return
+		}
+	}
+
+	block, _ := pem.Decode([]byte(request.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		respondWithError(responseHTTP, invalidRequestError("CSR has to be a PEM certificate request"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+
+	// the agents are called on localhost, see BASE_URL
+	leaf, err := ca.issue(AGENT_COMMON_NAME_PREFIX+request.ID, csr.PublicKey, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, err.Error()))
+		return
+	}
+	serverLog.infof("certificate issued to agent %s, valid until %s", request.ID, leaf.NotAfter.Format(time.RFC3339))
+
+	rotationLock.Lock()
+	delete(pendingRenewals, request.ID)
+	rotationLock.Unlock()
+
+	respondWithJSON(responseHTTP, http.StatusOK, CertificateResponse{
+		Certificate: string(encodePEM("CERTIFICATE", leaf.Raw)),
+		CA:          string(encodePEM("CERTIFICATE", ca.certificate.Raw)),
+	})
+}
+
+func certificatesStatus() CertificatesStatus {
+	status := CertificatesStatus{CAExpires: ca.certificate.NotAfter, ServerExpires: currentServerCertificate().Leaf.NotAfter}
+	rotationLock.Lock()
+	if !rotatedAt.IsZero() {
+		rotated := rotatedAt
+		status.RotatedAt = &rotated
+	}
+	rotationLock.Unlock()
+	return status
+}
+
+func getCertificatesEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	if ca == nil {
+		respondWithError(responseHTTP, tlsOffError())
+		return
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, certificatesStatus())
+}
+
+// rotateCertificatesEndPoint issues a new server certificate and has every agent renew its own with its
+// next heartbeat, both swap certificates under their open listener so no container is restarted
+func rotateCertificatesEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	if ca == nil {
+		respondWithError(responseHTTP, tlsOffError())
+		return
+	}
+	requestLog(r).infof("rotate certificates request")
+
+	stateLock.RLock()
+	rotationLock.Lock()
+	rotatedAt = time.Now().UTC()
+	for _, agent := range agentsArray {
+		pendingRenewals[agent.ID] = true
+	}
+	rotationLock.Unlock()
+	stateLock.RUnlock()
+
+	if err := issueServerCertificate(); err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, err.Error()))
+		return
+	}
+	// idle connections to the agents still carry the old certificate
+	agentTransport.(*http.Transport).CloseIdleConnections()
+	respondWithJSON(responseHTTP, http.StatusOK, certificatesStatus())
+}
+
+// renewServerCertificate runs for the life of the server, the certificate is renewed once two thirds
+// of its lifetime passed
+func renewServerCertificate() {
+	for {
+		time.Sleep(time.Hour)
+		if !needsRenewal(currentServerCertificate().Leaf) {
+			continue
+		}
+		if err := issueServerCertificate(); err != nil {
+			serverLog.errorf("server certificate renewal failed: %s", err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func useTLS(t *testing.T) {
+	pkiDir = t.TempDir()
+	if err := enableTLS(); err != nil {
+		t.Fatal(err)
+	}
+	testClient = tlsClient(nil)
+
+	t.Cleanup(func() {
+		ca, pkiDir = nil, DEFAULT_PKI_DIR
+		agentTransport, agentDialer = http.DefaultTransport, websocket.DefaultDialer
+		testClient = http.DefaultClient
+		rotationLock.Lock()
+		rotatedAt, pendingRenewals = time.Time{}, make(map[string]bool)
+		rotationLock.Unlock()
+	})
+}
+
+// tlsClient trusts the CA and presents the certificate when there is one
+func tlsClient(certificate *tls.Certificate) *http.Client {
+	config := &tls.Config{RootCAs: ca.pool}
+	if certificate != nil {
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// fakeAgentTLSConfig serves a certificate of the CA and only takes requests from the server, like the agents
+func fakeAgentTLSConfig(t *testing.T) *tls.Config {
+	key, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.issue(AGENT_COMMON_NAME_PREFIX+"fake", &key.PublicKey, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: key}},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if state.PeerCertificates[0].Subject.CommonName != SERVER_COMMON_NAME {
+				return errors.New("not the server")
+			}
+			return nil
+		},
+	}
+}
+
+// requestAgentCertificate asks the server for a certificate the way an agent does
+func requestAgentCertificate(t *testing.T, client *http.Client, token string, serverURL string, id string) (int, *tls.Certificate) {
+	key, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: AGENT_COMMON_NAME_PREFIX + id}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := CertificateRequest{ID: id, CSR: string(encodePEM("CERTIFICATE REQUEST", csr))}
+	status, body := doRequestWithClient(t, client, token, http.MethodPost, serverURL+"/agentCertificate", request)
+	if status != http.StatusOK {
+		return status, nil
+	}
+
+	var response CertificateResponse
+	json.Unmarshal(body, &response)
+	block, _ := pem.Decode([]byte(response.Certificate))
+	return status, &tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}
+}
+
+func TestMutualTLSAndRotation(t *testing.T) {
+	useTLS(t)
+	useTokens(t, nil, "bootstrap-token")
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configurationsURL := server.URL + API_V1_PREFIX + "/configurations"
+
+	if status, _ := requestAgentCertificate(t, testClient, "", server.URL, "agent-1"); status != http.StatusUnauthorized {
+		t.Fatalf("certificate without the bootstrap token: status %d", status)
+	}
+	if status, _ := requestAgentCertificate(t, testClient, "bootstrap-token", server.URL, "agent-1"); status != http.StatusOK {
+		t.Fatalf("first certificate of a new agent: status %d", status)
+	}
+
+	// agent-0 is registered, the bootstrap token can't get a certificate speaking for it
+	if status, _ := requestAgentCertificate(t, testClient, "bootstrap-token", server.URL, "agent-0"); status != http.StatusForbidden {
+		t.Fatalf("certificate of a registered agent with the bootstrap token: status %d", status)
+	}
+	stateLock.Lock()
+	agentByID("agent-0").State = AGENT_LOST
+	stateLock.Unlock()
+	status, certificate := requestAgentCertificate(t, testClient, "bootstrap-token", server.URL, "agent-0")
+	if status != http.StatusOK {
+		t.Fatalf("certificate of a lost agent: status %d", status)
+	}
+
+	// from then on the agent certificate is enough, but only for its own agent
+	agentClient := tlsClient(certificate)
+	if status, body := doRequestWithClient(t, agentClient, "", http.MethodPost, server.URL+"/agentHeartbeat", Heartbeat{ID: "agent-0", Port: agents[0].port(), Ready: true}); status != http.StatusNoContent {
+		t.Fatalf("heartbeat with the agent certificate: status %d %s", status, body)
+	}
+	if status, _ := doRequestWithClient(t, agentClient, "", http.MethodPost, server.URL+"/agentHeartbeat", Heartbeat{ID: "agent-1", Port: 1, Ready: true}); status != http.StatusForbidden {
+		t.Fatalf("heartbeat for another agent: status %d", status)
+	}
+
+	// the fake agent only answers the server certificate
+	status, body := doRequest(t, http.MethodPost, configurationsURL, Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create over mutual TLS: %+v", operation)
+	}
+
+	serial := currentServerCertificate().Leaf.SerialNumber
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/certificates/rotate", nil); status != http.StatusOK {
+		t.Fatalf("rotate: status %d %s", status, body)
+	}
+	if currentServerCertificate().Leaf.SerialNumber.Cmp(serial) == 0 {
+		t.Fatal("rotation kept the server certificate")
+	}
+
+	heartbeat := Heartbeat{ID: "agent-0", Port: agents[0].port(), Ready: true}
+	status, body = doRequestWithClient(t, agentClient, "", http.MethodPost, server.URL+"/agentHeartbeat", heartbeat)
+	var answer HeartbeatResponse
+	json.Unmarshal(body, &answer)
+	if status != http.StatusOK || !answer.RenewCertificate {
+		t.Fatalf("heartbeat after the rotation: status %d %s", status, body)
+	}
+
+	status, certificate = requestAgentCertificate(t, agentClient, "", server.URL, "agent-0")
+	if status != http.StatusOK {
+		t.Fatalf("renewal with the current certificate: status %d", status)
+	}
+	if status, body := doRequestWithClient(t, tlsClient(certificate), "", http.MethodPost, server.URL+"/agentHeartbeat", heartbeat); status != http.StatusNoContent {
+		t.Fatalf("heartbeat after the renewal: status %d %s", status, body)
+	}
+
+	// the running containers are untouched and the agents take the new server certificate
+	if names := agents[0].containerNames(); len(names) != 2 {
+		t.Fatalf("rotation changed the containers: %v", names)
+	}
+	status, body = doRequest(t, http.MethodDelete, configurationsURL+"/web", nil)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("delete after the rotation: %+v", operation)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const PATH_CONFIGMAPS = "configMaps.json"
+
+var configMapNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+var envNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ConfigMap is configuration data kept by the server, each key is a file name or an env var
+type ConfigMap struct {
+	Name string
+	Data map[string]string
+	// Namespaces are the namespaces whose configurations a token limited to namespaces may give it to
+	Namespaces []string `json:",omitempty"`
+}
+
+// ConfigMapRef puts a config map into the containers of a configuration, every key as a file
+// under MountPath, as an env var, or both
+type ConfigMapRef struct {
+	Name      string `yaml:"Name"`
+	MountPath string `yaml:"MountPath" json:",omitempty"`
+	Env       bool   `yaml:"Env" json:",omitempty"`
+}
+
+// ContainerFile is a file the agent writes into a container before starting it
+type ContainerFile struct {
+	Path    string
+	Content string
+}
+
+// agentContainer is what runContainer sends, the container with its config maps and secrets resolved,
+// it is never kept in the state
+type agentContainer struct {
+	Container
+	Files []ContainerFile `json:",omitempty"`
+	// SecretFiles are kept in memory by the agent, never written to disk
+	SecretFiles []ContainerFile `json:",omitempty"`
+	Env         []string        `json:",omitempty"`
+}
+
+// configMaps is guarded by stateLock
+var configMaps = make(map[string]*ConfigMap)
+
+func configMapNotFoundError(name string) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("configmap %s doesn't exists", name))
+}
+
+func checkConfigMapValidity(configMap *ConfigMap) *APIError {
+	if !configMapNamePattern.MatchString(configMap.Name) {
+		return invalidRequestError(fmt.Sprintf("configmap name %q may only have letters, digits, '_', '.' and '-'", configMap.Name))
+	}
+	for key := range configMap.Data {
+		if key == "" || key == "." || key == ".." || strings.Contains(key, "/") {
+			return invalidRequestError(fmt.Sprintf("configmap key %q is not a file name", key))
+		}
+	}
+	for _, namespace := range configMap.Namespaces {
+		if apiError := checkNamespaceValidity(namespace); apiError != nil {
+			return apiError
+		}
+	}
+	return nil
+}
+
+func sharedWith(namespaces []string, namespace string) bool {
+	for _, shared := range namespaces {
+		if shared == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReferencesAccess refuses a token limited to namespaces the config maps and secrets which aren't shared
+// with the namespace of the configuration, the missing ones are left to checkDataReference
+func checkReferencesAccess(token *APIToken, namespace string, configMapRefs []ConfigMapRef, secretRefs []SecretRef) *APIError {
+	if len(token.Namespaces) == 0 {
+		return nil
+	}
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	for _, ref := range configMapRefs {
+		if configMap, ok := configMaps[ref.Name]; ok && !sharedWith(configMap.Namespaces, namespace) {
+			return forbiddenError(fmt.Sprintf("configmap %s isn't shared with namespace %s", ref.Name, namespace))
+		}
+	}
+	for _, ref := range secretRefs {
+		if secret, ok := secrets[ref.Name]; ok && !sharedWith(secret.Namespaces, namespace) {
+			return forbiddenError(fmt.Sprintf("secret %s isn't shared with namespace %s", ref.Name, namespace))
+		}
+	}
+	return nil
+}
+
+// checkDataReference checks a config map or secret used by a configuration fits the way it is used,
+// data is nil when there is no such config map or secret
+func checkDataReference(kind string, name string, mountPath string, env bool, data map[string]string) *APIError {
+	if data == nil {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf("%s %s doesn't exists", kind, name))
+	}
+	if mountPath == "" && !env {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf("%s %s needs a MountPath, Env or both", kind, name))
+	}
+	if mountPath != "" && !path.IsAbs(mountPath) {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf("%s %s mount path must be absolute", kind, name))
+	}
+	if env {
+		for key := range data {
+			if !envNamePattern.MatchString(key) {
+				return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf("%s %s key %s is not an env var name", kind, name, key))
+			}
+		}
+	}
+	return nil
+}
+
+// checkConfigMapReferences checks the config maps a configuration uses exist and fit the way they are used
+func checkConfigMapReferences(configuration *Configuration) *APIError {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	for _, ref := range configuration.ConfigMaps {
+		var data map[string]string
+		if configMap, ok := configMaps[ref.Name]; ok {
+			data = configMap.Data
+		}
+		if apiError := checkDataReference("configmap", ref.Name, ref.MountPath, ref.Env, data); apiError != nil {
+			return apiError
+		}
+	}
+	return nil
+}
+
+// resolveData appends every key of data to files under mountPath and to env
+func resolveData(data map[string]string, mountPath string, env bool, files *[]ContainerFile, envVars *[]string) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if mountPath != "" {
+			*files = append(*files, ContainerFile{Path: path.Join(mountPath, key), Content: data[key]})
+		}
+		if env {
+			*envVars = append(*envVars, key+"="+data[key])
+		}
+	}
+}
+
+// resolveContainer adds the current content of the container's config maps and secrets, must be called with stateLock held
+func resolveContainer(container Container) (agentContainer, *APIError) {
+	resolved := agentContainer{Container: container}
+	for _, ref := range container.ConfigMaps {
+		configMap, ok := configMaps[ref.Name]
+		if !ok {
+			return resolved, configMapNotFoundError(ref.Name)
+		}
+		resolveData(configMap.Data, ref.MountPath, ref.Env, &resolved.Files, &resolved.Env)
+	}
+	for _, ref := range container.Secrets {
+		secret, ok := secrets[ref.Name]
+		if !ok {
+			return resolved, secretNotFoundError(ref.Name)
+		}
+		resolveData(secret.Data, ref.MountPath, ref.Env, &resolved.SecretFiles, &resolved.Env)
+	}
+	return resolved, nil
+}
+
+// configMapUsers are the configurations using the config map, must be called with stateLock held
+func configMapUsers(name string) []string {
+	users := make([]string, 0)
+	for configurationName, configurationAgent := range mapConfigurationToAgents {
+		for _, ref := range configurationAgent.Configuration.ConfigMaps {
+			if ref.Name == name {
+				users = append(users, configurationName)
+				break
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+func listConfigMapsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	stateLock.RLock()
+	configMapArray := make([]ConfigMap, 0, len(configMaps))
+	for _, configMap := range configMaps {
+		configMapArray = append(configMapArray, *configMap)
+	}
+	stateLock.RUnlock()
+
+	sort.Slice(configMapArray, func(i, j int) bool {
+		return configMapArray[i].Name < configMapArray[j].Name
+	})
+	respondWithJSON(responseHTTP, http.StatusOK, configMapArray)
+}
+
+func getConfigMapEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	stateLock.RLock()
+	configMap, ok := configMaps[name]
+	var configMapJSON []byte
+	if ok {
+		configMapJSON, _ = json.Marshal(configMap)
+	}
+	stateLock.RUnlock()
+
+	if !ok {
+		respondWithError(responseHTTP, configMapNotFoundError(name))
+		return
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, json.RawMessage(configMapJSON))
+}
+
+func decodeConfigMap(r *http.Request) (*ConfigMap, *APIError) {
+	var configMap ConfigMap
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&configMap); err != nil {
+		return nil, invalidRequestError(err.Error())
+	}
+	defer r.Body.Close()
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	return &configMap, checkConfigMapValidity(&configMap)
+}
+
+func createConfigMapEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configMap, apiError := decodeConfigMap(r)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	requestLog(r).infof("create configmap %s request", configMap.Name)
+	auditOf(r).target("", configMap.Name)
+
+	stateLock.Lock()
+	_, exists := configMaps[configMap.Name]
+	if !exists {
+		configMaps[configMap.Name] = configMap
+	}
+	stateLock.Unlock()
+
+	if exists {
+		respondWithError(responseHTTP, newAPIError(http.StatusConflict, ERROR_ALREADY_EXISTS, fmt.Sprintf("configmap %s already exists", configMap.Name)))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordAfter(snapshot(configMap))
+	respondWithJSON(responseHTTP, http.StatusCreated, configMap)
+}
+
+// replaceConfigMapEndPoint changes the data of a config map, only containers created afterwards see it
+func replaceConfigMapEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	configMap, apiError := decodeConfigMap(r)
+	if apiError == nil && configMap.Name != name {
+		apiError = invalidRequestError(fmt.Sprintf("the body is configmap %s, not %s", configMap.Name, name))
+	}
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	requestLog(r).infof("replace configmap %s request", name)
+
+	stateLock.Lock()
+	previous, exists := configMaps[name]
+	if exists {
+		configMaps[name] = configMap
+	}
+	stateLock.Unlock()
+
+	if !exists {
+		respondWithError(responseHTTP, configMapNotFoundError(name))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordBefore(snapshot(previous))
+	auditOf(r).recordAfter(snapshot(configMap))
+	respondWithJSON(responseHTTP, http.StatusOK, configMap)
+}
+
+func deleteConfigMapEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	requestLog(r).infof("delete configmap %s request", name)
+
+	stateLock.Lock()
+	previous, exists := configMaps[name]
+	users := configMapUsers(name)
+	if exists && len(users) == 0 {
+		delete(configMaps, name)
+	}
+	stateLock.Unlock()
+
+	if !exists {
+		respondWithError(responseHTTP, configMapNotFoundError(name))
+		return
+	}
+	if len(users) != 0 {
+		respondWithError(responseHTTP, newAPIError(http.StatusConflict, ERROR_IN_USE, fmt.Sprintf("configmap %s is used by %s", name, strings.Join(users, ", "))))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordBefore(snapshot(previous))
+	responseHTTP.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestConfigMapsReachTheContainers(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configMapsURL := server.URL + API_V1_PREFIX + "/configmaps"
+
+	configMap := ConfigMap{Name: "settings", Data: map[string]string{"LEVEL": "debug", "MODE": "fast"}}
+	if status, body := doRequest(t, http.MethodPost, configMapsURL, configMap); status != http.StatusCreated {
+		t.Fatalf("create configmap: status %d %s", status, body)
+	}
+	if status, body := doRequest(t, http.MethodPost, configMapsURL, ConfigMap{Name: "bad", Data: map[string]string{"../x": ""}}); status != http.StatusBadRequest {
+		t.Fatalf("expected a key with a path to be refused, got status %d %s", status, body)
+	}
+
+	missing := Configuration{Name: "web", Amount: 1, Image: "alpine", ConfigMaps: []ConfigMapRef{{Name: "missing", Env: true}}}
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", missing); status != http.StatusBadRequest {
+		t.Fatalf("expected a missing configmap to be refused, got status %d %s", status, body)
+	}
+
+	configuration := Configuration{Name: "web", Amount: 1, Image: "alpine", ConfigMaps: []ConfigMapRef{{Name: "settings", MountPath: "/etc/web", Env: true}}}
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	agents[0].lock.Lock()
+	container := agents[0].containers["web1"]
+	agents[0].lock.Unlock()
+	if fmt.Sprint(container.Env) != "[LEVEL=debug MODE=fast]" || len(container.Files) != 2 || container.Files[0].Path != "/etc/web/LEVEL" || container.Files[0].Content != "debug" {
+		t.Fatalf("container reached the agent with %+v", container)
+	}
+
+	if status, body := doRequest(t, http.MethodDelete, configMapsURL+"/settings", nil); status != http.StatusConflict {
+		t.Fatalf("expected the delete of a used configmap to be refused, got status %d %s", status, body)
+	}
+
+	status, body = doRequest(t, http.MethodDelete, server.URL+API_V1_PREFIX+"/configurations/web", nil)
+	waitForOperation(t, server.URL, status, body)
+	if status, body := doRequest(t, http.MethodDelete, configMapsURL+"/settings", nil); status != http.StatusNoContent {
+		t.Fatalf("delete configmap: status %d %s", status, body)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// flushWriter flushes every write so followed logs reach the CLI as soon as the agent sends them
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func newFlushWriter(responseHTTP http.ResponseWriter) *flushWriter {
+	flusher, _ := responseHTTP.(http.Flusher)
+	return &flushWriter{writer: responseHTTP, flusher: flusher}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.writer.Write(p)
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return n, err
+}
+
+func containerLogsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configurationName := mux.Vars(r)["name"]
+	query := r.URL.Query()
+
+	replica, err := strconv.Atoi(query.Get("replica"))
+	if err != nil || replica < 1 {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "Invalid replica index"))
+		return
+	}
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	key := configurationKey(namespace, configurationName)
+
+	requestLog(r).infof("logs of env %s replica %d request", key, replica)
+
+	agentPort, containerNameToRead, found := getAgentByContainer(key, replica)
+	if !found {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("replica %d of %s doesn't exists", replica, key)))
+		return
+	}
+
+	query.Del("replica")
+	query.Del("namespace")
+	agentURL := fmt.Sprintf("%s%d/containerLogs/%s?%s", agentBaseURL(), agentPort, containerNameToRead, query.Encode())
+
+	// the rest client buffers the whole body, a followed stream never ends so it is proxied by hand
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, agentURL, nil)
+	if err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, err.Error()))
+		return
+	}
+
+	resp, err := agentHTTPClient(0).Do(request)
+	if err != nil {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadGateway, ERROR_AGENT_FAILURE, fmt.Sprintf("agent on port %d is not responding", agentPort)))
+		return
+	}
+	defer resp.Body.Close()
+
+	responseHTTP.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	responseHTTP.WriteHeader(resp.StatusCode)
+	io.Copy(newFlushWriter(responseHTTP), resp.Body)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContainerLogs(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	// the replica and the namespace choose the container, the rest of the query goes to the agent
+	status, body = doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/web/logs?replica=2&namespace=default&tail=5", nil)
+	if status != http.StatusOK || strings.TrimSpace(string(body)) != "web2 tail=5" {
+		t.Fatalf("logs: status %d %s", status, body)
+	}
+	if status, body := doRequest(t, http.MethodGet, server.URL+"/logs/web?replica=1", nil); status != http.StatusOK || !strings.HasPrefix(string(body), "web1 ") {
+		t.Fatalf("logs on the deprecated route: status %d %s", status, body)
+	}
+
+	for query, expected := range map[string]int{"": http.StatusBadRequest, "?replica=0": http.StatusBadRequest, "?replica=x": http.StatusBadRequest, "?replica=3": http.StatusNotFound} {
+		if status, body := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/web/logs"+query, nil); status != expected {
+			t.Errorf("logs%s: expected status %d, got %d %s", query, expected, status, body)
+		}
+	}
+	if status, _ := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/api/logs?replica=1", nil); status != http.StatusNotFound {
+		t.Errorf("logs of an unknown configuration: status %d", status)
+	}
+
+	// the error of the agent reaches the client as is
+	for _, agent := range agents {
+		agent.lock.Lock()
+		delete(agent.containers, "web1")
+		agent.lock.Unlock()
+	}
+	status, body = doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/web/logs?replica=1", nil)
+	if status != http.StatusNotFound || !strings.Contains(string(body), "not managed by this agent") {
+		t.Fatalf("logs of a container the agent doesn't run: status %d %s", status, body)
+	}
+}
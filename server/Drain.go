@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const OPERATION_DRAIN = "Drain"
+const REPLICA_MOVE = "Move"
+
+// replicas of one configuration moved at the same time when the drain request doesn't say
+const DEFAULT_DRAIN_MAX_UNAVAILABLE = 1
+
+// DrainOptions is the body of a drain request
+type DrainOptions struct {
+	// MaxUnavailable is how many replicas of a configuration may be down at once during the drain
+	MaxUnavailable int
+}
+
+func agentNotFoundError(agentID string) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("agent %s doesn't exists", agentID))
+}
+
+// setAgentSchedulable cordons or uncordons an agent and answers with it
+func setAgentSchedulable(responseHTTP http.ResponseWriter, agentID string, schedulable bool) {
+	stateLock.Lock()
+	agent := agentByID(agentID)
+	if agent == nil {
+		stateLock.Unlock()
+		respondWithError(responseHTTP, agentNotFoundError(agentID))
+		return
+	}
+	agent.Unschedulable = !schedulable
+	agentJSON, _ := json.Marshal(agent)
+	stateLock.Unlock()
+
+	responseLog(responseHTTP).infof("agent %s schedulable: %t", agentID, schedulable)
+	writeDataToJSON()
+	respondWithJSON(responseHTTP, http.StatusOK, json.RawMessage(agentJSON))
+}
+
+func cordonEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	setAgentSchedulable(responseHTTP, mux.Vars(r)["id"], false)
+}
+
+func uncordonEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	setAgentSchedulable(responseHTTP, mux.Vars(r)["id"], true)
+}
+
+// drainEndPoint cordons the agent and starts an operation moving its containers to the other agents
+func drainEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	agentID := mux.Vars(r)["id"]
+
+	options := DrainOptions{MaxUnavailable: DEFAULT_DRAIN_MAX_UNAVAILABLE}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&options); err != nil {
+			respondWithError(responseHTTP, invalidRequestError(err.Error()))
+			return
+		}
+		defer r.Body.Close()
+	}
+	if options.MaxUnavailable < 1 {
+		respondWithError(responseHTTP, invalidRequestError("MaxUnavailable must be at least 1"))
+		return
+	}
+
+	requestLog(r).infof("drain agent %s request", agentID)
+
+	// cordoned first so nothing new lands on the agent while it is drained
+	stateLock.Lock()
+	agent := agentByID(agentID)
+	if agent != nil {
+		agent.Unschedulable = true
+	}
+	stateLock.Unlock()
+
+	if agent == nil {
+		respondWithError(responseHTTP, agentNotFoundError(agentID))
+		return
+	}
+
+	operation := newOperation(r, OPERATION_DRAIN, "", "")
+	operation.setAgentID(agentID)
+	entry := auditOf(r)
+	entry.deferTo(operation)
+	go func() {
+		apiError := drainAgent(operation, agent, options.MaxUnavailable)
+		writeDataToJSON()
+		entry.operationDone(apiError)
+		operation.finish(apiError)
+	}()
+	respondWithOperation(responseHTTP, r, operation)
+}
+
+// drainAgent moves the containers of the agent configuration by configuration, at most
+// maxUnavailable replicas of a configuration are stopped at the same time
+func drainAgent(operation *Operation, agent *Agent, maxUnavailable int) *APIError {
+	stateLock.RLock()
+	containersByConfiguration := make(map[string][]Container)
+	for _, container := range agent.MapContainerName {
+		key := container.configurationKey()
+		containersByConfiguration[key] = append(containersByConfiguration[key], *container)
+	}
+	agentPort := agent.Port
+	stateLock.RUnlock()
+
+	configurationNames := make([]string, 0, len(containersByConfiguration))
+	for configurationName, containers := range containersByConfiguration {
+		configurationNames = append(configurationNames, configurationName)
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].Index < containers[j].Index
+		})
+		for _, container := range containers {
+			operation.replicaPending(REPLICA_MOVE, containerName(&container), agentPort)
+		}
+	}
+	sort.Strings(configurationNames)
+
+	// the containers on their way to each agent, guarded by stateLock
+	incoming := make(map[*Agent]int)
+	failures := make([]ReplicaFailure, 0)
+	for _, configurationName := range configurationNames {
+		containers := containersByConfiguration[configurationName]
+
+		unlock := lockConfiguration(configurationName)
+		for start := 0; start < len(containers); start += maxUnavailable {
+			batch := containers[start:]
+			if len(batch) > maxUnavailable {
+				batch = batch[:maxUnavailable]
+			}
+
+			failures = append(failures, fanOut(len(batch), func(i int) *ReplicaFailure {
+				failure := moveContainer(operation, agent, batch[i], incoming)
+				operation.replicaDone(REPLICA_MOVE, containerName(&batch[i]), failure)
+				return failure
+			})...)
+		}
+		unlock()
+		writeDataToJSON()
+	}
+
+	if len(failures) != 0 {
+		return replicaFailuresError(fmt.Sprintf("%d of the containers failed to move", len(failures)), failures)
+	}
+	operation.log().infof("agent %s drained", agent.ID)
+	return nil
+}
+
+// drainTarget is the schedulable agent with the fewest containers, counting the ones moving to it,
+// must be called with stateLock held
+func drainTarget(incoming map[*Agent]int) *Agent {
+	var target *Agent
+	for _, agent := range sortedAgentsByContainerAmount() {
+		if target == nil || len(agent.MapContainerName)+incoming[agent] < len(target.MapContainerName)+incoming[target] {
+			target = agent
+		}
+	}
+	return target
+}
+
+// moveContainer stops the container on its agent and starts it on the least loaded schedulable agent,
+// a container the target fails to start goes back to its agent, must be called with the configuration lock held
+func moveContainer(operation *Operation, from *Agent, container Container, incoming map[*Agent]int) *ReplicaFailure {
+	name := containerName(&container)
+
+	stateLock.Lock()
+	// the configuration may have been updated or deleted since the drain started
+	_, stillScheduled := from.MapContainerName[name]
+	fromPort := from.Port
+	to := drainTarget(incoming)
+	var toPort int
+	if stillScheduled && to != nil {
+		toPort = to.Port
+		// the other moves of the batch see this one coming
+		incoming[to]++
+	}
+	stateLock.Unlock()
+
+	if !stillScheduled {
+		return nil
+	}
+	if to == nil {
+		schedulingFailed(SCHEDULING_NO_AGENTS, 1)
+		return &ReplicaFailure{Container: name, AgentPort: fromPort, Message: "no schedulable agent to move the container to"}
+	}
+	defer func() {
+		stateLock.Lock()
+		incoming[to]--
+		stateLock.Unlock()
+	}()
+
+	resp := deleteContainer(container, strconv.Itoa(fromPort), operation.requestID())
+	if resp.Err != nil || resp.StatusCode != http.StatusOK {
+		failure := newReplicaFailure(resp, name, fromPort)
+		return &failure
+	}
+
+	resp = runContainer(container, strconv.Itoa(toPort), operation.requestID())
+	if resp.Err != nil || resp.StatusCode != http.StatusCreated {
+		schedulingFailed(SCHEDULING_AGENT_FAILURE, 1)
+		failure := newReplicaFailure(resp, name, toPort)
+
+		// the drained agent is only cordoned, the replica runs there again and the state keeps it on it
+		back := runContainer(container, strconv.Itoa(fromPort), operation.requestID())
+		if back.Err != nil || back.StatusCode != http.StatusCreated {
+			operation.log().errorf("container %s is down, it failed to start on agent %s and again on agent %s", name, to.ID, from.ID)
+			failure.Message += ", and it failed to start again on the drained agent"
+			return &failure
+		}
+		operation.log().warnf("container %s failed to start on agent %s and runs again on agent %s", name, to.ID, from.ID)
+		failure.Message += ", it runs again on the drained agent"
+		return &failure
+	}
+
+	stateLock.Lock()
+	moveContainerState(from, to, container)
+	stateLock.Unlock()
+	operation.log().infof("container %s moved from agent %s to agent %s", name, from.ID, to.ID)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCordonAndDrain(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/agent-2/cordon", nil); status != http.StatusOK {
+		t.Fatalf("cordon: status %d %s", status, body)
+	}
+
+	for _, name := range []string{"web", "db"} {
+		status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: name, Amount: 3, Image: "alpine"})
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create %s: %+v", name, operation)
+		}
+	}
+	if running := len(agents[2].containerNames()); running != 0 {
+		t.Fatalf("cordoned agent got %d containers", running)
+	}
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/agent-0/drain", DrainOptions{MaxUnavailable: 2})
+	operation := waitForOperation(t, server.URL, status, body)
+	if operation.Status != OPERATION_SUCCEEDED || operation.AgentID != "agent-0" || len(operation.Replicas) != 3 {
+		t.Fatalf("drain: %+v", operation)
+	}
+
+	// the only schedulable agent left takes everything
+	if running := len(agents[0].containerNames()); running != 0 {
+		t.Fatalf("drained agent still runs %d containers", running)
+	}
+	if running := len(agents[1].containerNames()); running != 6 {
+		t.Fatalf("expected 6 containers on the remaining agent, got %d", running)
+	}
+	checkConsistency(t, agents...)
+
+	stateLock.RLock()
+	cordoned := agentByID("agent-0").Unschedulable
+	stateLock.RUnlock()
+	if !cordoned {
+		t.Fatalf("drained agent is schedulable")
+	}
+
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/unknown/drain", nil); status != http.StatusNotFound {
+		t.Fatalf("drain of unknown agent: status %d %s", status, body)
+	}
+}
+
+func TestDrainSpreadsABatch(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	// every replica lands on agent-0
+	for _, id := range []string{"agent-1", "agent-2"} {
+		if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/"+id+"/cordon", nil); status != http.StatusOK {
+			t.Fatalf("cordon %s: status %d %s", id, status, body)
+		}
+	}
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 4, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	for _, id := range []string{"agent-1", "agent-2"} {
+		if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/"+id+"/uncordon", nil); status != http.StatusOK {
+			t.Fatalf("uncordon %s: status %d %s", id, status, body)
+		}
+	}
+
+	// the four moves run at once, each one counts the others going to the same agent
+	status, body = doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/agent-0/drain", DrainOptions{MaxUnavailable: 4})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("drain: %+v", operation)
+	}
+	for i, expected := range []int{0, 2, 2} {
+		if running := len(agents[i].containerNames()); running != expected {
+			t.Errorf("agent-%d runs %d containers, expected %d", i, running, expected)
+		}
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestDrainKeepsTheReplicaTheTargetFailsToStart(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	// agent-1 stops answering before the server notices
+	agents[1].server.Close()
+	status, body = doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/agents/agent-0/drain", nil)
+	operation := waitForOperation(t, server.URL, status, body)
+	if operation.Status != OPERATION_FAILED || operation.Error == nil || len(operation.Error.Failures) != 1 || !strings.Contains(operation.Error.Failures[0].Message, "runs again on the drained agent") {
+		t.Fatalf("drain to an agent that fails: %+v", operation)
+	}
+
+	// the configuration keeps its amount, the replica is back on agent-0
+	if running := agents[0].containerNames(); len(running) != 1 {
+		t.Fatalf("agent-0 runs %v", running)
+	}
+	checkConsistency(t, agents...)
+}
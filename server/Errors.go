@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+// error codes carried in APIError.Code, stable across releases so scripts can match on them
+const (
+	ERROR_INVALID_REQUEST       = "InvalidRequest"
+	ERROR_INVALID_CONFIGURATION = "InvalidConfiguration"
+	ERROR_NOT_FOUND             = "NotFound"
+	ERROR_ALREADY_EXISTS        = "AlreadyExists"
+	ERROR_IN_USE                = "InUse"
+	ERROR_QUOTA_EXCEEDED        = "QuotaExceeded"
+	ERROR_UNAUTHORIZED          = "Unauthorized"
+	ERROR_FORBIDDEN             = "Forbidden"
+	ERROR_NO_AGENTS             = "NoAgentsAvailable"
+	ERROR_AGENT_FAILURE         = "AgentFailure"
+	ERROR_EXPIRED               = "Expired"
+	ERROR_INTERNAL              = "InternalError"
+)
+
+// APIError is the body of every failed response of the server and the agents
+type APIError struct {
+	Status   int `json:"-"`
+	Code     string
+	Message  string
+	Details  string           `json:",omitempty"`
+	Failures []ReplicaFailure `json:",omitempty"`
+}
+
+// ReplicaFailure describes a single container an agent failed to handle
+type ReplicaFailure struct {
+	Container string
+	AgentPort int
+	Message   string
+}
+
+func (apiError *APIError) Error() string {
+	return apiError.Message
+}
+
+func newAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func invalidRequestError(details string) *APIError {
+	apiError := newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "Invalid request payload")
+	apiError.Details = details
+	return apiError
+}
+
+func configurationNotFoundError(configurationName string) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("configuration %s doesn't exists", configurationName))
+}
+
+func replicaFailuresError(message string, failures []ReplicaFailure) *APIError {
+	apiError := newAPIError(http.StatusBadGateway, ERROR_AGENT_FAILURE, message)
+	apiError.Failures = failures
+	return apiError
+}
+
+// newReplicaFailure extracts the reason an agent gave for failing on a container
+func newReplicaFailure(resp *rest.Response, containerName string, agentPort int) ReplicaFailure {
+	failure := ReplicaFailure{Container: containerName, AgentPort: agentPort}
+
+	// the request wasn't sent, the server itself refused it
+	if apiError, ok := resp.Err.(*APIError); ok {
+		failure.Message = apiError.Message
+		return failure
+	}
+
+	if resp.Err != nil || resp.Response == nil {
+		failure.Message = fmt.Sprintf("agent on port %d is not responding", agentPort)
+		return failure
+	}
+
+	var agentError APIError
+	if err := resp.FillUp(&agentError); err == nil && agentError.Message != "" {
+		failure.Message = agentError.Message
+		if agentError.Details != "" {
+			failure.Message = fmt.Sprintf("%s: %s", agentError.Message, agentError.Details)
+		}
+		return failure
+	}
+
+	failure.Message = fmt.Sprintf("agent on port %d answered with status %d", agentPort, resp.StatusCode)
+	return failure
+}
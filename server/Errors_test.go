@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func decodeAPIError(t *testing.T, body []byte) APIError {
+	var apiError APIError
+	if err := json.Unmarshal(body, &apiError); err != nil {
+		t.Fatalf("not an error object: %s", body)
+	}
+	return apiError
+}
+
+func TestErrorStatusAndCode(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	for _, test := range []struct {
+		method  string
+		path    string
+		payload interface{}
+		status  int
+		code    string
+	}{
+		{http.MethodPost, API_V1_PREFIX + "/configurations", "not a configuration", http.StatusBadRequest, ERROR_INVALID_REQUEST},
+		{http.MethodPost, API_V1_PREFIX + "/configurations", Configuration{Name: "api", Amount: 1}, http.StatusBadRequest, ERROR_INVALID_CONFIGURATION},
+		{http.MethodPost, API_V1_PREFIX + "/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"}, http.StatusConflict, ERROR_ALREADY_EXISTS},
+		{http.MethodGet, API_V1_PREFIX + "/configurations/api", nil, http.StatusNotFound, ERROR_NOT_FOUND},
+		{http.MethodDelete, API_V1_PREFIX + "/configurations/api", nil, http.StatusNotFound, ERROR_NOT_FOUND},
+		{http.MethodPost, "/envNameStatus", "api", http.StatusNotFound, ERROR_NOT_FOUND},
+		{http.MethodPost, "/delete", "api", http.StatusNotFound, ERROR_NOT_FOUND},
+	} {
+		status, body := doRequest(t, test.method, server.URL+test.path, test.payload)
+		if status != test.status || decodeAPIError(t, body).Code != test.code {
+			t.Errorf("%s %s: expected %d %s, got %d %s", test.method, test.path, test.status, test.code, status, body)
+		}
+	}
+}
+
+func TestAgentFailureListsTheFailedReplicas(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	// the agent can't pull the image, the server reports it as a failure of the agent
+	status, body := doRequest(t, http.MethodPost, server.URL+"/create", Configuration{Name: "web", Amount: 2, Image: UNKNOWN_IMAGE})
+	apiError := decodeAPIError(t, body)
+	if status != http.StatusBadGateway || apiError.Code != ERROR_AGENT_FAILURE || len(apiError.Failures) != 2 {
+		t.Fatalf("create of an unknown image: status %d %s", status, body)
+	}
+	for _, failure := range apiError.Failures {
+		if !strings.Contains(failure.Message, "No such image") || failure.AgentPort == 0 {
+			t.Errorf("failure without the reason of the agent: %+v", failure)
+		}
+	}
+
+	// the operation of the v1 route carries the same error
+	status, body = doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "api", Amount: 1, Image: UNKNOWN_IMAGE})
+	operation := waitForOperation(t, server.URL, status, body)
+	if operation.Status != OPERATION_FAILED || operation.Error == nil || operation.Error.Code != ERROR_AGENT_FAILURE || len(operation.Error.Failures) != 1 {
+		t.Fatalf("operation of an unknown image: %+v", operation)
+	}
+}
+
+func TestNoAgentsAvailable(t *testing.T) {
+	server := newTestServer(t)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+"/create", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if status != http.StatusServiceUnavailable || decodeAPIError(t, body).Code != ERROR_NO_AGENTS {
+		t.Fatalf("create without agents: status %d %s", status, body)
+	}
+}
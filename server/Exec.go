@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// relayWebsocket copies frames from one side of an exec session to the other until either side closes
+func relayWebsocket(from *websocket.Conn, to *websocket.Conn, done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+
+	for {
+		messageType, data, err := from.ReadMessage()
+		if err != nil {
+			to.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+
+		if err := to.WriteMessage(messageType, data); err != nil {
+			return
+		}
+	}
+}
+
+func execEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configurationName := mux.Vars(r)["name"]
+	query := r.URL.Query()
+
+	replica, err := strconv.Atoi(query.Get("replica"))
+	if err != nil || replica < 1 {
+		respondWithError(responseHTTP, newAPIError(http.StatusBadRequest, ERROR_INVALID_REQUEST, "Invalid replica index"))
+		return
+	}
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	key := configurationKey(namespace, configurationName)
+
+	requestLog(r).infof("exec in env %s replica %d request", key, replica)
+	auditOf(r).target(namespace, configurationName)
+	auditOf(r).execIn(replica, query["cmd"])
+
+	agentPort, containerNameToExec, found := getAgentByContainer(key, replica)
+	if !found {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("replica %d of %s doesn't exists", replica, key)))
+		return
+	}
+
+	query.Del("replica")
+	query.Del("namespace")
+	agentURL := fmt.Sprintf("%s%d/exec/%s?%s", agentWebsocketBaseURL(), agentPort, containerNameToExec, query.Encode())
+
+	// the agent is dialed first so its errors can still be returned as a plain HTTP response
+	agentConn, resp, err := agentDialer.Dial(agentURL, nil)
+	if err != nil {
+		if resp == nil {
+			respondWithError(responseHTTP, newAPIError(http.StatusBadGateway, ERROR_AGENT_FAILURE, fmt.Sprintf("agent on port %d is not responding", agentPort)))
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responseHTTP.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		responseHTTP.WriteHeader(resp.StatusCode)
+		responseHTTP.Write(body)
+		return
+	}
+	defer agentConn.Close()
+
+	clientConn, err := upgrader.Upgrade(responseHTTP, r, nil)
+	if err != nil {
+		requestLog(r).warnf("%s", err)
+		return
+	}
+	defer clientConn.Close()
+	auditOf(r).sessionStarted()
+
+	done := make(chan struct{}, 2)
+	go relayWebsocket(clientConn, agentConn, done)
+	go relayWebsocket(agentConn, clientConn, done)
+	<-done
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialExec opens an exec session through the server, the response tells why when it fails
+func dialExec(t *testing.T, serverURL string, path string) (*websocket.Conn, int, string) {
+	header := make(http.Header)
+	if testToken != "" {
+		header.Set("Authorization", "Bearer "+testToken)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(strings.Replace(serverURL, "http://", "ws://", 1)+path, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, resp.StatusCode, string(body)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, http.StatusSwitchingProtocols, ""
+}
+
+func TestExec(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	conn, status, message := dialExec(t, server.URL, API_V1_PREFIX+"/configurations/web/exec?replica=2&cmd=sh")
+	if conn == nil {
+		t.Fatalf("exec: status %d %s", status, message)
+	}
+	// the frames are relayed both ways
+	for _, input := range []string{"ls\n", "exit\n"} {
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		_, output, err := conn.ReadMessage()
+		if err != nil || string(output) != "web2: "+input {
+			t.Fatalf("exec output %q %v", output, err)
+		}
+	}
+
+	if conn, status, message := dialExec(t, server.URL, API_V1_PREFIX+"/configurations/web/exec?replica=3"); conn != nil || status != http.StatusNotFound {
+		t.Fatalf("exec of an unknown replica: status %d %s", status, message)
+	}
+	if conn, status, message := dialExec(t, server.URL, API_V1_PREFIX+"/configurations/web/exec"); conn != nil || status != http.StatusBadRequest {
+		t.Fatalf("exec without replica: status %d %s", status, message)
+	}
+
+	// the agent refuses before the upgrade, its error reaches the client as is
+	for _, agent := range agents {
+		agent.lock.Lock()
+		delete(agent.containers, "web1")
+		agent.lock.Unlock()
+	}
+	if conn, status, message := dialExec(t, server.URL, API_V1_PREFIX+"/configurations/web/exec?replica=1"); conn != nil || status != http.StatusNotFound || !strings.Contains(message, "not managed by this agent") {
+		t.Fatalf("exec in a container the agent doesn't run: status %d %s", status, message)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const DEFAULT_AGENT_CONCURRENCY = 8
+const DEFAULT_RUN_CONTAINER_TIMEOUT = 5 * time.Minute
+const DEFAULT_DELETE_CONTAINER_TIMEOUT = time.Minute
+
+// seconds between SIGTERM and SIGKILL for a configuration without TerminationGracePeriod, the agents use the same
+const DEFAULT_TERMINATION_GRACE_PERIOD = 30
+
+// set from the command line, see main
+var agentConcurrency = DEFAULT_AGENT_CONCURRENCY
+var runContainerTimeout = DEFAULT_RUN_CONTAINER_TIMEOUT
+var deleteContainerTimeout = DEFAULT_DELETE_CONTAINER_TIMEOUT
+
+// fanOut runs task for the replicas 0..count-1 with at most agentConcurrency requests
+// to agents in flight, and returns the failures in replica order
+func fanOut(count int, task func(i int) *ReplicaFailure) []ReplicaFailure {
+	concurrency := agentConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*ReplicaFailure, count)
+	limit := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			results[i] = task(i)
+		}(i)
+	}
+	wg.Wait()
+
+	failures := make([]ReplicaFailure, 0)
+	for _, failure := range results {
+		if failure != nil {
+			failures = append(failures, *failure)
+		}
+	}
+	return failures
+}
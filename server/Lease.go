@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// states of an agent, only Ready agents get new containers
+const (
+	AGENT_READY = "Ready"
+	// the agent heartbeats but can't run containers, docker is unreachable for example
+	AGENT_NOT_READY = "NotReady"
+	// no heartbeat within the lease, the agent may come back during the grace period
+	AGENT_UNKNOWN = "Unknown"
+	// the grace period passed too, the agent was restarted and its containers rescheduled
+	AGENT_LOST = "Lost"
+)
+
+const DEFAULT_AGENT_LEASE = 15 * time.Second
+const DEFAULT_AGENT_GRACE_PERIOD = 30 * time.Second
+const LEASE_CHECK_PERIOD = time.Second
+
+// set from the command line, see main
+var agentLease = DEFAULT_AGENT_LEASE
+var agentGracePeriod = DEFAULT_AGENT_GRACE_PERIOD
+
+// Heartbeat is sent by every agent periodically
+type Heartbeat struct {
+	ID      string
+	Port    int
+	Ready   bool
+	Message string
+}
+
+// setAgentState must be called with stateLock held
+func setAgentState(agent *Agent, state string, message string) {
+	if agent.State != state {
+		serverLog.infof("agent %s on port %d is %s %s", agent.ID, agent.Port, state, message)
+	}
+	agent.State = state
+	agent.Message = message
+	agent.Active = state == AGENT_READY
+}
+
+func heartbeatEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var heartbeat Heartbeat
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&heartbeat); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	if apiError := checkAgentIdentity(r, heartbeat.ID); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	stateLock.Lock()
+	agent := agentByID(heartbeat.ID)
+	if agent == nil {
+		stateLock.Unlock()
+		// the server doesn't know the agent, after a restart for example, it has to register again
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("agent %s is not registered", heartbeat.ID)))
+		return
+	}
+
+	changed := agent.Port != heartbeat.Port || agent.Active != heartbeat.Ready
+	agent.Port = heartbeat.Port
+	agent.LastHeartbeat = time.Now().UTC()
+	if heartbeat.Ready {
+		setAgentState(agent, AGENT_READY, "")
+	} else {
+		setAgentState(agent, AGENT_NOT_READY, heartbeat.Message)
+	}
+	stateLock.Unlock()
+
+	if changed {
+		writeDataToJSON()
+	}
+	if ca != nil && renewalPending(heartbeat.ID) {
+		respondWithJSON(responseHTTP, http.StatusOK, HeartbeatResponse{RenewCertificate: true})
+		return
+	}
+	responseHTTP.WriteHeader(http.StatusNoContent)
+}
+
+// checkAgentLeases moves the agents whose heartbeats stopped to Unknown, and once the grace period
+// passes to Lost, replacing them and rescheduling their containers
+func checkAgentLeases() {
+	now := time.Now().UTC()
+	lostAgents := make([]*Agent, 0)
+	changed := false
+
+	stateLock.Lock()
+	for _, agent := range agentsArray {
+		silence := now.Sub(agent.LastHeartbeat)
+
+		switch {
+		case agent.State == AGENT_LOST:
+			continue
+
+		case silence > agentLease+agentGracePeriod:
+			setAgentState(agent, AGENT_LOST, fmt.Sprintf("no heartbeat since %s", agent.LastHeartbeat.Format(time.RFC3339)))
+			lostAgents = append(lostAgents, agent)
+			changed = true
+
+		case silence > agentLease && agent.State != AGENT_UNKNOWN:
+			setAgentState(agent, AGENT_UNKNOWN, fmt.Sprintf("no heartbeat since %s", agent.LastHeartbeat.Format(time.RFC3339)))
+			changed = true
+		}
+	}
+	stateLock.Unlock()
+
+	for _, agent := range lostAgents {
+		agentSupervisor.restart(agent.ID)
+		rescheduleContainers(agent)
+	}
+
+	if changed {
+		writeDataToJSON()
+	}
+}
+
+func watchAgentLeases() {
+	for {
+		time.Sleep(LEASE_CHECK_PERIOD)
+		checkAgentLeases()
+	}
+}
+
+// rescheduleContainers starts the containers of a lost agent on the ready agents
+// the requests to the agents share a new request ID, there is no request to take it from
+func rescheduleContainers(lost *Agent) {
+	started := time.Now()
+	logs := logger{requestID: newRequestID()}
+	stateLock.RLock()
+	containersByConfiguration := make(map[string][]Container)
+	for _, container := range lost.MapContainerName {
+		key := container.configurationKey()
+		containersByConfiguration[key] = append(containersByConfiguration[key], *container)
+	}
+	stateLock.RUnlock()
+
+	var apiError *APIError
+	for configurationName, containers := range containersByConfiguration {
+		if failed := rescheduleConfigurationContainers(logs, lost, configurationName, containers); failed != 0 {
+			apiError = newAPIError(http.StatusServiceUnavailable, ERROR_AGENT_FAILURE, fmt.Sprintf("%d containers of %s not rescheduled", failed, configurationName))
+		}
+	}
+	observeReconcile(RECONCILE_RESCHEDULE, started, apiError)
+}
+
+// rescheduleConfigurationContainers returns how many containers found no new agent
+func rescheduleConfigurationContainers(logs logger, lost *Agent, configurationName string, containers []Container) int {
+	defer lockConfiguration(configurationName)()
+
+	failed := 0
+	for i, container := range containers {
+		name := containerName(&container)
+
+		stateLock.Lock()
+		// the configuration may have changed since the agent was lost
+		_, stillScheduled := lost.MapContainerName[name]
+		if lost.State != AGENT_LOST {
+			stillScheduled = false
+		}
+		agentArray := sortedAgentsByContainerAmount()
+		stateLock.Unlock()
+
+		if !stillScheduled {
+			continue
+		}
+		if len(agentArray) == 0 {
+			logs.warnf("no agent ready to reschedule container %s", name)
+			schedulingFailed(SCHEDULING_NO_AGENTS, len(containers)-i)
+			return failed + len(containers) - i
+		}
+
+		agent := agentArray[0]
+		stateLock.RLock()
+		agentPort := strconv.Itoa(agent.Port)
+		stateLock.RUnlock()
+
+		// on a shared docker host the container of the lost agent still holds the name
+		deleteContainer(container, agentPort, logs.requestID)
+		resp := runContainer(container, agentPort, logs.requestID)
+		if resp.Err != nil || resp.StatusCode != http.StatusCreated {
+			failure := newReplicaFailure(resp, name, agent.Port)
+			logs.warnf("reschedule of container %s failed: %s", name, failure.Message)
+			schedulingFailed(SCHEDULING_AGENT_FAILURE, 1)
+			failed++
+			continue
+		}
+
+		stateLock.Lock()
+		moveContainerState(lost, agent, container)
+		stateLock.Unlock()
+		logs.infof("container %s rescheduled from agent %s to agent %s", name, lost.ID, agent.ID)
+	}
+
+	writeDataToJSON()
+	return failed
+}
+
+// moveContainerState records a container now running on another agent, must be called with stateLock held
+func moveContainerState(from *Agent, to *Agent, container Container) {
+	delete(from.MapContainerName, containerName(&container))
+	updateAllDataByContainer(&container, to)
+
+	key := container.configurationKey()
+	if configurationAgent, ok := mapConfigurationToAgents[key]; ok && !agentRunsConfiguration(from, key) {
+		if i := checkAgentExists(from, configurationAgent.AgentArray); i != -1 {
+			configurationAgent.AgentArray = append(configurationAgent.AgentArray[:i], configurationAgent.AgentArray[i+1:]...)
+		}
+	}
+}
+
+// agentRunsConfiguration must be called with stateLock held
+func agentRunsConfiguration(agent *Agent, key string) bool {
+	for _, container := range agent.MapContainerName {
+		if container.configurationKey() == key {
+			return true
+		}
+	}
+	return false
+}
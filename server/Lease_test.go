@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAgentLeaseExpiry(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 4, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	defer func(lease time.Duration, gracePeriod time.Duration) {
+		agentLease, agentGracePeriod = lease, gracePeriod
+	}(agentLease, agentGracePeriod)
+	agentLease, agentGracePeriod = time.Minute, time.Minute
+
+	agentState := func(id string) (string, int) {
+		stateLock.RLock()
+		defer stateLock.RUnlock()
+		agent := agentByID(id)
+		return agent.State, len(agent.MapContainerName)
+	}
+	silence := func(id string, duration time.Duration) {
+		stateLock.Lock()
+		agentByID(id).LastHeartbeat = time.Now().UTC().Add(-duration)
+		stateLock.Unlock()
+	}
+
+	// the other agent keeps sending heartbeats
+	heartbeat := func() {
+		if status, body := doRequest(t, http.MethodPost, server.URL+"/agentHeartbeat", Heartbeat{ID: "agent-1", Port: agents[1].port(), Ready: true}); status != http.StatusNoContent {
+			t.Fatalf("heartbeat: status %d %s", status, body)
+		}
+	}
+
+	silence("agent-0", 30*time.Second)
+	heartbeat()
+	checkAgentLeases()
+	if state, containers := agentState("agent-0"); state != AGENT_READY || containers != 2 {
+		t.Fatalf("agent within its lease is %s with %d containers", state, containers)
+	}
+
+	silence("agent-0", 90*time.Second)
+	heartbeat()
+	checkAgentLeases()
+	if state, containers := agentState("agent-0"); state != AGENT_UNKNOWN || containers != 2 {
+		t.Fatalf("agent in its grace period is %s with %d containers", state, containers)
+	}
+
+	silence("agent-0", 3*time.Minute)
+	heartbeat()
+	checkAgentLeases()
+	if state, containers := agentState("agent-0"); state != AGENT_LOST || containers != 0 {
+		t.Fatalf("agent after its grace period is %s with %d containers", state, containers)
+	}
+	if state, containers := agentState("agent-1"); state != AGENT_READY || containers != 4 {
+		t.Fatalf("containers were not rescheduled, agent-1 is %s with %d containers", state, containers)
+	}
+
+	// a lost agent that comes back is ready again, an unknown one has to register
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/agentHeartbeat", Heartbeat{ID: "agent-0", Port: agents[0].port(), Ready: true}); status != http.StatusNoContent {
+		t.Fatalf("heartbeat of lost agent: status %d %s", status, body)
+	}
+	if state, _ := agentState("agent-0"); state != AGENT_READY {
+		t.Fatalf("returning agent is %s", state)
+	}
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/agentHeartbeat", Heartbeat{ID: "unknown", Port: 1, Ready: true}); status != http.StatusNotFound {
+		t.Fatalf("heartbeat of unknown agent: status %d %s", status, body)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useLog collects the log at the level for the test
+func useLog(t *testing.T, level string) *bytes.Buffer {
+	var out bytes.Buffer
+	logLock.Lock()
+	logOutput, logLevel = &out, level
+	logLock.Unlock()
+	t.Cleanup(func() {
+		logLock.Lock()
+		logOutput, logLevel = ioutil.Discard, LEVEL_INFO
+		logLock.Unlock()
+	})
+	return &out
+}
+
+func readLog(t *testing.T, out *bytes.Buffer) []LogEntry {
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	entries := make([]LogEntry, 0)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q isn't JSON: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func sendWithRequestID(t *testing.T, method string, requestURL string, requestID string, payload interface{}) *http.Response {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(payload)
+	request, _ := http.NewRequest(method, requestURL, &body)
+	request.Header.Set(REQUEST_ID_HEADER, requestID)
+	resp, err := testClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRequestIDReachesTheAgents(t *testing.T) {
+	agent := newFakeAgent(t)
+	server := newTestServer(t, agent)
+	out := useLog(t, LEVEL_INFO)
+
+	resp := sendWithRequestID(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", "cli-0123", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Header.Get(REQUEST_ID_HEADER); got != "cli-0123" {
+		t.Fatalf("response request ID %q", got)
+	}
+	operation := waitForOperation(t, server.URL, resp.StatusCode, body)
+	if operation.Status != OPERATION_SUCCEEDED || operation.RequestID != "cli-0123" {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	resp = sendWithRequestID(t, http.MethodDelete, server.URL+API_V1_PREFIX+"/configurations/web", "cli-4567", nil)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	waitForOperation(t, server.URL, resp.StatusCode, body)
+
+	agent.lock.Lock()
+	requestIDs := strings.Join(agent.requestIDs, ",")
+	agent.lock.Unlock()
+	if requestIDs != "cli-0123,cli-0123,cli-4567,cli-4567" {
+		t.Fatalf("the agent got the request IDs %s", requestIDs)
+	}
+
+	logged := false
+	for _, entry := range readLog(t, out) {
+		if entry.Level == LEVEL_DEBUG {
+			t.Errorf("debug entry at the info level: %+v", entry)
+		}
+		if entry.RequestID == "cli-0123" && entry.Level == LEVEL_INFO && entry.Component == "server" && strings.HasPrefix(entry.Caller, "main.go:") {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Fatalf("no info entry of the create request:\n%s", out)
+	}
+}
+
+func TestInvalidRequestIDIsReplaced(t *testing.T) {
+	server := newTestServer(t)
+	out := useLog(t, LEVEL_DEBUG)
+
+	resp := sendWithRequestID(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations", `"}{"Level":"error`, nil)
+	resp.Body.Close()
+	requestID := resp.Header.Get(REQUEST_ID_HEADER)
+	if !requestIDPattern.MatchString(requestID) {
+		t.Fatalf("request ID %q kept", requestID)
+	}
+
+	entries := readLog(t, out)
+	if len(entries) == 0 || entries[0].Level != LEVEL_DEBUG || entries[0].RequestID != requestID {
+		t.Fatalf("log of the request: %+v", entries)
+	}
+}
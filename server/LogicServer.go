@@ -1,314 +1,486 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"net/http"
-	"os/exec"
-	"sort"
-	"strconv"
-	"os"
-)
-
-type ConfigurationAgent struct {
-	Configuration *Configuration
-	AgentArray    []*Agent
-}
-
-type Configuration struct {
-	Name   string `yaml:"Name"`
-	Amount int    `yaml:"Amount"`
-	Image  string `yaml:"Image"`
-}
-
-type Agent struct {
-	MapContainerName map[string]*Container
-	Port             int
-	Active           bool
-}
-
-type Container struct {
-	Index             int
-	ConfigurationName string
-	Image             string
-}
-
-func containerName(container *Container) string {
-	return container.ConfigurationName + strconv.Itoa(container.Index)
-}
-
-func (container *Container) checkIndexCorrectness(startIndex int, endIndex int) bool {
-	return startIndex <= container.Index
-}
-
-func removeConfiguration(configurationName string, containerStartIndex int) (bool, string) {
-	allSucceed := true
-	errorReturn := ""
-	if configurationAgent, ok := mapConfigurationToAgents[configurationName]; ok {
-
-		for i := 0; i < len(configurationAgent.AgentArray); i++ {
-			agent := configurationAgent.AgentArray[i]
-
-			for j := containerStartIndex; j <= configurationAgent.Configuration.Amount; j++ {
-				containerNameToCheck := configurationName + strconv.Itoa(j)
-
-				if _, ok := agent.MapContainerName[containerNameToCheck]; ok {
-					resp := deleteContainer(containerNameToCheck, strconv.Itoa(agent.Port))
-
-					if resp.StatusCode == http.StatusCreated {
-						delete(agent.MapContainerName, containerNameToCheck)
-						log.Printf("container %s deleted ", containerNameToCheck)
-					} else {
-						allSucceed = false
-						errorReturn = fmt.Sprintf("delete %s container failed", containerNameToCheck)
-						log.Printf("delete %s container failed", containerNameToCheck)
-					}
-				}
-			}
-		}
-
-		if allSucceed {
-			if containerStartIndex == 1 {
-				// means the configuration needs to be delete from the main map
-				delete(mapConfigurationToAgents, configurationName)
-			}
-
-			return allSucceed, errorReturn
-		}
-		return allSucceed, errorReturn
-
-	}
-	return false, "No such configuration in the system"
-}
-
-func sortAgentsByContainerAmount(agentArray []*Agent) {
-	sort.SliceStable(agentArray, func(i, j int) bool {
-		return len(agentArray[i].MapContainerName) < len(agentArray[j].MapContainerName)
-	})
-}
-
-func checkCreateParamValidity(configuration *Configuration, startIndexContainer int) (bool, string) {
-	isValid, errorMessage := checkAmountImageNameValdity(configuration)
-	if !isValid {
-		return false, errorMessage
-	}
-
-	_, ok := mapConfigurationToAgents[configuration.Name]
-	if startIndexContainer == 1 {
-		if ok {
-			//Intended to create new configuration but it already in our system
-			return false, "the configuration already exists in the system"
-		}
-		return true, ""
-
-	} else if ok {
-		return true, ""
-	}
-
-	// which mean 1 < start index than it must be update call, therefore the configuration must be in the system
-	return false, "the configuration doesn't exists in the system"
-}
-
-func createConfigurationToAgents(configuration *Configuration, startIndexContainer int) (bool, string) {
-	paramValidity, errorMessage := checkCreateParamValidity(configuration, startIndexContainer)
-
-	if !paramValidity {
-		return false, errorMessage
-	}
-
-	sortAgentsByContainerAmount(agentsArray)
-
-	if len(agentsArray) == 0 {
-		return false, "No agents available"
-	}
-
-	i := 0
-	allErrorMessagesFromAgents := ""
-	allContainersSucceed := true
-	for i+startIndexContainer <= configuration.Amount {
-
-		agentIndex := i % len(agentsArray)
-		containersSucceed, errorCommand := commandToAgentByConfiguration(configuration, agentIndex, startIndexContainer+i)
-		allErrorMessagesFromAgents = fmt.Sprintf("%s \n %s", allErrorMessagesFromAgents, errorCommand)
-
-		if !containersSucceed {
-			log.Printf(errorCommand)
-			containersSucceed = false
-		}
-
-		i++
-		allContainersSucceed = allContainersSucceed && containersSucceed
-	}
-
-	if !allContainersSucceed {
-		delete(mapConfigurationToAgents, configuration.Name)
-	}
-
-	return allContainersSucceed, allErrorMessagesFromAgents
-}
-
-func createAgents() {
-	agentsArray = make([]*Agent, 0)
-	for i := 0; i < AGENTS_AMOUNTS; i++ {
-		createAgent()
-	}
-}
-
-func createAgent() {
-	cmd := exec.Command(AGENT_PATH, PORT)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("start cmd agent with pid=%d started \n", cmd.Process.Pid)
-}
-
-func commandToAgentByConfiguration(configuration *Configuration, indexAgent int, indexContainer int) (bool, string) {
-
-	if indexContainer == 1 {
-		_, ok := mapConfigurationToAgents[configuration.Name]
-		// creation of the configuration in the map configuration to agents
-		if ok == false {
-			configurationAgent := new(ConfigurationAgent)
-			configurationAgent.AgentArray = make([]*Agent, 0)
-			configurationAgent.Configuration = configuration
-			mapConfigurationToAgents[configuration.Name] = configurationAgent
-		}
-	}
-
-	//create the container struct for the agent
-	var containerToSend *Container
-	containerToSend = new(Container)
-	agentPort := strconv.Itoa(agentsArray[indexAgent].Port)
-	containerToSend.Index = indexContainer
-	containerToSend.ConfigurationName = configuration.Name
-	containerToSend.Image = configuration.Image
-
-	resp := runContainer(*containerToSend, agentPort)
-
-	if resp.StatusCode == http.StatusCreated {
-
-		// container created then update the server database
-		updateAllDataByContainer(containerToSend, agentsArray[indexAgent])
-		log.Printf("container created by agent on port %s", agentPort)
-		return true, fmt.Sprintf("container created by agent on port %s", agentPort)
-	}
-
-	log.Printf("container failed by agent on port %s", agentPort)
-
-	defer resp.Body.Close()
-	return false, fmt.Sprintf("container failed by agent on port %s", agentPort)
-
-}
-
-func updateAllDataByContainer(container *Container, agent *Agent) {
-	//update agent
-	agent.MapContainerName[containerName(container)] = container
-
-	//update map
-	if val, ok := mapConfigurationToAgents[container.ConfigurationName]; ok {
-
-		//configuration exists in map
-		i := checkAgentExists(agent, val.AgentArray)
-
-		// the agent is not in the configuration map , need to add the agent
-		if i == -1 {
-			val.AgentArray = append(val.AgentArray, agent)
-		}
-	}
-}
-
-func getStatusByConfiguration(configurationName string, status **ConfigurationAgent) bool {
-	if val, ok := mapConfigurationToAgents[configurationName]; ok {
-		*status = val
-		return true
-	}
-	return false
-}
-
-func checkAgentExists(agent *Agent, agentArrayInConfigurationMap []*Agent) int {
-	for i := 0; i < len(agentArrayInConfigurationMap); i++ {
-		if agentsArray[i].Port == agent.Port {
-			return i
-		}
-	}
-	return -1
-}
-
-//function for debugging
-// func printAllDB() {
-// 	fmt.Println("new print")
-// 	for configurationName, configurationAgent := range mapConfigurationToAgents {
-// 		fmt.Printf("configurationName %s \n", configurationName)
-// 		if configurationAgent.Configuration != nil {
-// 			fmt.Printf("Image %s\n", configurationAgent.Configuration.Image)
-// 			fmt.Printf("Amount %d\n", configurationAgent.Configuration.Amount)
-// 		}
-// 		if 0 < len(configurationAgent.AgentArray) {
-// 			for i := 0; i < len(configurationAgent.AgentArray); i++ {
-// 				for containerName, container := range configurationAgent.AgentArray[i].MapContainerName {
-// 					fmt.Printf("container name %s\n", containerName)
-// 					fmt.Println(container)
-// 				}
-// 			}
-// 		}
-// 	}
-// }
-
-func checkAmountImageNameValdity(configuration *Configuration) (bool, string) {
-	if configuration.Image == "" {
-		return false, "there is no image in your YAML file"
-	}
-
-	if configuration.Name == "" {
-		return false, "there is no name in your YAML file"
-	}
-
-	if configuration.Amount < 0 {
-		return false, "amount must be above zero"
-	}
-
-	return true, ""
-}
-
-func update(configuration *Configuration) (bool, string) {
-	isValid, errorMessage := checkAmountImageNameValdity(configuration)
-	if !isValid {
-		return false, errorMessage
-	}
-
-	if val, ok := mapConfigurationToAgents[configuration.Name]; ok {
-		if configuration.Image != val.Configuration.Image {
-
-			// Different image , all the containers that belongs to the old configuration have to delete
-			updateSucceed, errorMessage := removeConfiguration(configuration.Name, 1)
-
-			if updateSucceed {
-				updateSucceed, errorMessage := createConfigurationToAgents(configuration, 1)
-				return updateSucceed, errorMessage
-			}
-
-			return updateSucceed, errorMessage
-		}
-
-		//same image , need to check the difference in the amount
-		if val.Configuration.Amount < configuration.Amount {
-
-			// need to create more containers
-			oldAmount := val.Configuration.Amount
-			val.Configuration.Amount = configuration.Amount
-			updateSucceed, errorMessage := createConfigurationToAgents(val.Configuration, oldAmount+1)
-			return updateSucceed, errorMessage
-		}
-
-		//need to delete containers
-		updateSucceed, errorMessage := removeConfiguration(configuration.Name, configuration.Amount+1)
-		val.Configuration.Amount = configuration.Amount
-		return updateSucceed, errorMessage
-
-	}
-
-	return false, "No such configuration"
-}
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ConfigurationAgent struct {
+	Configuration *Configuration
+	AgentArray    []*Agent
+}
+
+type Configuration struct {
+	Name string `yaml:"Name"`
+	// names are unique per namespace, DEFAULT_NAMESPACE when empty
+	Namespace string `yaml:"Namespace"`
+	Amount    int    `yaml:"Amount"`
+	Image     string `yaml:"Image"`
+	// seconds between SIGTERM and SIGKILL when a container stops, DEFAULT_TERMINATION_GRACE_PERIOD when zero
+	TerminationGracePeriod int            `yaml:"TerminationGracePeriod" json:",omitempty"`
+	PreStop                *PreStopHook   `yaml:"PreStop" json:",omitempty"`
+	Volumes                []VolumeMount  `yaml:"Volumes" json:",omitempty"`
+	ConfigMaps             []ConfigMapRef `yaml:"ConfigMaps" json:",omitempty"`
+	Secrets                []SecretRef    `yaml:"Secrets" json:",omitempty"`
+	// Resources are requested by every replica
+	Resources Resources `yaml:"Resources"`
+}
+
+// PreStopHook runs before a container gets SIGTERM, a command inside it or an HTTP GET to it
+type PreStopHook struct {
+	Exec []string     `yaml:"Exec" json:",omitempty"`
+	HTTP *HTTPGetHook `yaml:"HTTP" json:",omitempty"`
+}
+
+type HTTPGetHook struct {
+	Path string `yaml:"Path"`
+	Port int    `yaml:"Port"`
+}
+
+type Agent struct {
+	ID               string
+	MapContainerName map[string]*Container
+	Port             int
+	// Active is true when State is Ready, kept for the clients from before the states
+	Active        bool
+	State         string
+	Message       string `json:",omitempty"`
+	LastHeartbeat time.Time
+	// a cordoned agent keeps its containers but gets no new ones
+	Unschedulable bool
+}
+
+// AgentRegistration is sent by an agent when it starts, ID is generated by the agent on its
+// first start and kept in its ID file
+type AgentRegistration struct {
+	ID   string
+	Port int
+}
+
+type Container struct {
+	Index                  int
+	ConfigurationName      string
+	Namespace              string `json:",omitempty"`
+	Image                  string
+	TerminationGracePeriod int            `json:",omitempty"`
+	PreStop                *PreStopHook   `json:",omitempty"`
+	Volumes                []VolumeMount  `json:",omitempty"`
+	ConfigMaps             []ConfigMapRef `json:",omitempty"`
+	Secrets                []SecretRef    `json:",omitempty"`
+}
+
+// containerName is the docker name of the container, unique on the host since neither namespaces nor
+// configuration names have '_' and the names end with a letter, see checkConfigurationNameValidity.
+// the containers of the default namespace keep the names they had before namespaces
+func containerName(container *Container) string {
+	name := container.ConfigurationName + strconv.Itoa(container.Index)
+	if container.Namespace == "" || container.Namespace == DEFAULT_NAMESPACE {
+		return name
+	}
+	return container.Namespace + "_" + name
+}
+
+func (container *Container) checkIndexCorrectness(startIndex int, endIndex int) bool {
+	return startIndex <= container.Index
+}
+
+// removeConfiguration deletes the containers of the configuration with key from containerStartIndex on,
+// and the configuration itself when it is 1
+func removeConfiguration(operation *Operation, key string, containerStartIndex int) *APIError {
+	type containerToDelete struct {
+		agent     *Agent
+		port      int
+		name      string
+		container Container
+	}
+
+	stateLock.RLock()
+	configurationAgent, ok := mapConfigurationToAgents[key]
+	containersToDelete := make([]containerToDelete, 0)
+	if ok {
+		for i := 0; i < len(configurationAgent.AgentArray); i++ {
+			agent := configurationAgent.AgentArray[i]
+
+			for j := containerStartIndex; j <= configurationAgent.Configuration.Amount; j++ {
+				containerNameToCheck := configurationAgent.Configuration.containerName(j)
+
+				if container, ok := agent.MapContainerName[containerNameToCheck]; ok {
+					containersToDelete = append(containersToDelete, containerToDelete{agent: agent, port: agent.Port, name: containerNameToCheck, container: *container})
+				}
+			}
+		}
+	}
+	stateLock.RUnlock()
+
+	if !ok {
+		return configurationNotFoundError(key)
+	}
+
+	for _, container := range containersToDelete {
+		operation.replicaPending(OPERATION_DELETE, container.name, container.port)
+	}
+
+	failures := fanOut(len(containersToDelete), func(i int) *ReplicaFailure {
+		container := containersToDelete[i]
+		resp := deleteContainer(container.container, strconv.Itoa(container.port), operation.requestID())
+
+		if resp.Err == nil && resp.StatusCode == http.StatusOK {
+			stateLock.Lock()
+			delete(container.agent.MapContainerName, container.name)
+			stateLock.Unlock()
+			operation.replicaDone(OPERATION_DELETE, container.name, nil)
+			operation.log().infof("container %s deleted", container.name)
+			return nil
+		}
+
+		operation.log().warnf("delete %s container failed", container.name)
+		failure := newReplicaFailure(resp, container.name, container.port)
+		operation.replicaDone(OPERATION_DELETE, container.name, &failure)
+		return &failure
+	})
+
+	if len(failures) != 0 {
+		return replicaFailuresError(fmt.Sprintf("delete of %d containers failed", len(failures)), failures)
+	}
+
+	if containerStartIndex == 1 {
+		// means the configuration needs to be delete from the main map
+		stateLock.Lock()
+		delete(mapConfigurationToAgents, key)
+		stateLock.Unlock()
+	}
+	return nil
+}
+
+func checkCreateParamValidity(configuration *Configuration, startIndexContainer int) *APIError {
+	if apiError := checkAmountImageNameValdity(configuration); apiError != nil {
+		return apiError
+	}
+
+	ok := configurationExists(configuration.key())
+	if startIndexContainer == 1 {
+		if ok {
+			//Intended to create new configuration but it already in our system
+			return newAPIError(http.StatusConflict, ERROR_ALREADY_EXISTS, fmt.Sprintf("configuration %s already exists in the system", configuration.key()))
+		}
+		return checkQuota(configuration)
+
+	} else if ok {
+		return checkQuota(configuration)
+	}
+
+	// which mean 1 < start index than it must be update call, therefore the configuration must be in the system
+	return configurationNotFoundError(configuration.key())
+}
+
+func createConfigurationToAgents(operation *Operation, configuration *Configuration, startIndexContainer int) *APIError {
+	if apiError := checkCreateParamValidity(configuration, startIndexContainer); apiError != nil {
+		return apiError
+	}
+
+	stateLock.Lock()
+	agentArray := sortedAgentsByContainerAmount()
+
+	if len(agentArray) == 0 {
+		stateLock.Unlock()
+		schedulingFailed(SCHEDULING_NO_AGENTS, configuration.Amount-startIndexContainer+1)
+		return newAPIError(http.StatusServiceUnavailable, ERROR_NO_AGENTS, "No agents available")
+	}
+
+	if startIndexContainer == 1 {
+		// checked again now that no other create of the namespace can slip in
+		if apiError := quotaExceeded(configuration); apiError != nil {
+			stateLock.Unlock()
+			return apiError
+		}
+
+		// creation of the configuration in the map configuration to agents
+		configurationAgent := new(ConfigurationAgent)
+		configurationAgent.AgentArray = make([]*Agent, 0)
+		configurationAgent.Configuration = configuration
+		mapConfigurationToAgents[configuration.key()] = configurationAgent
+	}
+	agentPorts := make([]int, len(agentArray))
+	for i, agent := range agentArray {
+		agentPorts[i] = agent.Port
+	}
+	stateLock.Unlock()
+
+	for i := 0; i+startIndexContainer <= configuration.Amount; i++ {
+		operation.replicaPending(OPERATION_CREATE, configuration.containerName(startIndexContainer+i), agentPorts[i%len(agentPorts)])
+	}
+
+	failures := fanOut(configuration.Amount-startIndexContainer+1, func(i int) *ReplicaFailure {
+		agent := agentArray[i%len(agentArray)]
+		failure := commandToAgentByConfiguration(operation, configuration, agent, startIndexContainer+i)
+		operation.replicaDone(OPERATION_CREATE, configuration.containerName(startIndexContainer+i), failure)
+		return failure
+	})
+
+	if len(failures) != 0 {
+		schedulingFailed(SCHEDULING_AGENT_FAILURE, len(failures))
+		stateLock.Lock()
+		delete(mapConfigurationToAgents, configuration.key())
+		stateLock.Unlock()
+		return replicaFailuresError(fmt.Sprintf("%d of the containers failed to start", len(failures)), failures)
+	}
+
+	return nil
+}
+
+// newUUID is the ID of an agent registering without one, the agents generate theirs the same way
+func newUUID() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", err
+	}
+
+	// version 4, variant RFC 4122
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// agentByID must be called with stateLock held
+func agentByID(id string) *Agent {
+	for _, agent := range agentsArray {
+		if agent.ID == id {
+			return agent
+		}
+	}
+	return nil
+}
+
+func commandToAgentByConfiguration(operation *Operation, configuration *Configuration, agent *Agent, indexContainer int) *ReplicaFailure {
+	stateLock.RLock()
+	agentPortNumber := agent.Port
+	stateLock.RUnlock()
+
+	//create the container struct for the agent
+	var containerToSend *Container
+	containerToSend = new(Container)
+	agentPort := strconv.Itoa(agentPortNumber)
+	containerToSend.Index = indexContainer
+	containerToSend.ConfigurationName = configuration.Name
+	containerToSend.Namespace = configuration.Namespace
+	containerToSend.Image = configuration.Image
+	containerToSend.TerminationGracePeriod = configuration.TerminationGracePeriod
+	containerToSend.PreStop = configuration.PreStop
+	containerToSend.Volumes = configuration.Volumes
+	containerToSend.ConfigMaps = configuration.ConfigMaps
+	containerToSend.Secrets = configuration.Secrets
+
+	resp := runContainer(*containerToSend, agentPort, operation.requestID())
+
+	if resp.Err == nil && resp.StatusCode == http.StatusCreated {
+
+		// container created then update the server database
+		stateLock.Lock()
+		updateAllDataByContainer(containerToSend, agent)
+		stateLock.Unlock()
+		operation.log().infof("container created by agent on port %s", agentPort)
+		return nil
+	}
+
+	operation.log().warnf("container failed by agent on port %s", agentPort)
+
+	failure := newReplicaFailure(resp, containerName(containerToSend), agentPortNumber)
+	return &failure
+
+}
+
+// updateAllDataByContainer must be called with stateLock held
+func updateAllDataByContainer(container *Container, agent *Agent) {
+	//update agent
+	agent.MapContainerName[containerName(container)] = container
+
+	//update map
+	if val, ok := mapConfigurationToAgents[container.configurationKey()]; ok {
+
+		//configuration exists in map
+		i := checkAgentExists(agent, val.AgentArray)
+
+		// the agent is not in the configuration map , need to add the agent
+		if i == -1 {
+			val.AgentArray = append(val.AgentArray, agent)
+		}
+	}
+}
+
+// getStatusByConfiguration must be called with stateLock held, status points into the server state
+func getStatusByConfiguration(key string, status **ConfigurationAgent) bool {
+	if val, ok := mapConfigurationToAgents[key]; ok {
+		*status = val
+		return true
+	}
+	return false
+}
+
+// getAgentByContainer returns the port of the agent running the container and the container name
+func getAgentByContainer(key string, index int) (int, string, bool) {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	configurationAgent, ok := mapConfigurationToAgents[key]
+	if !ok {
+		return 0, "", false
+	}
+
+	containerNameToFind := configurationAgent.Configuration.containerName(index)
+	for _, agent := range configurationAgent.AgentArray {
+		if _, ok := agent.MapContainerName[containerNameToFind]; ok {
+			return agent.Port, containerNameToFind, true
+		}
+	}
+	return 0, "", false
+}
+
+func checkAgentExists(agent *Agent, agentArrayInConfigurationMap []*Agent) int {
+	for i := 0; i < len(agentArrayInConfigurationMap); i++ {
+		if agentArrayInConfigurationMap[i].ID == agent.ID {
+			return i
+		}
+	}
+	return -1
+}
+
+//function for debugging
+// func printAllDB() {
+// 	fmt.Println("new print")
+// 	for configurationName, configurationAgent := range mapConfigurationToAgents {
+// 		fmt.Printf("configurationName %s \n", configurationName)
+// 		if configurationAgent.Configuration != nil {
+// 			fmt.Printf("Image %s\n", configurationAgent.Configuration.Image)
+// 			fmt.Printf("Amount %d\n", configurationAgent.Configuration.Amount)
+// 		}
+// 		if 0 < len(configurationAgent.AgentArray) {
+// 			for i := 0; i < len(configurationAgent.AgentArray); i++ {
+// 				for containerName, container := range configurationAgent.AgentArray[i].MapContainerName {
+// 					fmt.Printf("container name %s\n", containerName)
+// 					fmt.Println(container)
+// 				}
+// 			}
+// 		}
+// 	}
+// }
+
+func checkAmountImageNameValdity(configuration *Configuration) *APIError {
+	if configuration.Image == "" {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "there is no image in your YAML file")
+	}
+
+	if configuration.Name == "" {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "there is no name in your YAML file")
+	}
+
+	if configuration.Namespace != "" {
+		if apiError := checkNamespaceValidity(configuration.Namespace); apiError != nil {
+			return apiError
+		}
+	}
+
+	if configuration.Amount < 0 {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "amount must be above zero")
+	}
+
+	if configuration.TerminationGracePeriod < 0 {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "termination grace period can't be negative")
+	}
+
+	if hook := configuration.PreStop; hook != nil {
+		if (len(hook.Exec) == 0) == (hook.HTTP == nil) {
+			return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "pre-stop hook needs either Exec or HTTP")
+		}
+		if hook.HTTP != nil && (hook.HTTP.Port <= 0 || hook.HTTP.Port > 65535) {
+			return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "pre-stop HTTP hook needs a valid port")
+		}
+	}
+
+	if apiError := checkResourcesValidity(configuration.Resources); apiError != nil {
+		return apiError
+	}
+
+	if apiError := checkVolumesValidity(configuration.Volumes); apiError != nil {
+		return apiError
+	}
+
+	if apiError := checkConfigMapReferences(configuration); apiError != nil {
+		return apiError
+	}
+
+	return checkSecretReferences(configuration)
+}
+
+func update(operation *Operation, configuration *Configuration) *APIError {
+	if apiError := checkAmountImageNameValdity(configuration); apiError != nil {
+		return apiError
+	}
+
+	// nothing is removed when the new spec doesn't fit
+	if apiError := checkQuota(configuration); apiError != nil {
+		return apiError
+	}
+
+	stateLock.RLock()
+	val, ok := mapConfigurationToAgents[configuration.key()]
+	stateLock.RUnlock()
+
+	// the caller holds the configuration lock, nobody else writes val.Configuration
+	if ok {
+		if configuration.Image != val.Configuration.Image {
+
+			// Different image , all the containers that belongs to the old configuration have to delete
+			if apiError := removeConfiguration(operation, configuration.key(), 1); apiError != nil {
+				return apiError
+			}
+
+			return createConfigurationToAgents(operation, configuration, 1)
+		}
+
+		// the running containers keep the stop settings, volumes, config maps and secrets they were created with, new ones get these
+		stateLock.Lock()
+		val.Configuration.TerminationGracePeriod = configuration.TerminationGracePeriod
+		val.Configuration.PreStop = configuration.PreStop
+		val.Configuration.Volumes = configuration.Volumes
+		val.Configuration.ConfigMaps = configuration.ConfigMaps
+		val.Configuration.Secrets = configuration.Secrets
+		stateLock.Unlock()
+
+		//same image , need to check the difference in the amount
+		if val.Configuration.Amount < configuration.Amount {
+
+			// need to create more containers, the quota is checked again with the new amount in place
+			oldAmount := val.Configuration.Amount
+			stateLock.Lock()
+			if apiError := quotaExceeded(configuration); apiError != nil {
+				stateLock.Unlock()
+				return apiError
+			}
+			val.Configuration.Amount = configuration.Amount
+			val.Configuration.Resources = configuration.Resources
+			stateLock.Unlock()
+			return createConfigurationToAgents(operation, val.Configuration, oldAmount+1)
+		}
+
+		//need to delete containers, the resources may still grow so the quota is checked again with them in place
+		stateLock.Lock()
+		if apiError := quotaExceeded(configuration); apiError != nil {
+			stateLock.Unlock()
+			return apiError
+		}
+		val.Configuration.Resources = configuration.Resources
+		stateLock.Unlock()
+
+		apiError := removeConfiguration(operation, configuration.key(), configuration.Amount+1)
+		stateLock.Lock()
+		val.Configuration.Amount = configuration.Amount
+		stateLock.Unlock()
+		return apiError
+
+	}
+
+	return configurationNotFoundError(configuration.key())
+}
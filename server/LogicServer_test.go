@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestCheckAgentExistsLooksInTheGivenAgents(t *testing.T) {
+	first := &Agent{ID: "agent-0", Port: 40000}
+	second := &Agent{ID: "agent-1", Port: 40001}
+	third := &Agent{ID: "agent-2", Port: 40002}
+
+	stateLock.Lock()
+	agentsArray = []*Agent{first, second, third}
+	stateLock.Unlock()
+
+	// the agents of a configuration are not the first ones of agentsArray
+	configurationAgents := []*Agent{third, second}
+	if i := checkAgentExists(second, configurationAgents); i != 1 {
+		t.Fatalf("agent-1 is at 1 of the configuration agents, got %d", i)
+	}
+	if i := checkAgentExists(third, configurationAgents); i != 0 {
+		t.Fatalf("agent-2 is at 0 of the configuration agents, got %d", i)
+	}
+	if i := checkAgentExists(first, configurationAgents); i != -1 {
+		t.Fatalf("agent-0 doesn't run the configuration, got %d", i)
+	}
+	if i := checkAgentExists(first, nil); i != -1 {
+		t.Fatalf("no agent runs the configuration, got %d", i)
+	}
+}
+
+func TestAgentsRegisteringWithoutIDGetTheirOwn(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, port := range []string{"40100", "40101"} {
+		if status, body := doRequest(t, http.MethodPost, server.URL+"/agentPort", port); status != http.StatusCreated {
+			t.Fatalf("register %s: status %d %s", port, status, body)
+		}
+	}
+
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if len(agentsArray) != 2 || agentsArray[0].ID == agentsArray[1].ID {
+		t.Fatalf("expected two agents with their own ID, got %+v", agentsArray)
+	}
+	for _, agent := range agentsArray {
+		if !uuidPattern.MatchString(agent.ID) {
+			t.Errorf("agent ID %s is not a UUID", agent.ID)
+		}
+	}
+}
+
+func TestTerminationSettingsReachTheAgents(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	invalid := Configuration{Name: "web", Amount: 1, Image: "alpine", PreStop: &PreStopHook{Exec: []string{"true"}, HTTP: &HTTPGetHook{Path: "/", Port: 80}}}
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", invalid); status != http.StatusBadRequest {
+		t.Fatalf("expected a hook with both Exec and HTTP to be refused, got status %d %s", status, body)
+	}
+
+	configuration := Configuration{Name: "web", Amount: 2, Image: "alpine", TerminationGracePeriod: 5, PreStop: &PreStopHook{Exec: []string{"nginx", "-s", "quit"}}}
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	// scaling up with new stop settings applies them to the new replica only
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]int{"Amount": 3, "TerminationGracePeriod": 60})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("update: %+v", operation)
+	}
+
+	agents[0].lock.Lock()
+	defer agents[0].lock.Unlock()
+	for name, expected := range map[string]int{"web1": 5, "web2": 5, "web3": 60} {
+		container := agents[0].containers[name]
+		if container.TerminationGracePeriod != expected || container.PreStop == nil || len(container.PreStop.Exec) != 3 {
+			t.Fatalf("container %s reached the agent with %+v", name, container)
+		}
+	}
+}
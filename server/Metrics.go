@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// the metrics are served in the Prometheus text format, version 0.0.4
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+const AGENT_METRICS_TIMEOUT = 30 * time.Second
+
+// the reasons a replica couldn't be placed, the label of minikube_scheduling_failures_total
+const (
+	SCHEDULING_NO_AGENTS     = "no_agents"
+	SCHEDULING_AGENT_FAILURE = "agent_failure"
+)
+
+// the type of minikube_reconcile_duration_seconds for the containers of a lost agent, the others are operation types
+const RECONCILE_RESCHEDULE = "Reschedule"
+
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metricsLock guards the counters and histograms, the gauges are read from the state when scraped
+var metricsLock sync.Mutex
+
+type counterVec struct {
+	name   string
+	help   string
+	series map[string]float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+var requestDurations = &histogramVec{
+	name:    "minikube_http_request_duration_seconds",
+	help:    "Latency of the API requests by method and route.",
+	buckets: durationBuckets,
+	series:  make(map[string]*histogram),
+}
+
+var reconcileDurations = &histogramVec{
+	name:    "minikube_reconcile_duration_seconds",
+	help:    "Duration of the operations and of the rescheduling of lost agents by type and outcome.",
+	buckets: durationBuckets,
+	series:  make(map[string]*histogram),
+}
+
+var schedulingFailures = &counterVec{
+	name:   "minikube_scheduling_failures_total",
+	help:   "Replicas that couldn't be placed on an agent by reason.",
+	series: make(map[string]float64),
+}
+
+// labels renders the label pairs, names and values alternating
+func labels(pairs ...string) string {
+	rendered := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rendered = append(rendered, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+	return strings.Join(rendered, ",")
+}
+
+func (counter *counterVec) add(seriesLabels string, value float64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	counter.series[seriesLabels] += value
+}
+
+func (vec *histogramVec) observe(seriesLabels string, value float64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	series, ok := vec.series[seriesLabels]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(vec.buckets))}
+		vec.series[seriesLabels] = series
+	}
+	for i, bound := range vec.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func sortedKeys(series map[string]float64) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeSample(out io.Writer, name string, seriesLabels string, value float64) {
+	if seriesLabels == "" {
+		fmt.Fprintf(out, "%s %s\n", name, formatValue(value))
+		return
+	}
+	fmt.Fprintf(out, "%s{%s} %s\n", name, seriesLabels, formatValue(value))
+}
+
+func writeHeader(out io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeGauge writes a gauge from its series, rendered labels to value
+func writeGauge(out io.Writer, name string, help string, series map[string]float64) {
+	writeHeader(out, name, help, "gauge")
+	for _, key := range sortedKeys(series) {
+		writeSample(out, name, key, series[key])
+	}
+}
+
+// write must be called with metricsLock held
+func (counter *counterVec) write(out io.Writer) {
+	writeHeader(out, counter.name, counter.help, "counter")
+	for _, key := range sortedKeys(counter.series) {
+		writeSample(out, counter.name, key, counter.series[key])
+	}
+}
+
+// write must be called with metricsLock held
+func (vec *histogramVec) write(out io.Writer) {
+	writeHeader(out, vec.name, vec.help, "histogram")
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := vec.series[key]
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, bound := range vec.buckets {
+			writeSample(out, vec.name+"_bucket", prefix+labels("le", formatValue(bound)), float64(series.counts[i]))
+		}
+		writeSample(out, vec.name+"_bucket", prefix+labels("le", "+Inf"), float64(series.count))
+		writeSample(out, vec.name+"_sum", key, series.sum)
+		writeSample(out, vec.name+"_count", key, float64(series.count))
+	}
+}
+
+func schedulingFailed(reason string, replicas int) {
+	schedulingFailures.add(labels("reason", reason), float64(replicas))
+}
+
+func observeReconcile(reconcileType string, started time.Time, apiError *APIError) {
+	outcome := OPERATION_SUCCEEDED
+	if apiError != nil {
+		outcome = OPERATION_FAILED
+	}
+	reconcileDurations.observe(labels("type", reconcileType, "outcome", outcome), time.Since(started).Seconds())
+}
+
+// measureRequests is the middleware timing every request by its route, not its path, so the
+// names in the path don't make new series
+func measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseHTTP http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		next.ServeHTTP(responseHTTP, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		requestDurations.observe(labels("method", r.Method, "route", route), time.Since(started).Seconds())
+	})
+}
+
+// writeStateMetrics writes the gauges read from the state
+func writeStateMetrics(out io.Writer) {
+	configurations := make(map[string]float64)
+	desired := make(map[string]float64)
+	running := make(map[string]float64)
+	agentStates := map[string]float64{
+		labels("state", AGENT_READY):     0,
+		labels("state", AGENT_NOT_READY): 0,
+		labels("state", AGENT_UNKNOWN):   0,
+		labels("state", AGENT_LOST):      0,
+	}
+	agentUp := make(map[string]float64)
+
+	stateLock.RLock()
+	runningByKey := make(map[string]int)
+	for _, agent := range agentsArray {
+		agentStates[labels("state", agent.State)]++
+		up := 0.0
+		if agent.State == AGENT_READY {
+			up = 1
+			for _, container := range agent.MapContainerName {
+				runningByKey[container.configurationKey()]++
+			}
+		}
+		agentUp[labels("agent", agent.ID)] = up
+	}
+	for key, configurationAgent := range mapConfigurationToAgents {
+		configuration := configurationAgent.Configuration
+		configurationLabels := labels("namespace", configuration.Namespace, "configuration", configuration.Name)
+		configurations[labels("namespace", configuration.Namespace)]++
+		desired[configurationLabels] = float64(configuration.Amount)
+		running[configurationLabels] = float64(runningByKey[key])
+	}
+	stateLock.RUnlock()
+
+	writeGauge(out, "minikube_configurations", "Configurations by namespace.", configurations)
+	writeGauge(out, "minikube_replicas_desired", "Replicas the configuration asks for.", desired)
+	writeGauge(out, "minikube_replicas_running", "Replicas of the configuration on Ready agents.", running)
+	writeGauge(out, "minikube_agents", "Agents by state.", agentStates)
+	writeGauge(out, "minikube_agent_up", "1 when the agent is Ready, 0 otherwise.", agentUp)
+}
+
+func metricsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var out bytes.Buffer
+	writeStateMetrics(&out)
+
+	metricsLock.Lock()
+	requestDurations.write(&out)
+	reconcileDurations.write(&out)
+	schedulingFailures.write(&out)
+	metricsLock.Unlock()
+
+	responseHTTP.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	responseHTTP.WriteHeader(http.StatusOK)
+	responseHTTP.Write(out.Bytes())
+}
+
+// agentMetricsEndPoint passes the metrics of an agent on, only the server may call the agents
+func agentMetricsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	agentID := mux.Vars(r)["id"]
+
+	stateLock.RLock()
+	agent := agentByID(agentID)
+	agentPort := 0
+	if agent != nil {
+		agentPort = agent.Port
+	}
+	stateLock.RUnlock()
+
+	if agent == nil {
+		respondWithError(responseHTTP, agentNotFoundError(agentID))
+		return
+	}
+
+	resp, err := agentHTTPClient(AGENT_METRICS_TIMEOUT).Get(fmt.Sprintf("%s%d/metrics", agentBaseURL(), agentPort))
+	if err != nil {
+		requestLog(r).warnf("metrics of agent %s: %s", agentID, err)
+		respondWithError(responseHTTP, newAPIError(http.StatusBadGateway, ERROR_AGENT_FAILURE, fmt.Sprintf("agent on port %d is not responding", agentPort)))
+		return
+	}
+	defer resp.Body.Close()
+
+	responseHTTP.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	responseHTTP.WriteHeader(resp.StatusCode)
+	io.Copy(responseHTTP, resp.Body)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 3, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	stateLock.Lock()
+	agentsArray[1].State, agentsArray[1].Active = AGENT_UNKNOWN, false
+	stateLock.Unlock()
+
+	status, body = doRequest(t, http.MethodGet, server.URL+"/metrics", nil)
+	if status != http.StatusOK {
+		t.Fatalf("metrics: status %d %s", status, body)
+	}
+	metrics := string(body)
+	for _, line := range []string{
+		`minikube_configurations{namespace="default"} 1`,
+		`minikube_replicas_desired{namespace="default",configuration="web"} 3`,
+		// the replicas of the Unknown agent aren't counted as running
+		`minikube_replicas_running{namespace="default",configuration="web"} 2`,
+		`minikube_agents{state="Ready"} 1`,
+		`minikube_agents{state="Unknown"} 1`,
+		`minikube_agent_up{agent="agent-1"} 0`,
+		`# TYPE minikube_http_request_duration_seconds histogram`,
+		`minikube_http_request_duration_seconds_bucket{method="POST",route="/api/v1/configurations",le="+Inf"}`,
+		`minikube_reconcile_duration_seconds_count{type="Create",outcome="Succeeded"}`,
+		`# TYPE minikube_scheduling_failures_total counter`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics miss %s:\n%s", line, metrics)
+		}
+	}
+
+	// the agents only answer the server, their metrics go through it
+	status, body = doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/agents/agent-0/metrics", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), `minikube_agent_containers{state="running"} 2`) {
+		t.Fatalf("agent metrics: status %d %s", status, body)
+	}
+	if status, _ := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/agents/agent-9/metrics", nil); status != http.StatusNotFound {
+		t.Fatalf("metrics of an unknown agent: status %d", status)
+	}
+}
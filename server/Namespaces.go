@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// the namespace of a configuration that doesn't name one
+const DEFAULT_NAMESPACE = "default"
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// configuration names end with a letter, the replica index follows them in the container name:
+// api with replica 11 and api1 with replica 1 would both be api11
+var configurationNamePattern = regexp.MustCompile(`^([a-z0-9][a-z0-9-]*)?[a-z]$`)
+
+// configurationKey identifies a configuration in mapConfigurationToAgents, its lock and its operations
+func configurationKey(namespace string, name string) string {
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	return namespace + "/" + name
+}
+
+func (configuration *Configuration) key() string {
+	return configurationKey(configuration.Namespace, configuration.Name)
+}
+
+// containerName is the name of a replica of the configuration, see containerName
+func (configuration *Configuration) containerName(index int) string {
+	return containerName(&Container{Namespace: configuration.Namespace, ConfigurationName: configuration.Name, Index: index})
+}
+
+func (container *Container) configurationKey() string {
+	return configurationKey(container.Namespace, container.ConfigurationName)
+}
+
+func checkNamespaceValidity(namespace string) *APIError {
+	if !namespacePattern.MatchString(namespace) || len(namespace) > 63 {
+		return invalidRequestError(fmt.Sprintf("namespace %q may only have lowercase letters, digits and '-'", namespace))
+	}
+	return nil
+}
+
+// checkConfigurationNameValidity keeps '_', which separates the namespace in the container name, and '/',
+// which separates it in the configuration key, out of the names
+func checkConfigurationNameValidity(name string) *APIError {
+	if !configurationNamePattern.MatchString(name) || len(name) > 63 {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf("configuration name %q may only have lowercase letters, digits and '-' and has to end with a letter", name))
+	}
+	return nil
+}
+
+// requestNamespace is the namespace a request is about, the namespace query parameter or the default one,
+// bodyNamespace is the namespace of the configuration in the body, if any, it has to agree with the query,
+// the caller has to have access to it
+func requestNamespace(r *http.Request, bodyNamespace string) (string, *APIError) {
+	namespace := r.URL.Query().Get("namespace")
+	if namespace != "" && bodyNamespace != "" && namespace != bodyNamespace {
+		return "", invalidRequestError(fmt.Sprintf("configuration namespace %s doesn't match the requested namespace %s", bodyNamespace, namespace))
+	}
+	if namespace == "" {
+		namespace = bodyNamespace
+	}
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	if apiError := checkNamespaceValidity(namespace); apiError != nil {
+		return "", apiError
+	}
+	if token := caller(r); !token.canAccess(namespace) {
+		return "", forbiddenError(fmt.Sprintf("%s has no access to namespace %s", token.Name, namespace))
+	}
+	return namespace, nil
+}
+
+// allNamespaces tells whether a list request is about every namespace
+func allNamespaces(r *http.Request) bool {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("allNamespaces"))
+	return all
+}
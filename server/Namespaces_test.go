@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSameNameInTwoNamespaces(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configurationsURL := server.URL + API_V1_PREFIX + "/configurations"
+
+	status, body := doRequest(t, http.MethodPost, configurationsURL, Configuration{Name: "web", Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create in default: %+v", operation)
+	}
+	status, body = doRequest(t, http.MethodPost, configurationsURL+"?namespace=team-a", Configuration{Name: "web", Amount: 1, Image: "nginx"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED || operation.Namespace != "team-a" {
+		t.Fatalf("create in team-a: %+v", operation)
+	}
+
+	if status, body := doRequest(t, http.MethodPost, configurationsURL+"?namespace=team-b", Configuration{Name: "web", Namespace: "team-a", Amount: 1, Image: "alpine"}); status != http.StatusBadRequest {
+		t.Fatalf("expected a body in another namespace to be refused, got status %d %s", status, body)
+	}
+	if status, body := doRequest(t, http.MethodPost, configurationsURL+"?namespace=Team_A", Configuration{Name: "db", Amount: 1, Image: "alpine"}); status != http.StatusBadRequest {
+		t.Fatalf("expected an invalid namespace to be refused, got status %d %s", status, body)
+	}
+
+	names := agents[0].containerNames()
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "team-a_web1" || names[1] != "web1" || names[2] != "web2" {
+		t.Fatalf("agent runs %v", names)
+	}
+	checkConsistency(t, agents...)
+
+	for query, expected := range map[string]string{"": "default/web", "?namespace=team-a": "team-a/web", "?allNamespaces=true": "default/web team-a/web"} {
+		_, body := doRequest(t, http.MethodGet, configurationsURL+query, nil)
+		var configurations []Configuration
+		json.Unmarshal(body, &configurations)
+		keys := make([]string, 0, len(configurations))
+		for _, configuration := range configurations {
+			keys = append(keys, configuration.key())
+		}
+		if strings.Join(keys, " ") != expected {
+			t.Fatalf("list%s: %s", query, body)
+		}
+	}
+
+	status, body = doRequest(t, http.MethodDelete, configurationsURL+"/web?namespace=team-a", nil)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("delete in team-a: %+v", operation)
+	}
+	if status, body := doRequest(t, http.MethodGet, configurationsURL+"/web", nil); status != http.StatusOK {
+		t.Fatalf("web of the default namespace is gone: status %d %s", status, body)
+	}
+	if names := agents[0].containerNames(); len(names) != 2 {
+		t.Fatalf("agent runs %v", names)
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestConfigurationNamesCantCollide(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configurationsURL := server.URL + API_V1_PREFIX + "/configurations"
+
+	// team_api1 would be the container of api in team, api11 the one of api with 11 replicas
+	for _, name := range []string{"team_api", "api1", "api/x", "Api", "-api", "api-", "", strings.Repeat("a", 64)} {
+		status, body := doRequest(t, http.MethodPost, configurationsURL, Configuration{Name: name, Amount: 1, Image: "alpine"})
+		if status != http.StatusBadRequest || !strings.Contains(string(body), ERROR_INVALID_CONFIGURATION) {
+			t.Errorf("create of %q: expected invalid, got status %d %s", name, status, body)
+		}
+	}
+	if status, body := doRequest(t, http.MethodPut, configurationsURL+"/api1", Configuration{Amount: 1, Image: "alpine"}); status != http.StatusBadRequest {
+		t.Errorf("put of api1: expected invalid, got status %d %s", status, body)
+	}
+
+	for _, create := range []struct{ namespace, name string }{{"team", "api"}, {"default", "3d-api"}, {"default", "a"}} {
+		status, body := doRequest(t, http.MethodPost, configurationsURL+"?namespace="+create.namespace, Configuration{Name: create.name, Amount: 1, Image: "alpine"})
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create of %s in %s: %+v", create.name, create.namespace, operation)
+		}
+	}
+	checkConsistency(t, agents...)
+
+	// a configuration created before the names were checked can still be changed
+	stateLock.Lock()
+	mapConfigurationToAgents[configurationKey(DEFAULT_NAMESPACE, "job1-")] = &ConfigurationAgent{Configuration: &Configuration{Name: "job1-", Namespace: DEFAULT_NAMESPACE, Amount: 1, Image: "alpine"}, AgentArray: make([]*Agent, 0)}
+	stateLock.Unlock()
+	status, body := doRequest(t, http.MethodPatch, configurationsURL+"/job1-", map[string]int{"Amount": 2})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of job1-: %+v", operation)
+	}
+}
+
+func TestImageChangeKeepsAnOlderName(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	// job1 was created before the names were checked, its replica job11 runs
+	container := Container{Index: 1, ConfigurationName: "job1", Namespace: DEFAULT_NAMESPACE, Image: "alpine"}
+	stateLock.Lock()
+	agentsArray[0].MapContainerName["job11"] = &container
+	mapConfigurationToAgents[configurationKey(DEFAULT_NAMESPACE, "job1")] = &ConfigurationAgent{Configuration: &Configuration{Name: "job1", Namespace: DEFAULT_NAMESPACE, Amount: 1, Image: "alpine"}, AgentArray: []*Agent{agentsArray[0]}}
+	stateLock.Unlock()
+	agents[0].lock.Lock()
+	agents[0].containers["job11"] = agentContainer{Container: container}
+	agents[0].lock.Unlock()
+
+	// an image change deletes every replica and creates them again under the same name
+	status, body := doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/job1", map[string]string{"Image": "nginx"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of the image: %+v", operation)
+	}
+	status, body = doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/job1", Configuration{Amount: 2, Image: "busybox"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("put of the image: %+v", operation)
+	}
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/update", Configuration{Name: "job1", Amount: 2, Image: "alpine"}); status != http.StatusOK {
+		t.Fatalf("update of the image: status %d %s", status, body)
+	}
+	if configuration := getConfiguration(t, server.URL, "job1"); configuration.Image != "alpine" || configuration.Amount != 2 {
+		t.Fatalf("configuration after the updates: %+v", configuration)
+	}
+	checkConsistency(t, agents...)
+}
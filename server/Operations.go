@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	OPERATION_CREATE = "Create"
+	OPERATION_UPDATE = "Update"
+	OPERATION_DELETE = "Delete"
+
+	OPERATION_RUNNING   = "Running"
+	OPERATION_SUCCEEDED = "Succeeded"
+	OPERATION_FAILED    = "Failed"
+
+	REPLICA_PENDING   = "Pending"
+	REPLICA_SUCCEEDED = "Succeeded"
+	REPLICA_FAILED    = "Failed"
+
+	// finished operations beyond this amount are forgotten, oldest first
+	OPERATIONS_HISTORY_SIZE = 1000
+)
+
+// ReplicaProgress is the state of a single container an operation creates or deletes
+type ReplicaProgress struct {
+	Action    string
+	Container string
+	AgentPort int
+	Status    string
+	Message   string `json:",omitempty"`
+}
+
+// Operation tracks a create, update or delete of a configuration, or the drain of an agent, running in the background
+type Operation struct {
+	ID                string
+	Type              string
+	ConfigurationName string `json:",omitempty"`
+	Namespace         string `json:",omitempty"`
+	AgentID           string `json:",omitempty"`
+	Status            string
+	Replicas          []ReplicaProgress
+	Error             *APIError `json:",omitempty"`
+	Started           time.Time
+	Finished          *time.Time `json:",omitempty"`
+	// the ID of the request that started the operation, the requests to the agents carry it
+	RequestID string `json:",omitempty"`
+
+	done chan struct{}
+}
+
+// operationsLock guards the operations and every field of them, it is never held while taking another lock
+var operationsLock sync.Mutex
+var operations = make(map[string]*Operation)
+var finishedOperations = make([]string, 0)
+
+func newOperationID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+func newOperation(r *http.Request, operationType string, namespace string, configurationName string) *Operation {
+	operation := &Operation{
+		ID:                newOperationID(),
+		Type:              operationType,
+		ConfigurationName: configurationName,
+		Namespace:         namespace,
+		Status:            OPERATION_RUNNING,
+		Replicas:          make([]ReplicaProgress, 0),
+		Started:           time.Now().UTC(),
+		RequestID:         requestIDOf(r),
+		done:              make(chan struct{}),
+	}
+
+	operationsInFlight.Add(1)
+	operationsLock.Lock()
+	operations[operation.ID] = operation
+	operationsLock.Unlock()
+	return operation
+}
+
+// log is the logger of the request that started the operation, it accepts a nil operation
+func (operation *Operation) log() logger {
+	if operation == nil {
+		return serverLog
+	}
+	return logger{requestID: operation.RequestID}
+}
+
+// requestID is empty for a nil operation, the agent then gives the request its own
+func (operation *Operation) requestID() string {
+	return operation.log().requestID
+}
+
+func (operation *Operation) setAgentID(agentID string) {
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+	operation.AgentID = agentID
+}
+
+// replicaPending adds a container to the operation before the request to its agent is sent,
+// all the replica methods accept a nil operation
+func (operation *Operation) replicaPending(action string, container string, agentPort int) {
+	if operation == nil {
+		return
+	}
+
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+	operation.Replicas = append(operation.Replicas, ReplicaProgress{Action: action, Container: container, AgentPort: agentPort, Status: REPLICA_PENDING})
+}
+
+func (operation *Operation) replicaDone(action string, container string, failure *ReplicaFailure) {
+	if operation == nil {
+		return
+	}
+
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+	for i := range operation.Replicas {
+		replica := &operation.Replicas[i]
+		if replica.Action != action || replica.Container != container || replica.Status != REPLICA_PENDING {
+			continue
+		}
+
+		replica.Status = REPLICA_SUCCEEDED
+		if failure != nil {
+			replica.Status = REPLICA_FAILED
+			replica.Message = failure.Message
+		}
+		return
+	}
+}
+
+func (operation *Operation) finish(apiError *APIError) {
+	operationsLock.Lock()
+	finished := time.Now().UTC()
+	operation.Finished = &finished
+	operation.Status = OPERATION_SUCCEEDED
+	if apiError != nil {
+		operation.Status = OPERATION_FAILED
+		operation.Error = apiError
+	}
+
+	finishedOperations = append(finishedOperations, operation.ID)
+	if len(finishedOperations) > OPERATIONS_HISTORY_SIZE {
+		delete(operations, finishedOperations[0])
+		finishedOperations = finishedOperations[1:]
+	}
+	operationsLock.Unlock()
+
+	observeReconcile(operation.Type, operation.Started, apiError)
+	close(operation.done)
+	operationsInFlight.Done()
+}
+
+// startOperation runs the operation in the background with the configuration lock held and
+// persists the state once it ends, the audit entry of the request is written then
+func startOperation(r *http.Request, operation *Operation, run func(operation *Operation) *APIError) {
+	key := configurationKey(operation.Namespace, operation.ConfigurationName)
+	operation.log().infof("operation %s: %s of %s started", operation.ID, operation.Type, key)
+	entry := auditOf(r)
+	entry.deferTo(operation)
+
+	go func() {
+		unlock := lockConfiguration(key)
+		entry.recordBefore(configurationSnapshot(key))
+		apiError := run(operation)
+		writeDataToJSON()
+		entry.recordAfter(configurationSnapshot(key))
+		unlock()
+
+		if apiError != nil {
+			operation.log().warnf("operation %s failed: %s", operation.ID, apiError.Message)
+		} else {
+			operation.log().infof("operation %s succeeded", operation.ID)
+		}
+		entry.operationDone(apiError)
+		operation.finish(apiError)
+	}()
+}
+
+func marshalOperation(operation *Operation) json.RawMessage {
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+
+	operationJSON, _ := json.Marshal(operation)
+	return operationJSON
+}
+
+// respondWithOperation answers 202 with the started operation, the routes from before /api/v1
+// keep their blocking behaviour and answer once it ends
+func respondWithOperation(responseHTTP http.ResponseWriter, r *http.Request, operation *Operation) {
+	if strings.HasPrefix(r.URL.Path, API_V1_PREFIX) {
+		responseHTTP.Header().Set("Location", fmt.Sprintf("%s/operations/%s", API_V1_PREFIX, operation.ID))
+		respondWithJSON(responseHTTP, http.StatusAccepted, marshalOperation(operation))
+		return
+	}
+
+	<-operation.done
+	if operation.Error != nil {
+		respondWithError(responseHTTP, operation.Error)
+		return
+	}
+
+	switch operation.Type {
+	case OPERATION_CREATE:
+		respondWithJSON(responseHTTP, http.StatusCreated, "Containers created")
+	case OPERATION_UPDATE:
+		respondWithJSON(responseHTTP, http.StatusOK, "Update complete")
+	case OPERATION_DELETE:
+		respondWithJSON(responseHTTP, http.StatusOK, fmt.Sprintf("configuration %s been deleted", operation.ConfigurationName))
+	}
+}
+
+func getOperationEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	operationID := mux.Vars(r)["id"]
+
+	operationsLock.Lock()
+	operation, ok := operations[operationID]
+	operationsLock.Unlock()
+
+	// an operation of a namespace the caller can't see doesn't exist for it
+	if !ok || operation.Namespace != "" && !caller(r).canAccess(operation.Namespace) {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("operation %s doesn't exists", operationID)))
+		return
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, marshalOperation(operation))
+}
+
+func listOperationsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configurationName := r.URL.Query().Get("configuration")
+	namespace := r.URL.Query().Get("namespace")
+	token := caller(r)
+
+	operationsLock.Lock()
+	operationArray := make([]Operation, 0)
+	for _, operation := range operations {
+		if configurationName != "" && operation.ConfigurationName != configurationName {
+			continue
+		}
+		if namespace != "" && operation.Namespace != namespace {
+			continue
+		}
+		if operation.Namespace != "" && !token.canAccess(operation.Namespace) {
+			continue
+		}
+		operationArray = append(operationArray, *operation)
+	}
+	sort.Slice(operationArray, func(i, j int) bool {
+		return operationArray[i].Started.Before(operationArray[j].Started)
+	})
+	operationsJSON, _ := json.Marshal(operationArray)
+	operationsLock.Unlock()
+
+	respondWithJSON(responseHTTP, http.StatusOK, json.RawMessage(operationsJSON))
+}
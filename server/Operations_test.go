@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOperationReportsReplicaProgress(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 4, Image: "alpine"})
+	operation := waitForOperation(t, server.URL, status, body)
+	if operation.Status != OPERATION_SUCCEEDED || operation.Type != OPERATION_CREATE || len(operation.Replicas) != 4 {
+		t.Fatalf("unexpected operation %+v", operation)
+	}
+	for _, replica := range operation.Replicas {
+		if replica.Status != REPLICA_SUCCEEDED {
+			t.Fatalf("replica %s is %s", replica.Container, replica.Status)
+		}
+	}
+
+	// a create of an existing configuration is refused before any operation starts
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"}); status != http.StatusConflict {
+		t.Fatalf("expected a conflict, got status %d %s", status, body)
+	}
+
+	if status, body := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/operations/unknown", nil); status != http.StatusNotFound {
+		t.Fatalf("expected not found, got status %d %s", status, body)
+	}
+	checkConsistency(t, agents...)
+}
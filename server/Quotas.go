@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const PATH_QUOTAS = "quotas.json"
+
+// Resources are what every replica of a configuration requests, they count against the quota of its namespace
+type Resources struct {
+	CPUMillis int `yaml:"CPUMillis" json:",omitempty"`
+	MemoryMB  int `yaml:"MemoryMB" json:",omitempty"`
+}
+
+// Quota limits what the configurations of a namespace may request in total, zero is unlimited
+type Quota struct {
+	MaxConfigurations int `json:",omitempty"`
+	MaxReplicas       int `json:",omitempty"`
+	MaxCPUMillis      int `json:",omitempty"`
+	MaxMemoryMB       int `json:",omitempty"`
+}
+
+// QuotaUsage is what the configurations of a namespace request
+type QuotaUsage struct {
+	Configurations int
+	Replicas       int
+	CPUMillis      int
+	MemoryMB       int
+}
+
+// QuotaStatus is the quota of a namespace next to its usage
+type QuotaStatus struct {
+	Namespace string
+	Quota     Quota
+	Used      QuotaUsage
+}
+
+// quotas is keyed by namespace and guarded by stateLock, a namespace without one is unlimited
+var quotas = make(map[string]*Quota)
+
+func (usage *QuotaUsage) add(configuration *Configuration) {
+	usage.Configurations++
+	usage.Replicas += configuration.Amount
+	usage.CPUMillis += configuration.Amount * configuration.Resources.CPUMillis
+	usage.MemoryMB += configuration.Amount * configuration.Resources.MemoryMB
+}
+
+// namespaceUsage adds up the configurations of the namespace but the one with key skip,
+// must be called with stateLock held
+func namespaceUsage(namespace string, skip string) QuotaUsage {
+	var usage QuotaUsage
+	for key, configurationAgent := range mapConfigurationToAgents {
+		if key != skip && configurationAgent.Configuration.Namespace == namespace {
+			usage.add(configurationAgent.Configuration)
+		}
+	}
+	return usage
+}
+
+func checkResourcesValidity(resources Resources) *APIError {
+	if resources.CPUMillis < 0 || resources.MemoryMB < 0 {
+		return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, "resource requests can't be negative")
+	}
+	return nil
+}
+
+// quotaExceeded tells whether the configuration, replacing its current version if any, fits the quota
+// of its namespace, a namespace over its quota may still shrink, must be called with stateLock held
+func quotaExceeded(configuration *Configuration) *APIError {
+	namespace := configuration.Namespace
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	quota, ok := quotas[namespace]
+	if !ok {
+		return nil
+	}
+
+	current := namespaceUsage(namespace, "")
+	usage := namespaceUsage(namespace, configuration.key())
+	usage.add(configuration)
+
+	exceeded := make([]string, 0)
+	check := func(resource string, used int, currentlyUsed int, max int) {
+		if max != 0 && used > max && used > currentlyUsed {
+			exceeded = append(exceeded, fmt.Sprintf("%s %d of %d", resource, used, max))
+		}
+	}
+	check("configurations", usage.Configurations, current.Configurations, quota.MaxConfigurations)
+	check("replicas", usage.Replicas, current.Replicas, quota.MaxReplicas)
+	check("cpu millis", usage.CPUMillis, current.CPUMillis, quota.MaxCPUMillis)
+	check("memory MB", usage.MemoryMB, current.MemoryMB, quota.MaxMemoryMB)
+
+	if len(exceeded) == 0 {
+		return nil
+	}
+	apiError := newAPIError(http.StatusForbidden, ERROR_QUOTA_EXCEEDED, fmt.Sprintf("configuration %s exceeds the quota of namespace %s", configuration.key(), namespace))
+	apiError.Details = "would use " + strings.Join(exceeded, ", ")
+	return apiError
+}
+
+func checkQuota(configuration *Configuration) *APIError {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	return quotaExceeded(configuration)
+}
+
+// quotaStatus must be called with stateLock held
+func quotaStatus(namespace string) QuotaStatus {
+	status := QuotaStatus{Namespace: namespace, Used: namespaceUsage(namespace, "")}
+	if quota, ok := quotas[namespace]; ok {
+		status.Quota = *quota
+	}
+	return status
+}
+
+func quotaNamespace(responseHTTP http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace := mux.Vars(r)["namespace"]
+	apiError := checkNamespaceValidity(namespace)
+	if token := caller(r); apiError == nil && !token.canAccess(namespace) {
+		apiError = forbiddenError(fmt.Sprintf("%s has no access to namespace %s", token.Name, namespace))
+	}
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return "", false
+	}
+	return namespace, true
+}
+
+func getQuotaEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, ok := quotaNamespace(responseHTTP, r)
+	if !ok {
+		return
+	}
+
+	stateLock.RLock()
+	status := quotaStatus(namespace)
+	stateLock.RUnlock()
+	respondWithJSON(responseHTTP, http.StatusOK, status)
+}
+
+// setQuotaEndPoint applies to the next create or update, configurations already over it keep running
+func setQuotaEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, ok := quotaNamespace(responseHTTP, r)
+	if !ok {
+		return
+	}
+
+	var quota Quota
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&quota); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	if quota.MaxConfigurations < 0 || quota.MaxReplicas < 0 || quota.MaxCPUMillis < 0 || quota.MaxMemoryMB < 0 {
+		respondWithError(responseHTTP, invalidRequestError("quota limits can't be negative"))
+		return
+	}
+	requestLog(r).infof("set quota of namespace %s request: %+v", namespace, quota)
+
+	stateLock.Lock()
+	if previous, ok := quotas[namespace]; ok {
+		auditOf(r).recordBefore(snapshot(previous))
+	}
+	quotas[namespace] = &quota
+	status := quotaStatus(namespace)
+	stateLock.Unlock()
+
+	writeDataToJSON()
+	auditOf(r).recordAfter(snapshot(quota))
+	respondWithJSON(responseHTTP, http.StatusOK, status)
+}
+
+func deleteQuotaEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, ok := quotaNamespace(responseHTTP, r)
+	if !ok {
+		return
+	}
+	requestLog(r).infof("delete quota of namespace %s request", namespace)
+
+	stateLock.Lock()
+	previous, exists := quotas[namespace]
+	delete(quotas, namespace)
+	stateLock.Unlock()
+
+	if !exists {
+		respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("namespace %s has no quota", namespace)))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordBefore(snapshot(previous))
+	responseHTTP.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestQuotaLimitsItsNamespace(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configurationsURL := server.URL + API_V1_PREFIX + "/configurations"
+	quotaURL := server.URL + API_V1_PREFIX + "/namespaces/team-a/quota"
+
+	if status, body := doRequest(t, http.MethodPut, quotaURL, Quota{MaxReplicas: 3, MaxMemoryMB: 1024}); status != http.StatusOK {
+		t.Fatalf("set quota: status %d %s", status, body)
+	}
+
+	web := Configuration{Name: "web", Namespace: "team-a", Amount: 2, Image: "alpine", Resources: Resources{MemoryMB: 256}}
+	status, body := doRequest(t, http.MethodPost, configurationsURL, web)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create web: %+v", operation)
+	}
+
+	db := Configuration{Name: "db", Namespace: "team-a", Amount: 2, Image: "alpine"}
+	if status, body := doRequest(t, http.MethodPost, configurationsURL, db); status != http.StatusForbidden {
+		t.Fatalf("expected db to exceed the replicas, got status %d %s", status, body)
+	}
+
+	// 3 replicas fit but 768 MB don't
+	web.Amount = 3
+	web.Resources.MemoryMB = 512
+	status, body = doRequest(t, http.MethodPut, configurationsURL+"/web", web)
+	operation := waitForOperation(t, server.URL, status, body)
+	if operation.Status != OPERATION_FAILED || operation.Error.Code != ERROR_QUOTA_EXCEEDED {
+		t.Fatalf("expected the update to exceed the memory, got %+v", operation)
+	}
+	if names := agents[0].containerNames(); len(names) != 2 {
+		t.Fatalf("refused update changed the containers: %v", names)
+	}
+
+	// other namespaces aren't limited
+	status, body = doRequest(t, http.MethodPost, configurationsURL, Configuration{Name: "db", Amount: 5, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create db in default: %+v", operation)
+	}
+
+	_, body = doRequest(t, http.MethodGet, quotaURL, nil)
+	var quotaStatus QuotaStatus
+	json.Unmarshal(body, &quotaStatus)
+	if quotaStatus.Used != (QuotaUsage{Configurations: 1, Replicas: 2, MemoryMB: 512}) || quotaStatus.Quota.MaxReplicas != 3 {
+		t.Fatalf("quota status: %s", body)
+	}
+
+	// lowering the quota below the usage still lets the namespace shrink
+	doRequest(t, http.MethodPut, quotaURL, Quota{MaxReplicas: 1})
+	web.Amount = 2
+	web.Resources.MemoryMB = 128
+	status, body = doRequest(t, http.MethodPut, configurationsURL+"/web", web)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("update over the quota without growing: %+v", operation)
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestConcurrentResourceUpdatesStayInTheQuota(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	configurationsURL := server.URL + API_V1_PREFIX + "/configurations"
+
+	if status, body := doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/namespaces/team-a/quota", Quota{MaxMemoryMB: 1000}); status != http.StatusOK {
+		t.Fatalf("set quota: status %d %s", status, body)
+	}
+	for _, name := range []string{"web", "db"} {
+		status, body := doRequest(t, http.MethodPost, configurationsURL, Configuration{Name: name, Namespace: "team-a", Amount: 2, Image: "alpine", Resources: Resources{MemoryMB: 100}})
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create %s: %+v", name, operation)
+		}
+	}
+
+	// each one fits alone, 600 MB with the 200 MB of the other, not both
+	results := make(chan Operation, 2)
+	for _, name := range []string{"web", "db"} {
+		go func(name string) {
+			status, body := doRequest(t, http.MethodPatch, configurationsURL+"/"+name+"?namespace=team-a", map[string]interface{}{"Amount": 1, "Resources": Resources{MemoryMB: 600}})
+			results <- waitForOperation(t, server.URL, status, body)
+		}(name)
+	}
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		operation := <-results
+		switch {
+		case operation.Status == OPERATION_SUCCEEDED:
+			succeeded++
+		case operation.Error == nil || operation.Error.Code != ERROR_QUOTA_EXCEEDED:
+			t.Errorf("update: %+v", operation)
+		}
+	}
+
+	stateLock.RLock()
+	usage := namespaceUsage("team-a", "")
+	stateLock.RUnlock()
+	if succeeded != 1 || usage.MemoryMB > 1000 {
+		t.Fatalf("%d updates succeeded, the namespace uses %d MB", succeeded, usage.MemoryMB)
+	}
+}
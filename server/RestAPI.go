@@ -0,0 +1,285 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const API_V1_PREFIX = "/api/v1"
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// ConfigurationPatch holds the fields a PATCH changes, a missing field keeps its current value
+type ConfigurationPatch struct {
+	Amount                 *int
+	Image                  *string
+	TerminationGracePeriod *int
+	// a present PreStop replaces the hook, an empty one without Exec and HTTP removes it
+	PreStop *PreStopHook
+	// a present Volumes replaces all of them, an empty list removes them
+	Volumes *[]VolumeMount
+	// a present ConfigMaps replaces all of them, an empty list removes them
+	ConfigMaps *[]ConfigMapRef
+	// a present Secrets replaces all of them, an empty list removes them
+	Secrets   *[]SecretRef
+	Resources *Resources
+}
+
+// registerAPIv1Routes wraps every handler but the spec with the role it needs, see allow
+func registerAPIv1Routes(api *mux.Router) {
+	api.HandleFunc("/openapi.yaml", openAPIEndPoint).Methods(http.MethodGet)
+
+	api.HandleFunc("/configurations", allow(ROLE_VIEWER, listConfigurationsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/configurations", audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, createEndpoint))).Methods(http.MethodPost)
+	api.HandleFunc("/configurations/{name}", allow(ROLE_VIEWER, getConfigurationEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/configurations/{name}", audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, replaceConfigurationEndPoint))).Methods(http.MethodPut)
+	api.HandleFunc("/configurations/{name}", audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, patchConfigurationEndPoint))).Methods(http.MethodPatch)
+	api.HandleFunc("/configurations/{name}", audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, deleteConfigurationEndPoint))).Methods(http.MethodDelete)
+	api.HandleFunc("/configurations/{name}/logs", allow(ROLE_VIEWER, containerLogsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/configurations/{name}/exec", audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, execEndPoint))).Methods(http.MethodGet)
+	api.HandleFunc("/configurations/{name}/stats", allow(ROLE_VIEWER, configurationStatsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/stats", allow(ROLE_VIEWER, statsEndPoint)).Methods(http.MethodGet)
+
+	api.HandleFunc("/configmaps", allow(ROLE_VIEWER, listConfigMapsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/configmaps", audited(AUDIT_KIND_CONFIGMAP, allowCluster(ROLE_EDITOR, createConfigMapEndPoint))).Methods(http.MethodPost)
+	api.HandleFunc("/configmaps/{name}", allow(ROLE_VIEWER, getConfigMapEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/configmaps/{name}", audited(AUDIT_KIND_CONFIGMAP, allowCluster(ROLE_EDITOR, replaceConfigMapEndPoint))).Methods(http.MethodPut)
+	api.HandleFunc("/configmaps/{name}", audited(AUDIT_KIND_CONFIGMAP, allowCluster(ROLE_EDITOR, deleteConfigMapEndPoint))).Methods(http.MethodDelete)
+
+	api.HandleFunc("/secrets", allow(ROLE_VIEWER, listSecretsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/secrets", audited(AUDIT_KIND_SECRET, allowCluster(ROLE_EDITOR, createSecretEndPoint))).Methods(http.MethodPost)
+	api.HandleFunc("/secrets/{name}", allow(ROLE_VIEWER, getSecretEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/secrets/{name}", audited(AUDIT_KIND_SECRET, allowCluster(ROLE_EDITOR, replaceSecretEndPoint))).Methods(http.MethodPut)
+	api.HandleFunc("/secrets/{name}", audited(AUDIT_KIND_SECRET, allowCluster(ROLE_EDITOR, deleteSecretEndPoint))).Methods(http.MethodDelete)
+
+	api.HandleFunc("/certificates", allow(ROLE_VIEWER, getCertificatesEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/certificates/rotate", audited(AUDIT_KIND_CERTIFICATES, allowCluster(ROLE_ADMIN, rotateCertificatesEndPoint))).Methods(http.MethodPost)
+
+	api.HandleFunc("/namespaces/{namespace}/quota", allow(ROLE_VIEWER, getQuotaEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/namespaces/{namespace}/quota", audited(AUDIT_KIND_QUOTA, allowCluster(ROLE_ADMIN, setQuotaEndPoint))).Methods(http.MethodPut)
+	api.HandleFunc("/namespaces/{namespace}/quota", audited(AUDIT_KIND_QUOTA, allowCluster(ROLE_ADMIN, deleteQuotaEndPoint))).Methods(http.MethodDelete)
+
+	api.HandleFunc("/audit", allowCluster(ROLE_ADMIN, auditEndPoint)).Methods(http.MethodGet)
+
+	api.HandleFunc("/operations", allow(ROLE_VIEWER, listOperationsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/operations/{id}", allow(ROLE_VIEWER, getOperationEndPoint)).Methods(http.MethodGet)
+
+	api.HandleFunc("/agents", allow(ROLE_VIEWER, agentsStatusEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/agents/pool", allow(ROLE_VIEWER, agentPoolEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/agents/{id}/metrics", allow(ROLE_VIEWER, agentMetricsEndPoint)).Methods(http.MethodGet)
+	api.HandleFunc("/agents/pool", audited(AUDIT_KIND_AGENT_POOL, allowCluster(ROLE_ADMIN, scaleAgentPoolEndPoint))).Methods(http.MethodPut)
+	api.HandleFunc("/agents/{id}/cordon", audited(AUDIT_KIND_AGENT, allowCluster(ROLE_ADMIN, cordonEndPoint))).Methods(http.MethodPost)
+	api.HandleFunc("/agents/{id}/uncordon", audited(AUDIT_KIND_AGENT, allowCluster(ROLE_ADMIN, uncordonEndPoint))).Methods(http.MethodPost)
+	api.HandleFunc("/agents/{id}/drain", audited(AUDIT_KIND_AGENT, allowCluster(ROLE_ADMIN, drainEndPoint))).Methods(http.MethodPost)
+
+	api.HandleFunc("/watch", allow(ROLE_VIEWER, watchEndPoint)).Methods(http.MethodGet)
+}
+
+// deprecated marks the responses of a pre-v1 route and points the caller to its replacement
+func deprecated(handler http.HandlerFunc, successor string) http.HandlerFunc {
+	return func(responseHTTP http.ResponseWriter, r *http.Request) {
+		requestLog(r).warnf("deprecated route %s called, use %s instead", r.URL.Path, successor)
+		responseHTTP.Header().Set("Deprecation", "true")
+		responseHTTP.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		handler(responseHTTP, r)
+	}
+}
+
+func openAPIEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	responseHTTP.Header().Set("Content-Type", "application/yaml")
+	responseHTTP.WriteHeader(http.StatusOK)
+	responseHTTP.Write(openAPISpec)
+}
+
+func listConfigurationsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	image := query.Get("image")
+	prefix := query.Get("prefix")
+	all := allNamespaces(r)
+	namespace := ""
+	if !all {
+		var apiError *APIError
+		if namespace, apiError = requestNamespace(r, ""); apiError != nil {
+			respondWithError(responseHTTP, apiError)
+			return
+		}
+	}
+	token := caller(r)
+
+	stateLock.RLock()
+	configurationArray := make([]Configuration, 0)
+	for _, configurationAgent := range mapConfigurationToAgents {
+		configuration := configurationAgent.Configuration
+		if !all && configuration.Namespace != namespace || !token.canAccess(configuration.Namespace) {
+			continue
+		}
+		if image != "" && configuration.Image != image {
+			continue
+		}
+		if prefix != "" && !strings.HasPrefix(configuration.Name, prefix) {
+			continue
+		}
+		configurationArray = append(configurationArray, *configuration)
+	}
+	stateLock.RUnlock()
+
+	sort.Slice(configurationArray, func(i, j int) bool {
+		if configurationArray[i].Namespace != configurationArray[j].Namespace {
+			return configurationArray[i].Namespace < configurationArray[j].Namespace
+		}
+		return configurationArray[i].Name < configurationArray[j].Name
+	})
+
+	requestLog(r).infof("show env status request")
+	respondWithJSON(responseHTTP, http.StatusOK, configurationArray)
+}
+
+func getConfigurationEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	respondWithConfigurationStatus(responseHTTP, namespace, mux.Vars(r)["name"])
+}
+
+func deleteConfigurationEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	startDeleteConfiguration(responseHTTP, r, namespace, mux.Vars(r)["name"])
+}
+
+// replaceConfigurationEndPoint creates the configuration or, when it already exists, updates it to the given spec
+func replaceConfigurationEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configurationName := mux.Vars(r)["name"]
+
+	var configuration Configuration
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&configuration); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	if configuration.Name == "" {
+		configuration.Name = configurationName
+	}
+	if configuration.Name != configurationName {
+		respondWithError(responseHTTP, invalidRequestError(fmt.Sprintf("configuration name %s doesn't match the path %s", configuration.Name, configurationName)))
+		return
+	}
+	namespace, apiError := requestNamespace(r, configuration.Namespace)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	configuration.Namespace = namespace
+
+	if configurationExists(configuration.key()) {
+		startUpdateConfiguration(responseHTTP, r, &configuration)
+		return
+	}
+	// If-Match: * only replaces an existing configuration, cli update sends it
+	if r.Header.Get("If-Match") == "*" {
+		apiError := configurationNotFoundError(configuration.key())
+		apiError.Status = http.StatusPreconditionFailed
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	startCreateConfiguration(responseHTTP, r, &configuration)
+}
+
+func patchConfigurationEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	configurationName := mux.Vars(r)["name"]
+
+	var patch ConfigurationPatch
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&patch); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	key := configurationKey(namespace, configurationName)
+
+	requestLog(r).infof("patch %s request", key)
+	auditOf(r).target(namespace, configurationName)
+	if !configurationExists(key) {
+		respondWithError(responseHTTP, configurationNotFoundError(key))
+		return
+	}
+	var configMapRefs []ConfigMapRef
+	var secretRefs []SecretRef
+	if patch.ConfigMaps != nil {
+		configMapRefs = *patch.ConfigMaps
+	}
+	if patch.Secrets != nil {
+		secretRefs = *patch.Secrets
+	}
+	if apiError := checkReferencesAccess(caller(r), namespace, configMapRefs, secretRefs); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	// the patch applies to the configuration as it is once the operation holds its lock
+	operation := newOperation(r, OPERATION_UPDATE, namespace, configurationName)
+	startOperation(r, operation, func(operation *Operation) *APIError {
+		stateLock.RLock()
+		configurationAgent, ok := mapConfigurationToAgents[key]
+		var configuration Configuration
+		if ok {
+			configuration = *configurationAgent.Configuration
+		}
+		stateLock.RUnlock()
+
+		if !ok {
+			return configurationNotFoundError(key)
+		}
+
+		if patch.Amount != nil {
+			configuration.Amount = *patch.Amount
+		}
+		if patch.Image != nil {
+			configuration.Image = *patch.Image
+		}
+		if patch.TerminationGracePeriod != nil {
+			configuration.TerminationGracePeriod = *patch.TerminationGracePeriod
+		}
+		if patch.PreStop != nil {
+			configuration.PreStop = patch.PreStop
+			if len(patch.PreStop.Exec) == 0 && patch.PreStop.HTTP == nil {
+				configuration.PreStop = nil
+			}
+		}
+		if patch.Volumes != nil {
+			configuration.Volumes = *patch.Volumes
+		}
+		if patch.ConfigMaps != nil {
+			configuration.ConfigMaps = *patch.ConfigMaps
+		}
+		if patch.Secrets != nil {
+			configuration.Secrets = *patch.Secrets
+		}
+		if patch.Resources != nil {
+			configuration.Resources = *patch.Resources
+		}
+		return update(operation, &configuration)
+	})
+	respondWithOperation(responseHTTP, r, operation)
+}
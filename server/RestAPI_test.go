@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// getConfiguration reads a configuration back from the server
+func getConfiguration(t *testing.T, serverURL string, path string) Configuration {
+	status, body := doRequest(t, http.MethodGet, serverURL+API_V1_PREFIX+"/configurations/"+path, nil)
+	if status != http.StatusOK {
+		t.Fatalf("get %s: status %d %s", path, status, body)
+	}
+	var configurationAgent ConfigurationAgent
+	json.Unmarshal(body, &configurationAgent)
+	if configurationAgent.Configuration == nil {
+		t.Fatalf("get %s: no configuration in %s", path, body)
+	}
+	return *configurationAgent.Configuration
+}
+
+func TestPutCreatesAndReplaces(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	// the name comes from the path when the body has none
+	status, body := doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/web", Configuration{Amount: 2, Image: "alpine", TerminationGracePeriod: 20})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED || operation.Type != OPERATION_CREATE {
+		t.Fatalf("put of a new configuration: %+v", operation)
+	}
+
+	// a replace sets every field, the ones missing from the body are reset
+	status, body = doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/web", Configuration{Amount: 3, Image: "nginx"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED || operation.Type != OPERATION_UPDATE {
+		t.Fatalf("put of an existing configuration: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.Amount != 3 || configuration.Image != "nginx" || configuration.TerminationGracePeriod != 0 {
+		t.Fatalf("configuration after put: %+v", configuration)
+	}
+
+	if status, body := doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/web", Configuration{Name: "api", Amount: 1, Image: "alpine"}); status != http.StatusBadRequest {
+		t.Fatalf("put with another name than the path: status %d %s", status, body)
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestPatchKeepsTheMissingFields(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine", TerminationGracePeriod: 20})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]interface{}{"Amount": 3})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of the amount: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.Amount != 3 || configuration.Image != "alpine" || configuration.TerminationGracePeriod != 20 {
+		t.Fatalf("configuration after patch of the amount: %+v", configuration)
+	}
+
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]interface{}{"Image": "nginx"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of the image: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.Amount != 3 || configuration.Image != "nginx" {
+		t.Fatalf("configuration after patch of the image: %+v", configuration)
+	}
+
+	if status, _ := doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/api", map[string]interface{}{"Amount": 3}); status != http.StatusNotFound {
+		t.Fatalf("patch of an unknown configuration: status %d", status)
+	}
+	if status, _ := doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", "3"); status != http.StatusBadRequest {
+		t.Fatalf("patch that isn't an object: status %d", status)
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestListConfigurationsFilters(t *testing.T) {
+	server := newTestServer(t, newFakeAgent(t))
+
+	for _, configuration := range []Configuration{{Name: "web", Amount: 1, Image: "nginx"}, {Name: "web-api", Amount: 1, Image: "alpine"}, {Name: "db", Amount: 1, Image: "alpine"}} {
+		status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create %s: %+v", configuration.Name, operation)
+		}
+	}
+
+	for query, expected := range map[string][]string{
+		"":                         {"db", "web", "web-api"},
+		"?image=alpine":            {"db", "web-api"},
+		"?prefix=web":              {"web", "web-api"},
+		"?prefix=web&image=alpine": {"web-api"},
+		"?image=busybox":           {},
+	} {
+		status, body := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations"+query, nil)
+		var configurations []Configuration
+		json.Unmarshal(body, &configurations)
+		names := make([]string, 0)
+		for _, configuration := range configurations {
+			names = append(names, configuration.Name)
+		}
+		if status != http.StatusOK || fmt.Sprint(names) != fmt.Sprint(expected) {
+			t.Errorf("list%s: expected %v, got %d %v", query, expected, status, names)
+		}
+	}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	server := newTestServer(t, newFakeAgent(t))
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/envStatus", nil)
+	resp, err := testClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Deprecation") != "true" || resp.Header.Get("Link") != "<"+API_V1_PREFIX+"/configurations>; rel=\"successor-version\"" {
+		t.Fatalf("deprecated route: status %d headers %v", resp.StatusCode, resp.Header)
+	}
+
+	// the v1 routes aren't deprecated
+	resp, err = testClient.Get(server.URL + API_V1_PREFIX + "/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Deprecation") != "" {
+		t.Fatalf("openapi: status %d headers %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestPatchReplacesAndRemovesThePreStopHook(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine", PreStop: &PreStopHook{Exec: []string{"/bin/sh", "-c", "echo stopping"}}})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	// a patch without PreStop keeps it
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]interface{}{"Amount": 2})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of the amount: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.PreStop == nil || len(configuration.PreStop.Exec) != 3 {
+		t.Fatalf("the hook is lost: %+v", configuration)
+	}
+
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]interface{}{"PreStop": PreStopHook{HTTP: &HTTPGetHook{Path: "/quit", Port: 8080}}})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch of the hook: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.PreStop == nil || configuration.PreStop.HTTP == nil || len(configuration.PreStop.Exec) != 0 {
+		t.Fatalf("the hook isn't replaced: %+v", configuration)
+	}
+
+	// an empty hook removes it, the replicas created afterwards have none
+	status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]interface{}{"PreStop": map[string]interface{}{}, "Amount": 3})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("patch removing the hook: %+v", operation)
+	}
+	if configuration := getConfiguration(t, server.URL, "web"); configuration.PreStop != nil {
+		t.Fatalf("the hook isn't removed: %+v", configuration)
+	}
+	agents[0].lock.Lock()
+	defer agents[0].lock.Unlock()
+	if container, ok := agents[0].containers["web3"]; !ok || container.PreStop != nil {
+		t.Fatalf("the new replica got %+v", container)
+	}
+}
+
+func TestPutIfMatchOnlyUpdates(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	put := func(name string, configuration Configuration) (int, []byte) {
+		request, _ := http.NewRequest(http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/"+name, strings.NewReader(fmt.Sprintf(`{"Amount":%d,"Image":%q}`, configuration.Amount, configuration.Image)))
+		request.Header.Set("If-Match", "*")
+		resp, err := testClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	// a mistyped name isn't created
+	if status, body := put("web", Configuration{Amount: 1, Image: "alpine"}); status != http.StatusPreconditionFailed || !strings.Contains(string(body), ERROR_NOT_FOUND) {
+		t.Fatalf("conditional put of a missing configuration: status %d %s", status, body)
+	}
+	if names := agents[0].containerNames(); len(names) != 0 {
+		t.Fatalf("the refused put created %v", names)
+	}
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	status, body = put("web", Configuration{Amount: 2, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED || operation.Type != OPERATION_UPDATE {
+		t.Fatalf("conditional put of an existing configuration: %+v", operation)
+	}
+}
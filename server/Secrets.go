@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const PATH_SECRETS = "secrets.json"
+const DEFAULT_SECRET_KEY_FILE = "secret.key"
+
+// AES-256
+const SECRET_KEY_SIZE = 32
+
+// set from the command line, see main
+var secretKeyFile = DEFAULT_SECRET_KEY_FILE
+
+// secretKey encrypts the secrets on disk, loaded by loadSecretKey
+var secretKey []byte
+
+// Secret is data the containers need but nobody else sees, the API never answers with its values
+type Secret struct {
+	Name string
+	Data map[string]string
+	// Namespaces are the namespaces whose configurations a token limited to namespaces may give it to
+	Namespaces []string `json:",omitempty"`
+}
+
+// SecretInfo is what the API tells about a secret
+type SecretInfo struct {
+	Name       string
+	Keys       []string
+	Namespaces []string `json:",omitempty"`
+}
+
+// SecretRef puts a secret into the containers of a configuration, every key as a file in memory
+// under MountPath, as an env var, or both
+type SecretRef struct {
+	Name      string `yaml:"Name"`
+	MountPath string `yaml:"MountPath" json:",omitempty"`
+	Env       bool   `yaml:"Env" json:",omitempty"`
+}
+
+// secrets is guarded by stateLock
+var secrets = make(map[string]*Secret)
+
+// loadSecretKey reads the key file, a missing one is created with a new random key
+func loadSecretKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key = make([]byte, SECRET_KEY_SIZE)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		serverLog.infof("secret key file %s created", path)
+		return key, ioutil.WriteFile(path, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != SECRET_KEY_SIZE {
+		return nil, fmt.Errorf("secret key file %s must hold %d bytes", path, SECRET_KEY_SIZE)
+	}
+	return key, nil
+}
+
+// encryptSecrets seals the secrets with AES-GCM, the nonce comes first, must be called with stateLock held
+func encryptSecrets() ([]byte, error) {
+	if secretKey == nil {
+		return nil, errors.New("there is no secret key")
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decryptSecrets(sealed []byte) (map[string]*Secret, error) {
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("the secrets file is truncated")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make(map[string]*Secret)
+	return decrypted, json.Unmarshal(plain, &decrypted)
+}
+
+// readSecrets loads the secrets written by the previous run, none if there is no file
+func readSecrets() (map[string]*Secret, error) {
+	sealed, err := ioutil.ReadFile(PATH_SECRETS)
+	if os.IsNotExist(err) {
+		return make(map[string]*Secret), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decryptSecrets(sealed)
+}
+
+func secretNotFoundError(name string) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("secret %s doesn't exists", name))
+}
+
+// checkSecretReferences checks the secrets a configuration uses exist and fit the way they are used
+func checkSecretReferences(configuration *Configuration) *APIError {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	for _, ref := range configuration.Secrets {
+		var data map[string]string
+		if secret, ok := secrets[ref.Name]; ok {
+			data = secret.Data
+		}
+		if apiError := checkDataReference("secret", ref.Name, ref.MountPath, ref.Env, data); apiError != nil {
+			return apiError
+		}
+	}
+	return nil
+}
+
+// secretUsers are the configurations using the secret, must be called with stateLock held
+func secretUsers(name string) []string {
+	users := make([]string, 0)
+	for configurationName, configurationAgent := range mapConfigurationToAgents {
+		for _, ref := range configurationAgent.Configuration.Secrets {
+			if ref.Name == name {
+				users = append(users, configurationName)
+				break
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+func secretInfo(secret *Secret) SecretInfo {
+	info := SecretInfo{Name: secret.Name, Keys: make([]string, 0, len(secret.Data)), Namespaces: secret.Namespaces}
+	for key := range secret.Data {
+		info.Keys = append(info.Keys, key)
+	}
+	sort.Strings(info.Keys)
+	return info
+}
+
+func listSecretsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	stateLock.RLock()
+	infos := make([]SecretInfo, 0, len(secrets))
+	for _, secret := range secrets {
+		infos = append(infos, secretInfo(secret))
+	}
+	stateLock.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	respondWithJSON(responseHTTP, http.StatusOK, infos)
+}
+
+func getSecretEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	stateLock.RLock()
+	secret, ok := secrets[name]
+	var info SecretInfo
+	if ok {
+		info = secretInfo(secret)
+	}
+	stateLock.RUnlock()
+
+	if !ok {
+		respondWithError(responseHTTP, secretNotFoundError(name))
+		return
+	}
+	respondWithJSON(responseHTTP, http.StatusOK, info)
+}
+
+func decodeSecret(r *http.Request) (*Secret, *APIError) {
+	var secret Secret
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&secret); err != nil {
+		return nil, invalidRequestError(err.Error())
+	}
+	defer r.Body.Close()
+
+	if secret.Data == nil {
+		secret.Data = make(map[string]string)
+	}
+	// the same rules as for config maps, a key is a file name or an env var
+	return &secret, checkConfigMapValidity(&ConfigMap{Name: secret.Name, Data: secret.Data, Namespaces: secret.Namespaces})
+}
+
+func createSecretEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	secret, apiError := decodeSecret(r)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	requestLog(r).infof("create secret %s request", secret.Name)
+	auditOf(r).target("", secret.Name)
+
+	stateLock.Lock()
+	_, exists := secrets[secret.Name]
+	if !exists {
+		secrets[secret.Name] = secret
+	}
+	info := secretInfo(secret)
+	stateLock.Unlock()
+
+	if exists {
+		respondWithError(responseHTTP, newAPIError(http.StatusConflict, ERROR_ALREADY_EXISTS, fmt.Sprintf("secret %s already exists", secret.Name)))
+		return
+	}
+	writeDataToJSON()
+	// the audit log never holds the values of a secret
+	auditOf(r).recordAfter(snapshot(info))
+	respondWithJSON(responseHTTP, http.StatusCreated, info)
+}
+
+// replaceSecretEndPoint changes the data of a secret, only containers created afterwards see it
+func replaceSecretEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	secret, apiError := decodeSecret(r)
+	if apiError == nil && secret.Name != name {
+		apiError = invalidRequestError(fmt.Sprintf("the body is secret %s, not %s", secret.Name, name))
+	}
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	requestLog(r).infof("replace secret %s request", name)
+
+	stateLock.Lock()
+	previous, exists := secrets[name]
+	var previousInfo SecretInfo
+	if exists {
+		previousInfo = secretInfo(previous)
+		secrets[name] = secret
+	}
+	info := secretInfo(secret)
+	stateLock.Unlock()
+
+	if !exists {
+		respondWithError(responseHTTP, secretNotFoundError(name))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordBefore(snapshot(previousInfo))
+	auditOf(r).recordAfter(snapshot(info))
+	respondWithJSON(responseHTTP, http.StatusOK, info)
+}
+
+func deleteSecretEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	requestLog(r).infof("delete secret %s request", name)
+
+	stateLock.Lock()
+	previous, exists := secrets[name]
+	var previousInfo SecretInfo
+	users := secretUsers(name)
+	if exists {
+		previousInfo = secretInfo(previous)
+	}
+	if exists && len(users) == 0 {
+		delete(secrets, name)
+	}
+	stateLock.Unlock()
+
+	if !exists {
+		respondWithError(responseHTTP, secretNotFoundError(name))
+		return
+	}
+	if len(users) != 0 {
+		respondWithError(responseHTTP, newAPIError(http.StatusConflict, ERROR_IN_USE, fmt.Sprintf("secret %s is used by %s", name, strings.Join(users, ", "))))
+		return
+	}
+	writeDataToJSON()
+	auditOf(r).recordBefore(snapshot(previousInfo))
+	responseHTTP.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestSecretsOnlyReachTheAgents(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+	const password = "hunter2-very-secret"
+
+	secret := Secret{Name: "db", Data: map[string]string{"PASSWORD": password}}
+	if status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/secrets", secret); status != http.StatusCreated || bytes.Contains(body, []byte(password)) {
+		t.Fatalf("create secret: status %d %s", status, body)
+	}
+
+	configuration := Configuration{Name: "web", Amount: 1, Image: "alpine", Secrets: []SecretRef{{Name: "db", MountPath: "/run/secrets", Env: true}}}
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	agents[0].lock.Lock()
+	container := agents[0].containers["web1"]
+	agents[0].lock.Unlock()
+	if len(container.SecretFiles) != 1 || container.SecretFiles[0].Path != "/run/secrets/PASSWORD" || len(container.Env) != 1 || len(container.Files) != 0 {
+		t.Fatalf("container reached the agent with %+v", container)
+	}
+
+	for _, path := range []string{"/envStatus", API_V1_PREFIX + "/configurations/web", API_V1_PREFIX + "/secrets", API_V1_PREFIX + "/secrets/db", API_V1_PREFIX + "/agents"} {
+		if _, body := doRequest(t, http.MethodGet, server.URL+path, nil); bytes.Contains(body, []byte(password)) {
+			t.Fatalf("%s shows the secret: %s", path, body)
+		}
+	}
+
+	for _, file := range []string{PATH_MAP, PATH_AGENTARRAY, PATH_CONFIGMAPS, PATH_SECRETS} {
+		content, err := ioutil.ReadFile(file)
+		if err != nil || bytes.Contains(content, []byte(password)) {
+			t.Fatalf("%s holds the secret or is missing: %v", file, err)
+		}
+	}
+
+	// the next server decrypts them with the same key
+	loaded, err := readSecrets()
+	if err != nil || loaded["db"] == nil || loaded["db"].Data["PASSWORD"] != password {
+		t.Fatalf("secrets not read back: %v", err)
+	}
+}
+
+func TestNamespacedTokensOnlyUseSharedData(t *testing.T) {
+	server := newTestServer(t, newFakeAgent(t))
+	api := server.URL + API_V1_PREFIX
+
+	for _, secret := range []Secret{{Name: "prod-db", Data: map[string]string{"PASSWORD": "prod"}}, {Name: "team-a-db", Data: map[string]string{"PASSWORD": "a"}, Namespaces: []string{"team-a"}}} {
+		if status, body := doRequest(t, http.MethodPost, api+"/secrets", secret); status != http.StatusCreated {
+			t.Fatalf("create secret %s: status %d %s", secret.Name, status, body)
+		}
+	}
+	for _, configMap := range []ConfigMap{{Name: "prod-settings", Data: map[string]string{"MODE": "prod"}}, {Name: "team-a-settings", Data: map[string]string{"MODE": "a"}, Namespaces: []string{"team-a"}}} {
+		if status, body := doRequest(t, http.MethodPost, api+"/configmaps", configMap); status != http.StatusCreated {
+			t.Fatalf("create configmap %s: status %d %s", configMap.Name, status, body)
+		}
+	}
+	if status, _ := doRequest(t, http.MethodPost, api+"/secrets", Secret{Name: "bad", Namespaces: []string{"Team_A"}}); status != http.StatusBadRequest {
+		t.Fatalf("secret shared with an invalid namespace: status %d", status)
+	}
+	status, body := doRequest(t, http.MethodPost, api+"/configurations", Configuration{Name: "web", Namespace: "team-a", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	useTokens(t, []APIToken{
+		{Name: "ops", Token: "admin-token", Role: ROLE_ADMIN},
+		{Name: "team-a", Token: "team-a-token", Role: ROLE_EDITOR, Namespaces: []string{"team-a"}},
+	}, "")
+
+	withSecret := func(name string, secret string) Configuration {
+		return Configuration{Name: name, Namespace: "team-a", Amount: 1, Image: "alpine", Secrets: []SecretRef{{Name: secret, Env: true}}}
+	}
+	withConfigMap := func(name string, configMap string) Configuration {
+		return Configuration{Name: name, Namespace: "team-a", Amount: 1, Image: "alpine", ConfigMaps: []ConfigMapRef{{Name: configMap, Env: true}}}
+	}
+	cases := []struct {
+		name    string
+		token   string
+		method  string
+		url     string
+		payload interface{}
+		status  int
+	}{
+		{"secret of another namespace", "team-a-token", http.MethodPost, api + "/configurations", withSecret("api", "prod-db"), http.StatusForbidden},
+		{"configmap of another namespace", "team-a-token", http.MethodPost, api + "/configurations", withConfigMap("api", "prod-settings"), http.StatusForbidden},
+		{"update with a secret of another namespace", "team-a-token", http.MethodPut, api + "/configurations/web", withSecret("web", "prod-db"), http.StatusForbidden},
+		{"patch with a secret of another namespace", "team-a-token", http.MethodPatch, api + "/configurations/web?namespace=team-a", map[string]interface{}{"Secrets": []SecretRef{{Name: "prod-db", Env: true}}}, http.StatusForbidden},
+		{"patch with a configmap of another namespace", "team-a-token", http.MethodPatch, api + "/configurations/web?namespace=team-a", map[string]interface{}{"ConfigMaps": []ConfigMapRef{{Name: "prod-settings", Env: true}}}, http.StatusForbidden},
+		// a missing one is still an invalid configuration
+		{"missing secret", "team-a-token", http.MethodPost, api + "/configurations", withSecret("api", "missing"), http.StatusBadRequest},
+		{"shared secret", "team-a-token", http.MethodPost, api + "/configurations", withSecret("api", "team-a-db"), http.StatusAccepted},
+		{"shared configmap", "team-a-token", http.MethodPost, api + "/configurations", withConfigMap("cache", "team-a-settings"), http.StatusAccepted},
+		{"token of every namespace", "admin-token", http.MethodPost, api + "/configurations", withSecret("db", "prod-db"), http.StatusAccepted},
+	}
+	accepted := make([][]byte, 0)
+	for _, c := range cases {
+		status, body := doRequestWithToken(t, c.token, c.method, c.url, c.payload)
+		if status != c.status {
+			t.Errorf("%s: expected status %d, got %d %s", c.name, c.status, status, body)
+		}
+		if status == http.StatusAccepted {
+			accepted = append(accepted, body)
+		}
+	}
+
+	apiTokens = nil
+	for _, body := range accepted {
+		if operation := waitForOperation(t, server.URL, http.StatusAccepted, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Errorf("operation of a shared reference: %+v", operation)
+		}
+	}
+}
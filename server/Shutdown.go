@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// what a stopping server does with its agents, set by -on-shutdown
+const SHUTDOWN_LEAVE = "leave"
+const SHUTDOWN_TEARDOWN = "teardown"
+
+const DEFAULT_SHUTDOWN_TIMEOUT = time.Minute
+
+// set from the command line, see main
+var shutdownMode = SHUTDOWN_LEAVE
+var shutdownTimeout = DEFAULT_SHUTDOWN_TIMEOUT
+
+// operationsInFlight counts the operations started and not finished yet
+var operationsInFlight sync.WaitGroup
+
+// serveUntilSignal serves until SIGTERM or SIGINT and then shuts the server down
+func serveUntilSignal(server *http.Server) {
+	// watch and log streams never end on their own, they are cancelled when the server stops
+	streams, cancelStreams := context.WithCancel(context.Background())
+	server.BaseContext = func(net.Listener) context.Context {
+		return streams
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErrors <- server.ListenAndServeTLS("", "")
+		} else {
+			serveErrors <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErrors:
+		serverLog.fatalf("%s", err)
+	case received := <-signals:
+		serverLog.infof("%s received, shutting down", received)
+	}
+	signal.Stop(signals)
+
+	cancelStreams()
+	shutdown(server)
+}
+
+// shutdown stops accepting requests, waits for the running requests and operations, then leaves
+// the agents running for the next server or tears them down, the state is written last
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		serverLog.warnf("requests still running at shutdown: %s", err)
+	}
+	waitForOperations(ctx)
+
+	if shutdownMode == SHUTDOWN_TEARDOWN {
+		teardown(ctx)
+	} else {
+		agentSupervisor.detach()
+		serverLog.infof("agents and containers left running")
+	}
+
+	writeDataToJSON()
+	serverLog.infof("server stopped")
+}
+
+func waitForOperations(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		operationsInFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		serverLog.warnf("operations still running at shutdown, their progress is lost")
+	}
+}
+
+// teardown deletes the containers of every configuration and stops the agents
+func teardown(ctx context.Context) {
+	stateLock.RLock()
+	configurationNames := make([]string, 0, len(mapConfigurationToAgents))
+	for configurationName := range mapConfigurationToAgents {
+		configurationNames = append(configurationNames, configurationName)
+	}
+	stateLock.RUnlock()
+	sort.Strings(configurationNames)
+
+	for _, configurationName := range configurationNames {
+		unlock := lockConfiguration(configurationName)
+		if apiError := removeConfiguration(nil, configurationName, 1); apiError != nil {
+			serverLog.errorf("teardown of %s failed: %s", configurationName, apiError.Message)
+		} else {
+			serverLog.infof("configuration %s torn down", configurationName)
+		}
+		unlock()
+	}
+
+	agentSupervisor.stopAll(ctx)
+
+	stateLock.Lock()
+	agentsArray = make([]*Agent, 0)
+	stateLock.Unlock()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRestartedServerReattachesToItsAgents(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 4, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	// the next server starts from the files the operation wrote
+	initalizeParams()
+
+	stateLock.RLock()
+	unknown := len(agentsArray) == 2 && agentsArray[0].State == AGENT_UNKNOWN && agentsArray[1].State == AGENT_UNKNOWN
+	shared := true
+	for _, agent := range mapConfigurationToAgents[configurationKey(DEFAULT_NAMESPACE, "web")].AgentArray {
+		shared = shared && agentByID(agent.ID) == agent
+	}
+	stateLock.RUnlock()
+	if !unknown || !shared {
+		t.Fatalf("reloaded agents are not the agents of the configuration, or not Unknown")
+	}
+
+	for i, agent := range agents {
+		heartbeat := Heartbeat{ID: agentsArray[i].ID, Port: agent.port(), Ready: true}
+		if status, body := doRequest(t, http.MethodPost, server.URL+"/agentHeartbeat", heartbeat); status != http.StatusNoContent {
+			t.Fatalf("heartbeat: status %d %s", status, body)
+		}
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestTeardownDeletesTheContainers(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	for _, name := range []string{"web", "db"} {
+		status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: name, Amount: 3, Image: "alpine"})
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create %s: %+v", name, operation)
+		}
+	}
+
+	teardown(context.Background())
+
+	for i, agent := range agents {
+		if names := agent.containerNames(); len(names) != 0 {
+			t.Fatalf("agent %d still runs %v", i, names)
+		}
+	}
+
+	stateLock.RLock()
+	left := len(mapConfigurationToAgents) + len(agentsArray)
+	stateLock.RUnlock()
+	if left != 0 {
+		t.Fatalf("state not empty after teardown")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Locking model of the server state:
+//
+// stateLock guards agentsArray, mapConfigurationToAgents, every Agent and the
+// MapContainerName of every agent. It is only held while reading or changing
+// them, never across a request to an agent, so slow agents don't block
+// status requests or the operations of other configurations.
+//
+// Create, update and delete of a configuration are serialized per name with
+// lockConfiguration. The fields of a Configuration are only written by the
+// holder of its configuration lock, with stateLock held, so the holder may read
+// them without stateLock while everyone else needs stateLock.RLock.
+//
+// Locks are always taken in this order: configuration lock, hub.lock, stateLock.
+// operationsLock is never held while taking another one.
+var stateLock sync.RWMutex
+
+// persistLock keeps concurrent writeDataToJSON calls from interleaving their files
+var persistLock sync.Mutex
+
+type configurationLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+var configurationLocksGuard sync.Mutex
+var configurationLocks = make(map[string]*configurationLock)
+
+// lockConfiguration waits until no other create, update or delete of the configuration
+// is running and returns the function that releases it
+func lockConfiguration(configurationName string) func() {
+	configurationLocksGuard.Lock()
+	lock, ok := configurationLocks[configurationName]
+	if !ok {
+		lock = new(configurationLock)
+		configurationLocks[configurationName] = lock
+	}
+	lock.users++
+	configurationLocksGuard.Unlock()
+
+	lock.mutex.Lock()
+	return func() {
+		lock.mutex.Unlock()
+
+		configurationLocksGuard.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(configurationLocks, configurationName)
+		}
+		configurationLocksGuard.Unlock()
+	}
+}
+
+// sortedAgentsByContainerAmount returns the active schedulable agents with the least loaded first,
+// must be called with stateLock held
+func sortedAgentsByContainerAmount() []*Agent {
+	agentArray := make([]*Agent, 0, len(agentsArray))
+	for _, agent := range agentsArray {
+		if agent.Active && !agent.Unschedulable {
+			agentArray = append(agentArray, agent)
+		}
+	}
+
+	sort.SliceStable(agentArray, func(i, j int) bool {
+		return len(agentArray[i].MapContainerName) < len(agentArray[j].MapContainerName)
+	})
+	return agentArray
+}
+
+func configurationExists(configurationName string) bool {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	_, ok := mapConfigurationToAgents[configurationName]
+	return ok
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentCreateOfDifferentConfigurations(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			configuration := Configuration{Name: fmt.Sprintf("app%d-x", i), Amount: 3, Image: "alpine"}
+			status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+			if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+				t.Errorf("create %s: %+v", configuration.Name, operation)
+			}
+		}(i)
+
+		// readers and the health check run alongside the writers
+		go func(i int) {
+			defer wg.Done()
+			doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations", nil)
+			doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/agents", nil)
+			doRequest(t, http.MethodGet, server.URL+"/envStatus", nil)
+			doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/app0-x", nil)
+			if i%5 == 0 {
+				checkAgentLeases()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(mapConfigurationToAgents) != 20 {
+		t.Fatalf("expected 20 configurations, got %d", len(mapConfigurationToAgents))
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestConcurrentCreateOfSameConfiguration(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	var wg sync.WaitGroup
+	var statusLock sync.Mutex
+	statuses := make(map[int]int)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := doRequest(t, http.MethodPost, server.URL+"/create", Configuration{Name: "web", Amount: 2, Image: "alpine"})
+
+			statusLock.Lock()
+			statuses[status]++
+			statusLock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusConflict] != 9 {
+		t.Fatalf("expected one create and nine conflicts, got %v", statuses)
+	}
+	checkConsistency(t, agents...)
+}
+
+func TestConcurrentUpdatesOfSameConfigurationAreSerialized(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 12; i++ {
+		wg.Add(1)
+		go func(amount int) {
+			defer wg.Done()
+			var status int
+			var body []byte
+			switch amount % 3 {
+			case 0:
+				status, body = doRequest(t, http.MethodPut, server.URL+API_V1_PREFIX+"/configurations/web", Configuration{Amount: amount, Image: "alpine"})
+			case 1:
+				status, body = doRequest(t, http.MethodPatch, server.URL+API_V1_PREFIX+"/configurations/web", map[string]int{"Amount": amount})
+			default:
+				status, body = doRequest(t, http.MethodPost, server.URL+"/update", Configuration{Name: "web", Amount: amount, Image: "alpine"})
+				if status != http.StatusOK {
+					t.Errorf("update to %d: status %d %s", amount, status, body)
+				}
+				return
+			}
+			if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+				t.Errorf("update to %d: %+v", amount, operation)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	checkConsistency(t, agents...)
+}
+
+func TestConcurrentDeleteWhileAgentsRegister(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	for i := 0; i < 10; i++ {
+		configuration := Configuration{Name: fmt.Sprintf("job%d-x", i), Amount: 2, Image: "alpine"}
+		status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", configuration)
+		if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+			t.Fatalf("create %s: %+v", configuration.Name, operation)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("job%d-x", i)
+			if i%2 == 0 {
+				status, body := doRequest(t, http.MethodDelete, server.URL+API_V1_PREFIX+"/configurations/"+name, nil)
+				if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+					t.Errorf("delete %s: %+v", name, operation)
+				}
+				return
+			}
+
+			if status, body := doRequest(t, http.MethodPost, server.URL+"/delete", name); status != http.StatusOK {
+				t.Errorf("delete %s: status %d %s", name, status, body)
+			}
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			doRequest(t, http.MethodPost, server.URL+"/agentPort", strconv.Itoa(40000+i))
+			doRequest(t, http.MethodPost, server.URL+"/envNameStatus", fmt.Sprintf("job%d-x", i))
+		}(i)
+	}
+	wg.Wait()
+
+	if len(mapConfigurationToAgents) != 0 {
+		t.Fatalf("expected no configurations, got %d", len(mapConfigurationToAgents))
+	}
+	checkConsistency(t, agents...)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// docker waits a second between the two samples of the CPU usage, the agent asks for its containers at the same time
+const STATS_TIMEOUT = 30 * time.Second
+
+// the states of a replica besides the docker ones the agents report
+const (
+	// no agent has the container
+	REPLICA_STATE_UNSCHEDULED = "unscheduled"
+	// the agent isn't Ready or didn't answer, Error tells why
+	REPLICA_STATE_UNKNOWN = "unknown"
+)
+
+// ContainerUsage is a sample of docker stats of a running container, as the agents send it
+type ContainerUsage struct {
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	NetworkRx   uint64
+	NetworkTx   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+// AgentContainerStatus is what an agent tells about one of its containers
+type AgentContainerStatus struct {
+	Name      string
+	State     string
+	StartedAt *time.Time `json:",omitempty"`
+	Restarts  int
+	Usage     *ContainerUsage `json:",omitempty"`
+}
+
+// ReplicaStatus is the state and the resource usage of a replica, Usage only while it runs
+type ReplicaStatus struct {
+	Namespace     string
+	Configuration string
+	Replica       int
+	Container     string
+	AgentID       string `json:",omitempty"`
+	AgentPort     int    `json:",omitempty"`
+	State         string
+	StartedAt     *time.Time `json:",omitempty"`
+	Restarts      int
+	Usage         *ContainerUsage `json:",omitempty"`
+	Error         string          `json:",omitempty"`
+}
+
+// statsQuery is the request to one agent, replicas are the indexes of its containers in the collected statuses
+type statsQuery struct {
+	agentPort int
+	names     []string
+	replicas  []int
+}
+
+// collectReplicaStatuses reports every replica of the configurations keep accepts, the Ready agents are
+// asked about their containers at the same time, the others aren't asked
+func collectReplicaStatuses(keep func(configuration *Configuration) bool) []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0)
+	queries := make(map[string]*statsQuery)
+
+	stateLock.RLock()
+	for key, configurationAgent := range mapConfigurationToAgents {
+		configuration := configurationAgent.Configuration
+		if !keep(configuration) {
+			continue
+		}
+		scheduled := make(map[int]bool)
+		for _, agent := range configurationAgent.AgentArray {
+			for name, container := range agent.MapContainerName {
+				if container.configurationKey() != key {
+					continue
+				}
+				scheduled[container.Index] = true
+				status := ReplicaStatus{Namespace: configuration.Namespace, Configuration: configuration.Name, Replica: container.Index,
+					Container: name, AgentID: agent.ID, AgentPort: agent.Port, State: REPLICA_STATE_UNKNOWN}
+				if agent.State != AGENT_READY {
+					status.Error = fmt.Sprintf("agent %s is %s", agent.ID, agent.State)
+					statuses = append(statuses, status)
+					continue
+				}
+				query, ok := queries[agent.ID]
+				if !ok {
+					query = &statsQuery{agentPort: agent.Port}
+					queries[agent.ID] = query
+				}
+				query.names = append(query.names, name)
+				query.replicas = append(query.replicas, len(statuses))
+				statuses = append(statuses, status)
+			}
+		}
+		for index := 1; index <= configuration.Amount; index++ {
+			if !scheduled[index] {
+				statuses = append(statuses, ReplicaStatus{Namespace: configuration.Namespace, Configuration: configuration.Name, Replica: index,
+					Container: configuration.containerName(index), State: REPLICA_STATE_UNSCHEDULED})
+			}
+		}
+	}
+	stateLock.RUnlock()
+
+	agentQueries := make([]*statsQuery, 0, len(queries))
+	for _, query := range queries {
+		agentQueries = append(agentQueries, query)
+	}
+	// every query fills its own replicas
+	fanOut(len(agentQueries), func(i int) *ReplicaFailure {
+		query := agentQueries[i]
+		containers, err := agentContainerStatuses(query)
+		for j, replica := range query.replicas {
+			if err != nil {
+				statuses[replica].Error = err.Error()
+				continue
+			}
+			if j < len(containers) && containers[j].Name == statuses[replica].Container {
+				statuses[replica].State = containers[j].State
+				statuses[replica].StartedAt = containers[j].StartedAt
+				statuses[replica].Restarts = containers[j].Restarts
+				statuses[replica].Usage = containers[j].Usage
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		if statuses[i].Configuration != statuses[j].Configuration {
+			return statuses[i].Configuration < statuses[j].Configuration
+		}
+		return statuses[i].Replica < statuses[j].Replica
+	})
+	return statuses
+}
+
+// agentContainerStatuses asks the agent about the containers of the query, it answers in the same order
+func agentContainerStatuses(query *statsQuery) ([]AgentContainerStatus, error) {
+	statsURL := fmt.Sprintf("%s%d/containerStats?%s", agentBaseURL(), query.agentPort, url.Values{"name": query.names}.Encode())
+	resp, err := agentHTTPClient(STATS_TIMEOUT).Get(statsURL)
+	if err != nil {
+		serverLog.warnf("stats of agent on port %d: %s", query.agentPort, err)
+		return nil, fmt.Errorf("agent on port %d is not responding", query.agentPort)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent on port %d answered %s", query.agentPort, resp.Status)
+	}
+	var containers []AgentContainerStatus
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("agent on port %d sent invalid stats: %s", query.agentPort, err)
+	}
+	return containers, nil
+}
+
+func configurationStatsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	key := configurationKey(namespace, mux.Vars(r)["name"])
+	requestLog(r).infof("stats of env %s request", key)
+
+	stateLock.RLock()
+	_, found := mapConfigurationToAgents[key]
+	stateLock.RUnlock()
+	if !found {
+		respondWithError(responseHTTP, configurationNotFoundError(key))
+		return
+	}
+
+	respondWithJSON(responseHTTP, http.StatusOK, collectReplicaStatuses(func(configuration *Configuration) bool {
+		return configuration.key() == key
+	}))
+}
+
+// statsEndPoint reports the replicas of the namespace, or of every namespace the caller has access to
+func statsEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	all := allNamespaces(r)
+	namespace := ""
+	if !all {
+		var apiError *APIError
+		if namespace, apiError = requestNamespace(r, ""); apiError != nil {
+			respondWithError(responseHTTP, apiError)
+			return
+		}
+	}
+	token := caller(r)
+	requestLog(r).infof("stats request")
+
+	respondWithJSON(responseHTTP, http.StatusOK, collectReplicaStatuses(func(configuration *Configuration) bool {
+		return (all || configuration.Namespace == namespace) && token.canAccess(configuration.Namespace)
+	}))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReplicaStats(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 3, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	stateLock.Lock()
+	agentsArray[1].State, agentsArray[1].Active = AGENT_UNKNOWN, false
+	stateLock.Unlock()
+
+	status, body = doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/web/stats", nil)
+	if status != http.StatusOK {
+		t.Fatalf("stats: status %d %s", status, body)
+	}
+	var replicas []ReplicaStatus
+	if err := json.Unmarshal(body, &replicas); err != nil {
+		t.Fatal(err)
+	}
+	if len(replicas) != 3 {
+		t.Fatalf("stats: %d replicas, want 3: %s", len(replicas), body)
+	}
+	for i, replica := range replicas {
+		if replica.Replica != i+1 || replica.Namespace != DEFAULT_NAMESPACE || replica.Configuration != "web" {
+			t.Errorf("replica %d: %+v", i, replica)
+		}
+		switch replica.AgentID {
+		case "agent-0":
+			if replica.State != "running" || replica.Usage == nil || replica.Usage.CPUPercent != 12.5 || replica.Restarts != 1 {
+				t.Errorf("replica on the Ready agent: %+v", replica)
+			}
+		case "agent-1":
+			// the Unknown agent isn't asked
+			if replica.State != REPLICA_STATE_UNKNOWN || replica.Usage != nil || replica.Error == "" {
+				t.Errorf("replica on the Unknown agent: %+v", replica)
+			}
+		default:
+			t.Errorf("replica on no agent: %+v", replica)
+		}
+	}
+
+	// the replica the agent lost is reported as docker doesn't have it
+	agents[0].lock.Lock()
+	for name := range agents[0].containers {
+		delete(agents[0].containers, name)
+	}
+	agents[0].lock.Unlock()
+	status, body = doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/stats", nil)
+	if status != http.StatusOK {
+		t.Fatalf("stats of the namespace: status %d %s", status, body)
+	}
+	replicas = nil
+	json.Unmarshal(body, &replicas)
+	for _, replica := range replicas {
+		if replica.AgentID == "agent-0" && replica.State != "missing" {
+			t.Errorf("replica lost by its agent: %+v", replica)
+		}
+	}
+
+	if status, _ := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/configurations/db/stats", nil); status != http.StatusNotFound {
+		t.Fatalf("stats of an unknown configuration: status %d", status)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_AGENT_LOG_DIR = "agent-logs"
+
+// the agents a server left running when it stopped, the next server adopts them
+const PATH_DETACHED_AGENTS = "agentPool.json"
+const AGENT_RESTART_MIN_BACKOFF = time.Second
+const AGENT_RESTART_MAX_BACKOFF = time.Minute
+
+// an agent running this long is healthy again, its next restart starts from the minimum backoff
+const AGENT_STABLE_RUN = time.Minute
+
+// set from the command line, see main
+var agentPath = AGENT_PATH
+var agentLogDir = DEFAULT_AGENT_LOG_DIR
+var agentRestartBackoff = AGENT_RESTART_MIN_BACKOFF
+
+// agentProcess is one slot of the pool, its agent keeps the ID file of the slot across restarts
+type agentProcess struct {
+	Number   int
+	PID      int
+	Running  bool
+	Restarts int
+	LastExit string `json:",omitempty"`
+	IDFile   string
+	LogFile  string
+
+	handle *os.Process
+	stop   chan struct{}
+}
+
+// supervisor keeps the configured number of local agents running
+type supervisor struct {
+	lock      sync.Mutex
+	processes []*agentProcess
+	// detached are the agents of the previous server still running, by slot number
+	detached map[int]agentProcess
+}
+
+// AgentPool is the size of the pool and the state of its processes
+type AgentPool struct {
+	Size      int
+	Processes []agentProcess
+}
+
+var agentSupervisor = &supervisor{}
+
+func (s *supervisor) pool() AgentPool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pool := AgentPool{Size: len(s.processes), Processes: make([]agentProcess, 0, len(s.processes))}
+	for _, process := range s.processes {
+		pool.Processes = append(pool.Processes, *process)
+	}
+	return pool
+}
+
+// scale starts or stops agents until the pool has size agents, the stopped agents' containers are rescheduled
+func (s *supervisor) scale(size int) {
+	s.lock.Lock()
+	for len(s.processes) < size {
+		number := len(s.processes)
+		process := &agentProcess{
+			Number:  number,
+			IDFile:  fmt.Sprintf("agent-%d.id", number),
+			LogFile: filepath.Join(agentLogDir, fmt.Sprintf("agent-%d.log", number)),
+			stop:    make(chan struct{}),
+		}
+		s.processes = append(s.processes, process)
+		go s.run(process)
+	}
+
+	retired := make([]*agentProcess, 0)
+	if len(s.processes) > size {
+		retired = append(retired, s.processes[size:]...)
+		s.processes = s.processes[:size]
+	}
+
+	for _, process := range retired {
+		close(process.stop)
+		if process.Running {
+			process.handle.Kill()
+		}
+	}
+	s.lock.Unlock()
+
+	serverLog.infof("agent pool scaled to %d", size)
+	for _, process := range retired {
+		retireAgent(readAgentID(process.IDFile))
+	}
+}
+
+// restart kills the process of the agent, the supervisor starts it again
+func (s *supervisor) restart(agentID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, process := range s.processes {
+		if process.Running && readAgentID(process.IDFile) == agentID {
+			serverLog.infof("restarting agent %d with pid=%d", process.Number, process.PID)
+			process.handle.Kill()
+			return
+		}
+	}
+}
+
+// start runs the agent of the slot with its output appended to the slot log file, or adopts the agent
+// the previous server left running in the slot, and returns the function waiting for it to exit
+func (s *supervisor) start(process *agentProcess) (func() error, error) {
+	if wait := s.adopt(process); wait != nil {
+		return wait, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(process.LogFile), 0755); err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(process.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the process is started under the lock so a scale down can't miss it
+	select {
+	case <-process.stop:
+		logFile.Close()
+		return nil, nil
+	default:
+	}
+
+	cmd := exec.Command(agentPath, "-id-file", process.IDFile, PORT)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = append(os.Environ(), ENV_BOOTSTRAP_TOKEN+"="+bootstrapToken)
+	if ca != nil {
+		caFile, _ := filepath.Abs(filepath.Join(pkiDir, CA_CERT_FILE))
+		cmd.Env = append(cmd.Env, ENV_CA_FILE+"="+caFile)
+	}
+	// in its own process group the agent doesn't get the Ctrl-C of the server, it may outlive it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+
+	process.handle = cmd.Process
+	process.PID = cmd.Process.Pid
+	process.Running = true
+	serverLog.infof("agent %d started with pid=%d, logs in %s", process.Number, process.PID, process.LogFile)
+	return func() error {
+		defer logFile.Close()
+		return cmd.Wait()
+	}, nil
+}
+
+// adopt takes over the agent the previous server left running in the slot, it isn't a child of
+// this server so its exit is polled
+func (s *supervisor) adopt(process *agentProcess) func() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	detached, ok := s.detached[process.Number]
+	if !ok {
+		return nil
+	}
+	delete(s.detached, process.Number)
+
+	if detached.IDFile != process.IDFile || !processAlive(detached.PID) {
+		return nil
+	}
+	handle, err := os.FindProcess(detached.PID)
+	if err != nil {
+		return nil
+	}
+
+	process.handle = handle
+	process.PID = detached.PID
+	process.Running = true
+	serverLog.infof("agent %d with pid=%d adopted from the previous server", process.Number, process.PID)
+	return func() error {
+		for processAlive(handle.Pid) {
+			time.Sleep(time.Second)
+		}
+		return nil
+	}
+}
+
+func processAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// run starts the agent of the slot and restarts it with exponential backoff every time it exits,
+// until the slot is removed by a scale down
+func (s *supervisor) run(process *agentProcess) {
+	backoff := agentRestartBackoff
+	for {
+		started := time.Now()
+		wait, err := s.start(process)
+		if wait != nil {
+			err = wait()
+		}
+
+		exit := "exited"
+		if err != nil {
+			exit = err.Error()
+		}
+
+		s.lock.Lock()
+		process.Running = false
+		process.LastExit = exit
+		s.lock.Unlock()
+
+		select {
+		case <-process.stop:
+			serverLog.infof("agent %d stopped", process.Number)
+			return
+		default:
+		}
+
+		if time.Since(started) > AGENT_STABLE_RUN {
+			backoff = agentRestartBackoff
+		}
+		serverLog.warnf("agent %d %s, restarting in %s", process.Number, exit, backoff)
+
+		select {
+		case <-process.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > AGENT_RESTART_MAX_BACKOFF {
+			backoff = AGENT_RESTART_MAX_BACKOFF
+		}
+
+		s.lock.Lock()
+		process.Restarts++
+		s.lock.Unlock()
+	}
+}
+
+// detach stops supervising the agents and leaves them running, the next server adopts them
+func (s *supervisor) detach() {
+	s.lock.Lock()
+	running := make([]agentProcess, 0, len(s.processes))
+	for _, process := range s.processes {
+		close(process.stop)
+		if process.Running {
+			running = append(running, *process)
+		}
+	}
+	s.processes = nil
+	s.lock.Unlock()
+
+	content, _ := json.MarshalIndent(running, "", " ")
+	if err := ioutil.WriteFile(PATH_DETACHED_AGENTS, content, 0644); err != nil {
+		serverLog.errorf("writing %s failed: %s", PATH_DETACHED_AGENTS, err)
+	}
+	serverLog.infof("%d agents detached", len(running))
+}
+
+// adoptDetached reads the agents the previous server left running, the slots of the same number adopt them
+func (s *supervisor) adoptDetached() {
+	var detached []agentProcess
+	if !readJSONToStructs(&detached, PATH_DETACHED_AGENTS) {
+		return
+	}
+	os.Remove(PATH_DETACHED_AGENTS)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.detached = make(map[int]agentProcess)
+	for _, process := range detached {
+		s.detached[process.Number] = process
+	}
+}
+
+// stopAll sends SIGTERM to every agent and SIGKILL to those still running when ctx is done,
+// the containers of the agents aren't rescheduled
+func (s *supervisor) stopAll(ctx context.Context) {
+	s.lock.Lock()
+	stopped := s.processes
+	s.processes = nil
+	for _, process := range stopped {
+		close(process.stop)
+		if process.Running {
+			process.handle.Signal(syscall.SIGTERM)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, process := range stopped {
+		for {
+			s.lock.Lock()
+			running := process.Running
+			s.lock.Unlock()
+			if !running {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				serverLog.warnf("agent %d didn't stop in time, killing it", process.Number)
+				process.handle.Kill()
+				time.Sleep(100 * time.Millisecond)
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	serverLog.infof("%d agents stopped", len(stopped))
+}
+
+func readAgentID(idFile string) string {
+	content, err := ioutil.ReadFile(idFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// retireAgent forgets an agent removed from the pool once its containers run somewhere else
+func retireAgent(agentID string) {
+	stateLock.Lock()
+	agent := agentByID(agentID)
+	if agent == nil {
+		stateLock.Unlock()
+		return
+	}
+	setAgentState(agent, AGENT_LOST, "removed from the agent pool")
+	stateLock.Unlock()
+
+	rescheduleContainers(agent)
+
+	stateLock.Lock()
+	if len(agent.MapContainerName) == 0 {
+		for i, other := range agentsArray {
+			if other == agent {
+				agentsArray = append(agentsArray[:i], agentsArray[i+1:]...)
+				break
+			}
+		}
+	}
+	stateLock.Unlock()
+	writeDataToJSON()
+}
+
+func agentPoolEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	respondWithJSON(responseHTTP, http.StatusOK, agentSupervisor.pool())
+}
+
+func scaleAgentPoolEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var pool AgentPool
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&pool); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	if pool.Size < 0 {
+		respondWithError(responseHTTP, invalidRequestError("the pool size can't be negative"))
+		return
+	}
+
+	requestLog(r).infof("scale agent pool to %d request", pool.Size)
+	auditOf(r).recordBefore(snapshot(agentSupervisor.pool()))
+	agentSupervisor.scale(pool.Size)
+	current := agentSupervisor.pool()
+	auditOf(r).recordAfter(snapshot(current))
+	respondWithJSON(responseHTTP, http.StatusOK, current)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// waitForPool polls the pool until check accepts it
+func waitForPool(t *testing.T, check func(pool AgentPool) bool) AgentPool {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pool := agentSupervisor.pool()
+		if check(pool) {
+			return pool
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("agent pool didn't reach the expected state: %+v", pool)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervisorKeepsThePoolSize(t *testing.T) {
+	newTestServer(t)
+
+	os.Setenv("FAKE_AGENT_PROCESS", "1")
+	defer os.Unsetenv("FAKE_AGENT_PROCESS")
+	defer func(path string, backoff time.Duration) {
+		agentPath, agentRestartBackoff = path, backoff
+	}(agentPath, agentRestartBackoff)
+	agentPath, agentRestartBackoff = os.Args[0], 10*time.Millisecond
+
+	agentSupervisor.scale(2)
+	defer agentSupervisor.scale(0)
+
+	pool := waitForPool(t, func(pool AgentPool) bool {
+		return pool.Size == 2 && pool.Processes[0].Running && pool.Processes[1].Running
+	})
+
+	// an agent that exits is started again
+	firstPID := pool.Processes[0].PID
+	process, err := os.FindProcess(firstPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process.Kill()
+
+	waitForPool(t, func(pool AgentPool) bool {
+		return pool.Processes[0].Running && pool.Processes[0].Restarts == 1 && pool.Processes[0].PID != firstPID
+	})
+
+	if _, err := os.Stat(pool.Processes[1].LogFile); err != nil {
+		t.Fatalf("agent log file missing: %s", err)
+	}
+
+	agentSupervisor.scale(1)
+	if pool := agentSupervisor.pool(); pool.Size != 1 || pool.Processes[0].Number != 0 {
+		t.Fatalf("unexpected pool after scale down: %+v", pool)
+	}
+}
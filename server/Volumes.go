@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+)
+
+const (
+	VOLUME_TYPE_VOLUME = "volume"
+	VOLUME_TYPE_BIND   = "bind"
+	VOLUME_TYPE_TMPFS  = "tmpfs"
+)
+
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// VolumeMount is a named volume, a host bind mount or a tmpfs mounted at Target in every replica,
+// each replica gets its own named volume, bind mounts are only allowed from the host paths the agent allows
+type VolumeMount struct {
+	Type     string `yaml:"Type"`
+	Source   string `yaml:"Source" json:",omitempty"`
+	Target   string `yaml:"Target"`
+	ReadOnly bool   `yaml:"ReadOnly" json:",omitempty"`
+	// Retain keeps a named volume on delete, the replica with the same index gets it back when created again
+	Retain bool `yaml:"Retain" json:",omitempty"`
+	// SizeMB limits a tmpfs, unlimited when zero
+	SizeMB int `yaml:"SizeMB" json:",omitempty"`
+}
+
+func invalidVolumeError(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusBadRequest, ERROR_INVALID_CONFIGURATION, fmt.Sprintf(format, args...))
+}
+
+func checkVolumesValidity(volumes []VolumeMount) *APIError {
+	targets := make(map[string]bool)
+	for _, volume := range volumes {
+		if !path.IsAbs(volume.Target) {
+			return invalidVolumeError("volume target %q must be an absolute path", volume.Target)
+		}
+		if targets[path.Clean(volume.Target)] {
+			return invalidVolumeError("volume target %s is mounted twice", volume.Target)
+		}
+		targets[path.Clean(volume.Target)] = true
+
+		switch volume.Type {
+		case VOLUME_TYPE_VOLUME:
+			if !volumeNamePattern.MatchString(volume.Source) {
+				return invalidVolumeError("volume name %q may only have letters, digits, '_', '.' and '-'", volume.Source)
+			}
+		case VOLUME_TYPE_BIND:
+			if !path.IsAbs(volume.Source) {
+				return invalidVolumeError("bind mount source %q must be an absolute host path", volume.Source)
+			}
+		case VOLUME_TYPE_TMPFS:
+			if volume.Source != "" {
+				return invalidVolumeError("tmpfs at %s has no source", volume.Target)
+			}
+			if volume.SizeMB < 0 {
+				return invalidVolumeError("tmpfs size at %s can't be negative", volume.Target)
+			}
+		default:
+			return invalidVolumeError("volume type must be %s, %s or %s", VOLUME_TYPE_VOLUME, VOLUME_TYPE_BIND, VOLUME_TYPE_TMPFS)
+		}
+
+		if volume.Retain && volume.Type != VOLUME_TYPE_VOLUME {
+			return invalidVolumeError("only named volumes can be retained, %s is a %s", volume.Target, volume.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVolumesValidity(t *testing.T) {
+	for _, test := range []struct {
+		volumes []VolumeMount
+		valid   bool
+	}{
+		{[]VolumeMount{{Type: VOLUME_TYPE_VOLUME, Source: "data", Target: "/data", Retain: true}}, true},
+		{[]VolumeMount{{Type: VOLUME_TYPE_BIND, Source: "/srv/web", Target: "/web", ReadOnly: true}}, true},
+		{[]VolumeMount{{Type: VOLUME_TYPE_TMPFS, Target: "/tmp", SizeMB: 64}}, true},
+		{[]VolumeMount{{Type: VOLUME_TYPE_VOLUME, Source: "../data", Target: "/data"}}, false},
+		{[]VolumeMount{{Type: VOLUME_TYPE_BIND, Source: "srv", Target: "/web"}}, false},
+		{[]VolumeMount{{Type: VOLUME_TYPE_TMPFS, Target: "tmp"}}, false},
+		{[]VolumeMount{{Type: VOLUME_TYPE_TMPFS, Target: "/tmp", Retain: true}}, false},
+		{[]VolumeMount{{Type: "nfs", Source: "data", Target: "/data"}}, false},
+		{[]VolumeMount{{Type: VOLUME_TYPE_TMPFS, Target: "/tmp"}, {Type: VOLUME_TYPE_VOLUME, Source: "data", Target: "/tmp/"}}, false},
+	} {
+		if apiError := checkVolumesValidity(test.volumes); (apiError == nil) != test.valid {
+			t.Errorf("volumes %+v: valid %t, got %v", test.volumes, test.valid, apiError)
+		}
+	}
+}
+
+func TestVolumesReachTheAgents(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	volumes := []VolumeMount{{Type: VOLUME_TYPE_VOLUME, Source: "data", Target: "/data", Retain: true}, {Type: VOLUME_TYPE_TMPFS, Target: "/cache"}}
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "db", Amount: 2, Image: "alpine", Volumes: volumes})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	agents[0].lock.Lock()
+	defer agents[0].lock.Unlock()
+	for _, name := range []string{"db1", "db2"} {
+		container := agents[0].containers[name]
+		if len(container.Volumes) != 2 || !container.Volumes[0].Retain || container.Volumes[1].Type != VOLUME_TYPE_TMPFS {
+			t.Fatalf("container %s reached the agent with %+v", name, container)
+		}
+	}
+}
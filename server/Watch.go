@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WATCH_ADDED    = "ADDED"
+	WATCH_MODIFIED = "MODIFIED"
+	WATCH_DELETED  = "DELETED"
+	// sent once the current state has been replayed to a new watcher
+	WATCH_SYNCED = "SYNCED"
+
+	KIND_CONFIGURATION = "Configuration"
+	KIND_CONTAINER     = "Container"
+	KIND_AGENT         = "Agent"
+
+	WATCH_HISTORY_SIZE     = 1000
+	WATCH_CHANNEL_SIZE     = 256
+	WATCH_KEEPALIVE_PERIOD = 15 * time.Second
+)
+
+// WatchEvent is a single change of a resource, ResourceVersion grows by one with every event
+type WatchEvent struct {
+	Type            string
+	Kind            string
+	Name            string
+	Namespace       string `json:",omitempty"`
+	ResourceVersion uint64
+	Object          json.RawMessage `json:",omitempty"`
+}
+
+// ContainerStatus is the object of container events
+type ContainerStatus struct {
+	Container
+	AgentPort int
+}
+
+type watchedObject struct {
+	kind            string
+	name            string
+	namespace       string
+	object          json.RawMessage
+	resourceVersion uint64
+}
+
+type watcher struct {
+	events chan WatchEvent
+	kinds  map[string]bool
+	name   string
+	// agents belong to no namespace, every watcher sees them
+	namespace string
+}
+
+// watchHub keeps the last published state, the recent events and the connected watchers
+type watchHub struct {
+	lock            sync.Mutex
+	resourceVersion uint64
+	objects         map[string]*watchedObject
+	history         []WatchEvent
+	watchers        map[*watcher]struct{}
+}
+
+var hub = &watchHub{
+	objects:  make(map[string]*watchedObject),
+	watchers: make(map[*watcher]struct{}),
+}
+
+func (w *watcher) wants(event WatchEvent) bool {
+	if len(w.kinds) != 0 && !w.kinds[event.Kind] {
+		return false
+	}
+
+	if event.Kind == KIND_AGENT {
+		return true
+	}
+	if w.namespace != "" && event.Namespace != w.namespace {
+		return false
+	}
+	if w.name == "" {
+		return true
+	}
+
+	if event.Kind == KIND_CONTAINER {
+		var container ContainerStatus
+		json.Unmarshal(event.Object, &container)
+		return container.ConfigurationName == w.name
+	}
+	return event.Name == w.name
+}
+
+func watchKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// currentObjects flattens the server state into the watched resources, keyed by kind, namespace and name
+func currentObjects() map[string]*watchedObject {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	objects := make(map[string]*watchedObject)
+	add := func(kind string, namespace string, name string, object interface{}) {
+		data, _ := json.Marshal(object)
+		objects[watchKey(kind, namespace, name)] = &watchedObject{kind: kind, name: name, namespace: namespace, object: data}
+	}
+
+	for _, configurationAgent := range mapConfigurationToAgents {
+		configuration := configurationAgent.Configuration
+		add(KIND_CONFIGURATION, configuration.Namespace, configuration.Name, configuration)
+	}
+
+	for _, agent := range agentsArray {
+		add(KIND_AGENT, "", agent.ID, agent)
+		for name, container := range agent.MapContainerName {
+			namespace := container.Namespace
+			if namespace == "" {
+				namespace = DEFAULT_NAMESPACE
+			}
+			add(KIND_CONTAINER, namespace, name, ContainerStatus{Container: *container, AgentPort: agent.Port})
+		}
+	}
+	return objects
+}
+
+// publishWatchEvents compares the server state with the last published one and sends the differences to the watchers
+func publishWatchEvents() {
+	// the snapshot is taken under the hub lock so two publishers can't send their changes out of order
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	objects := currentObjects()
+
+	events := make([]WatchEvent, 0)
+	for key, object := range objects {
+		previous, existed := hub.objects[key]
+		if existed && bytes.Equal(previous.object, object.object) {
+			object.resourceVersion = previous.resourceVersion
+			continue
+		}
+
+		eventType := WATCH_ADDED
+		if existed {
+			eventType = WATCH_MODIFIED
+		}
+		events = append(events, WatchEvent{Type: eventType, Kind: object.kind, Name: object.name, Namespace: object.namespace, Object: object.object})
+	}
+
+	for key, previous := range hub.objects {
+		if _, ok := objects[key]; !ok {
+			events = append(events, WatchEvent{Type: WATCH_DELETED, Kind: previous.kind, Name: previous.name, Namespace: previous.namespace, Object: previous.object})
+		}
+	}
+
+	// a watcher never sees a container of a configuration it doesn't know about
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventOrder(events[i]) < eventOrder(events[j])
+	})
+
+	for i := range events {
+		hub.resourceVersion++
+		events[i].ResourceVersion = hub.resourceVersion
+		if object, ok := objects[watchKey(events[i].Kind, events[i].Namespace, events[i].Name)]; ok {
+			object.resourceVersion = hub.resourceVersion
+		}
+		hub.broadcast(events[i])
+	}
+	hub.objects = objects
+}
+
+// additions go agents, configurations then containers, deletions the other way around
+func eventOrder(event WatchEvent) int {
+	order := map[string]int{KIND_AGENT: 0, KIND_CONFIGURATION: 1, KIND_CONTAINER: 2}[event.Kind]
+	if event.Type == WATCH_DELETED {
+		return 5 - order
+	}
+	return order
+}
+
+// broadcast must be called with the hub lock held
+func (h *watchHub) broadcast(event WatchEvent) {
+	h.history = append(h.history, event)
+	if len(h.history) > WATCH_HISTORY_SIZE {
+		h.history = h.history[len(h.history)-WATCH_HISTORY_SIZE:]
+	}
+
+	for w := range h.watchers {
+		if !w.wants(event) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			// a watcher that can't keep up is dropped, it resumes from its last resource version
+			serverLog.warnf("watcher is too slow, closing its stream")
+			close(w.events)
+			delete(h.watchers, w)
+		}
+	}
+}
+
+// subscribe registers a watcher and returns the events it missed: the whole state when
+// resourceVersion is zero, otherwise the history after it
+func (h *watchHub) subscribe(w *watcher, resourceVersion uint64) ([]WatchEvent, *APIError) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	backlog := make([]WatchEvent, 0)
+	if resourceVersion == 0 {
+		for _, object := range h.objects {
+			event := WatchEvent{Type: WATCH_ADDED, Kind: object.kind, Name: object.name, Namespace: object.namespace, ResourceVersion: object.resourceVersion, Object: object.object}
+			if w.wants(event) {
+				backlog = append(backlog, event)
+			}
+		}
+		sort.Slice(backlog, func(i, j int) bool {
+			return backlog[i].ResourceVersion < backlog[j].ResourceVersion
+		})
+		backlog = append(backlog, WatchEvent{Type: WATCH_SYNCED, ResourceVersion: h.resourceVersion})
+	} else {
+		if resourceVersion > h.resourceVersion || (len(h.history) != 0 && resourceVersion+1 < h.history[0].ResourceVersion) {
+			return nil, newAPIError(http.StatusGone, ERROR_EXPIRED, fmt.Sprintf("resource version %d is too old, watch again without it", resourceVersion))
+		}
+
+		for _, event := range h.history {
+			if event.ResourceVersion > resourceVersion && w.wants(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	h.watchers[w] = struct{}{}
+	return backlog, nil
+}
+
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.watchers[w]; ok {
+		close(w.events)
+		delete(h.watchers, w)
+	}
+}
+
+func writeServerSentEvent(responseHTTP http.ResponseWriter, event WatchEvent) error {
+	data, _ := json.Marshal(event)
+	_, err := fmt.Fprintf(responseHTTP, "id: %d\nevent: %s\ndata: %s\n\n", event.ResourceVersion, event.Type, data)
+	return err
+}
+
+// watchEndPoint streams the changes of configurations, containers and agents as server-sent events
+func watchEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	flusher, ok := responseHTTP.(http.Flusher)
+	if !ok {
+		respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, "streaming is not supported"))
+		return
+	}
+
+	query := r.URL.Query()
+	// without a namespace the watcher sees every namespace, a token limited to namespaces has to pick one of them
+	w := &watcher{events: make(chan WatchEvent, WATCH_CHANNEL_SIZE), kinds: make(map[string]bool), name: query.Get("name"), namespace: query.Get("namespace")}
+	if token := caller(r); w.namespace != "" || len(token.Namespaces) != 0 {
+		namespace, apiError := requestNamespace(r, "")
+		if apiError != nil {
+			respondWithError(responseHTTP, apiError)
+			return
+		}
+		w.namespace = namespace
+	}
+	if kinds := query.Get("kinds"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			w.kinds[kind] = true
+		}
+	}
+
+	var resourceVersion uint64
+	if version := query.Get("resourceVersion"); version != "" {
+		var err error
+		if resourceVersion, err = strconv.ParseUint(version, 10, 64); err != nil {
+			respondWithError(responseHTTP, invalidRequestError(err.Error()))
+			return
+		}
+	}
+
+	backlog, apiError := hub.subscribe(w, resourceVersion)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	defer hub.unsubscribe(w)
+
+	requestLog(r).infof("watch request kinds=%s namespace=%s name=%s from resource version %d", query.Get("kinds"), w.namespace, w.name, resourceVersion)
+
+	responseHTTP.Header().Set("Content-Type", "text/event-stream")
+	responseHTTP.Header().Set("Cache-Control", "no-cache")
+	responseHTTP.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		writeServerSentEvent(responseHTTP, event)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(WATCH_KEEPALIVE_PERIOD)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			fmt.Fprint(responseHTTP, ": keepalive\n\n")
+			flusher.Flush()
+
+		case event, ok := <-w.events:
+			if !ok {
+				return
+			}
+			if err := writeServerSentEvent(responseHTTP, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
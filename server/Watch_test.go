@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useNewHub replaces the watch hub by an empty one for the test
+func useNewHub(t *testing.T) {
+	previous := hub
+	hub = &watchHub{objects: make(map[string]*watchedObject), watchers: make(map[*watcher]struct{})}
+	t.Cleanup(func() { hub = previous })
+}
+
+func newWatcher(kinds ...string) *watcher {
+	w := &watcher{events: make(chan WatchEvent, WATCH_CHANNEL_SIZE), kinds: make(map[string]bool)}
+	for _, kind := range kinds {
+		w.kinds[kind] = true
+	}
+	return w
+}
+
+// receive takes the events the watcher got so far
+func receive(w *watcher) []string {
+	received := make([]string, 0)
+	for {
+		select {
+		case event := <-w.events:
+			received = append(received, event.Type+" "+event.Kind+" "+event.Name)
+		default:
+			return received
+		}
+	}
+}
+
+func TestWatchPublishesTheDifferences(t *testing.T) {
+	newTestServer(t, newFakeAgent(t))
+	useNewHub(t)
+
+	configuration := &Configuration{Name: "web", Namespace: DEFAULT_NAMESPACE, Amount: 1, Image: "alpine"}
+	stateLock.Lock()
+	agent := agentsArray[0]
+	mapConfigurationToAgents[configuration.key()] = &ConfigurationAgent{Configuration: configuration, AgentArray: []*Agent{agent}}
+	agent.MapContainerName["web1"] = &Container{Index: 1, ConfigurationName: "web", Namespace: DEFAULT_NAMESPACE, Image: "alpine"}
+	stateLock.Unlock()
+	publishWatchEvents()
+
+	// a new watcher gets the current state in the order of the additions, then SYNCED
+	w := newWatcher()
+	backlog, apiError := hub.subscribe(w, 0)
+	if apiError != nil {
+		t.Fatal(apiError)
+	}
+	received := make([]string, 0)
+	for _, event := range backlog {
+		received = append(received, event.Type+" "+event.Kind+" "+event.Name)
+	}
+	if expected := "[ADDED Agent agent-0 ADDED Configuration web ADDED Container web1 SYNCED  ]"; fmt.Sprint(received) != expected {
+		t.Fatalf("expected backlog %s, got %v", expected, received)
+	}
+
+	// nothing changed, nothing is sent
+	publishWatchEvents()
+	if received := receive(w); len(received) != 0 {
+		t.Fatalf("events without a change: %v", received)
+	}
+
+	stateLock.Lock()
+	configuration.Amount = 2
+	stateLock.Unlock()
+	publishWatchEvents()
+	if received := receive(w); fmt.Sprint(received) != "[MODIFIED Configuration web]" {
+		t.Fatalf("events of an update: %v", received)
+	}
+
+	// deletions go containers first, the agent loses its container
+	stateLock.Lock()
+	delete(mapConfigurationToAgents, configuration.key())
+	delete(agent.MapContainerName, "web1")
+	stateLock.Unlock()
+	publishWatchEvents()
+	if received := receive(w); fmt.Sprint(received) != "[MODIFIED Agent agent-0 DELETED Container web1 DELETED Configuration web]" {
+		t.Fatalf("events of a delete: %v", received)
+	}
+}
+
+func TestWatchFilters(t *testing.T) {
+	configurationEvent := WatchEvent{Kind: KIND_CONFIGURATION, Name: "web", Namespace: "team-a"}
+	containerObject, _ := json.Marshal(ContainerStatus{Container: Container{ConfigurationName: "web", Index: 1}})
+	containerEvent := WatchEvent{Kind: KIND_CONTAINER, Name: "team-a_web1", Namespace: "team-a", Object: containerObject}
+	agentEvent := WatchEvent{Kind: KIND_AGENT, Name: "agent-0"}
+
+	for _, test := range []struct {
+		watcher  *watcher
+		expected [3]bool
+	}{
+		{&watcher{}, [3]bool{true, true, true}},
+		{&watcher{kinds: map[string]bool{KIND_AGENT: true}}, [3]bool{false, false, true}},
+		// agents belong to no namespace
+		{&watcher{namespace: "team-b"}, [3]bool{false, false, true}},
+		{&watcher{namespace: "team-a", name: "web"}, [3]bool{true, true, true}},
+		{&watcher{name: "api"}, [3]bool{false, false, true}},
+	} {
+		got := [3]bool{test.watcher.wants(configurationEvent), test.watcher.wants(containerEvent), test.watcher.wants(agentEvent)}
+		if got != test.expected {
+			t.Errorf("watcher %+v: expected %v, got %v", *test.watcher, test.expected, got)
+		}
+	}
+}
+
+func TestWatchResumesFromTheHistory(t *testing.T) {
+	useNewHub(t)
+
+	hub.lock.Lock()
+	for i := 0; i < WATCH_HISTORY_SIZE+10; i++ {
+		hub.resourceVersion++
+		hub.broadcast(WatchEvent{Type: WATCH_MODIFIED, Kind: KIND_AGENT, Name: "agent-0", ResourceVersion: hub.resourceVersion})
+	}
+	last := hub.resourceVersion
+	hub.lock.Unlock()
+
+	backlog, apiError := hub.subscribe(newWatcher(), last-3)
+	if apiError != nil || len(backlog) != 3 || backlog[0].ResourceVersion != last-2 {
+		t.Fatalf("resume from %d: %v %+v", last-3, apiError, backlog)
+	}
+
+	// the history only keeps the last events, a watcher behind it starts again
+	for _, version := range []uint64{1, last + 1} {
+		if _, apiError := hub.subscribe(newWatcher(), version); apiError == nil || apiError.Status != http.StatusGone || apiError.Code != ERROR_EXPIRED {
+			t.Errorf("resume from %d: expected expired, got %v", version, apiError)
+		}
+	}
+}
+
+func TestSlowWatcherIsDropped(t *testing.T) {
+	useNewHub(t)
+
+	w := &watcher{events: make(chan WatchEvent, 1)}
+	hub.subscribe(w, 0)
+
+	hub.lock.Lock()
+	hub.broadcast(WatchEvent{Type: WATCH_ADDED, Kind: KIND_AGENT, Name: "agent-0", ResourceVersion: 1})
+	hub.broadcast(WatchEvent{Type: WATCH_ADDED, Kind: KIND_AGENT, Name: "agent-1", ResourceVersion: 2})
+	_, stillWatching := hub.watchers[w]
+	hub.lock.Unlock()
+
+	if stillWatching {
+		t.Fatal("the watcher that can't keep up is still registered")
+	}
+	if event := <-w.events; event.Name != "agent-0" {
+		t.Fatalf("expected the event sent before the drop, got %+v", event)
+	}
+	if _, open := <-w.events; open {
+		t.Fatal("the events of a dropped watcher are still open")
+	}
+}
+
+func TestWatchStream(t *testing.T) {
+	server := newTestServer(t, newFakeAgent(t))
+	useNewHub(t)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+API_V1_PREFIX+"/watch?kinds="+KIND_CONFIGURATION, nil)
+	resp, err := testClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("watch: status %d %v", resp.StatusCode, resp.Header)
+	}
+
+	events := make(chan string, 16)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+				events <- strings.TrimPrefix(line, "event: ")
+			}
+		}
+		close(events)
+	}()
+
+	expect := func(expected string) {
+		select {
+		case event := <-events:
+			if event != expected {
+				t.Fatalf("expected event %s, got %s", expected, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", expected)
+		}
+	}
+
+	expect(WATCH_SYNCED)
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 1, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+	expect(WATCH_ADDED)
+
+	if status, _ := doRequest(t, http.MethodGet, server.URL+API_V1_PREFIX+"/watch?resourceVersion=x", nil); status != http.StatusBadRequest {
+		t.Fatalf("watch from an invalid resource version: status %d", status)
+	}
+}
@@ -1,302 +1,531 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"net"
-	"net/http"
-	"strconv"
-	"time"
-
-	"github.com/gorilla/mux"
-	"github.com/mercadolibre/golang-restclient/rest"
-)
-
-const SERVER_PORT = "127.0.0.1:1234"
-const PORT = "1234"
-const BASE_URL = "http://localhost:"
-const AGENT_PATH = "../agent/agent"
-const AGENTS_AMOUNTS = 2
-const PATH_MAP = "server/mapConfigurationToAgents.json"
-const PATH_AGENTARRAY = "server/agentsArray.json"
-
-var agentsArray []*Agent
-var mapConfigurationToAgents map[string]*ConfigurationAgent
-
-func generateAvilablePort() net.Listener {
-	listener, err := net.Listen("tcp", ":0")
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Println("Using port:", listener.Addr().(*net.TCPAddr).Port)
-	return listener
-
-}
-
-func respondWithError(response http.ResponseWriter, code int, message string) {
-	log.Println("error happened: " + message)
-	respondWithJSON(response, code, "error :"+message)
-}
-
-func respondWithJSON(responseHTTP http.ResponseWriter, code int, payload interface{}) {
-	responseJSON, _ := json.Marshal(payload)
-
-	responseHTTP.Header().Set("Content-Type", "application/json")
-	responseHTTP.WriteHeader(code)
-	responseHTTP.Write(responseJSON)
-}
-
-func envStatusEndpoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	configurationArray := make([]Configuration, 0)
-	for _, configurationAgent := range mapConfigurationToAgents {
-		configurationArray = append(configurationArray, *configurationAgent.Configuration)
-	}
-
-	log.Println("show env status request")
-	respondWithJSON(responseHTTP, http.StatusCreated, configurationArray)
-}
-
-func agentsStatusEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	log.Println("show agents status request")
-	respondWithJSON(responseHTTP, http.StatusCreated, agentsArray)
-}
-
-func agentPortEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	var portAgent string
-
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&portAgent); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-	defer r.Body.Close()
-
-	var err error
-	var port int
-
-	if port, err = strconv.Atoi(portAgent); err != nil {
-		log.Println(err)
-		return
-	}
-
-	agentAlreadyExisted := false
-	for _, agent := range agentsArray {
-		if agent.Active == false {
-			agent.Active = true
-			agent.Port = port
-			agentAlreadyExisted = true
-			log.Printf("agent %d was replaced\n", port)
-		}
-	}
-
-	if !agentAlreadyExisted {
-		agentToAdd := new(Agent)
-		agentToAdd.Port = port
-		agentToAdd.MapContainerName = make(map[string]*Container)
-		agentToAdd.Active = true
-
-		agentsArray = append(agentsArray, agentToAdd)
-
-		log.Printf("agent %d created\n", port)
-
-	}
-	writeDataToJSON()
-	respondWithJSON(responseHTTP, http.StatusCreated, portAgent)
-}
-
-func envNameStatusEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	var configurationName string
-	decoder := json.NewDecoder(r.Body)
-
-	if err := decoder.Decode(&configurationName); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	log.Printf("env %s status request \n", configurationName)
-	var status *ConfigurationAgent
-
-	if getStatusByConfiguration(configurationName, &status) {
-		log.Println(status)
-		respondWithJSON(responseHTTP, http.StatusCreated, status)
-	} else {
-		respondWithError(responseHTTP, http.StatusBadRequest, "The configuration doesn't exists")
-	}
-
-	defer r.Body.Close()
-}
-
-func deleteEndPoint(responseHTTP http.ResponseWriter, request *http.Request) {
-
-	var configurationNameToDelete string
-	decoder := json.NewDecoder(request.Body)
-	if err := decoder.Decode(&configurationNameToDelete); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	log.Printf("delete %s request", configurationNameToDelete)
-	defer request.Body.Close()
-
-	deleteSucceed, errorOfDelete := removeConfiguration(configurationNameToDelete, 1)
-	writeDataToJSON()
-	if deleteSucceed {
-		messesgeSuccess := fmt.Sprintf("configuration %s been deleted", configurationNameToDelete)
-		respondWithJSON(responseHTTP, http.StatusCreated, messesgeSuccess)
-	} else {
-		respondWithError(responseHTTP, http.StatusBadRequest, errorOfDelete)
-	}
-
-}
-
-func createEndpoint(responseHTTP http.ResponseWriter, r *http.Request) {
-
-	var configuration Configuration
-	decoder := json.NewDecoder(r.Body)
-
-	if err := decoder.Decode(&configuration); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	containersSucceed, errorMessege := createConfigurationToAgents(&configuration, 1)
-
-	writeDataToJSON()
-	if containersSucceed {
-		respondWithJSON(responseHTTP, http.StatusCreated, "Containers created")
-	} else {
-		respondWithError(responseHTTP, http.StatusBadRequest, errorMessege)
-	}
-	defer r.Body.Close()
-}
-
-func updateEndpoint(responseHTTP http.ResponseWriter, r *http.Request) {
-	var configuration Configuration
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&configuration); err != nil {
-		respondWithError(responseHTTP, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	fmt.Printf("update %s request", configuration.Name)
-	defer r.Body.Close()
-	updataSucceed, errorMessage := update(&configuration)
-
-	writeDataToJSON()
-	if updataSucceed {
-		respondWithJSON(responseHTTP, http.StatusCreated, "Update complete")
-	} else {
-		respondWithError(responseHTTP, http.StatusBadRequest, errorMessage)
-	}
-}
-
-//Server functions to Agent
-
-func runContainer(container Container, port string) *rest.Response {
-	log.Println("container send to agent request")
-	var rb rest.RequestBuilder
-	rb.DisableTimeout = true
-	resp := rb.Post(fmt.Sprintf("%s%s/runContainer", BASE_URL, port), container)
-	return resp
-}
-
-func deleteContainer(containerName string, port string) *rest.Response {
-	resp := rest.Post(fmt.Sprintf("%s%s/deleteContainer", BASE_URL, port), containerName)
-	return resp
-}
-
-func writeDataToJSON() {
-	file, _ := json.MarshalIndent(mapConfigurationToAgents, "", " ")
-	_ = ioutil.WriteFile("mapConfigurationToAgents.json", file, 0644)
-	file, _ = json.MarshalIndent(agentsArray, "", " ")
-	_ = ioutil.WriteFile("agentsArray.json", file, 0644)
-}
-
-// read the data from json files
-
-// func readJSONToStructs(variable interface{}, path string) bool {
-
-// 	absPath, _ := filepath.Abs(fmt.Sprintf("../%s", path))
-
-// 	if _, err := os.Stat(absPath); err == nil {
-
-// 		configFile, err := os.Open(absPath)
-// 		if err != nil {
-// 			log.Println("opening config file", err.Error())
-// 			return false
-// 		}
-
-// 		jsonParser := json.NewDecoder(configFile)
-// 		if err = jsonParser.Decode(&variable); err != nil {
-// 			log.Println("parsing config file", err.Error())
-// 			return false
-// 		}
-// 		if variable == nil {
-// 			return false
-// 		}
-
-// 		return true
-// 	}
-
-// 	return false
-// }
-
-// func initalizeParams() {
-// 	if !readJSONToStructs(mapConfigurationToAgents, PATH_MAP) {
-// 		mapConfigurationToAgents = make(map[string]*ConfigurationAgent)
-// 	}
-
-// 	if !readJSONToStructs(agentsArray, PATH_AGENTARRAY) {
-// 		createAgent()
-// 	}
-// }
-
-func main() {
-	mapConfigurationToAgents = make(map[string]*ConfigurationAgent)
-	createAgents()
-
-	log.SetFlags(log.LstdFlags | log.Llongfile)
-
-	r := mux.NewRouter()
-	api := r.PathPrefix("/").Subrouter()
-
-	api.HandleFunc("/envStatus", envStatusEndpoint).Methods(http.MethodGet)
-	api.HandleFunc("/agentsStatus", agentsStatusEndPoint).Methods(http.MethodGet)
-	api.HandleFunc("/agentPort", agentPortEndPoint).Methods(http.MethodPost)
-	api.HandleFunc("/create", createEndpoint).Methods(http.MethodPost)
-	api.HandleFunc("/delete", deleteEndPoint).Methods(http.MethodPost)
-	api.HandleFunc("/update", updateEndpoint).Methods(http.MethodPost)
-	api.HandleFunc("/envNameStatus", envNameStatusEndPoint).Methods(http.MethodPost)
-
-	go func() {
-		for true {
-			time.Sleep(10 * time.Second)
-			for _, agent := range agentsArray {
-
-				resp, err := http.Get(fmt.Sprintf("%s%d/isAgentActive", BASE_URL, agent.Port))
-				if err != nil {
-					log.Printf("agent with port=%d is not responding\n", agent.Port)
-					agent.Active = false
-					createAgent()
-					continue
-				}
-				resp.Body.Close()
-
-				if resp.StatusCode == http.StatusCreated {
-					agent.Active = true
-					log.Printf("agent with port=%d is ALIVE\n", agent.Port)
-				}
-			}
-		}
-	}()
-
-	log.Println("Server is waiting for connections on port " + PORT)
-
-	portToListen := fmt.Sprintf(":" + PORT)
-	err := http.ListenAndServe(portToListen, r)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mercadolibre/golang-restclient/rest"
+)
+
+const SERVER_PORT = "127.0.0.1:1234"
+const PORT = "1234"
+const BASE_URL = "http://localhost:"
+const WEBSOCKET_BASE_URL = "ws://localhost:"
+const TLS_BASE_URL = "https://localhost:"
+const TLS_WEBSOCKET_BASE_URL = "wss://localhost:"
+const AGENT_PATH = "../agent/agent"
+const DEFAULT_AGENTS_AMOUNT = 2
+const PATH_MAP = "mapConfigurationToAgents.json"
+const PATH_AGENTARRAY = "agentsArray.json"
+
+var agentsArray []*Agent
+var mapConfigurationToAgents map[string]*ConfigurationAgent
+
+func generateAvilablePort() net.Listener {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	fmt.Println("Using port:", listener.Addr().(*net.TCPAddr).Port)
+	return listener
+
+}
+
+func respondWithError(response http.ResponseWriter, apiError *APIError) {
+	responseLog(response).warnf("error happened: %s (%s)", apiError.Message, apiError.Code)
+	respondWithJSON(response, apiError.Status, apiError)
+}
+
+func respondWithJSON(responseHTTP http.ResponseWriter, code int, payload interface{}) {
+	responseJSON, _ := json.Marshal(payload)
+
+	responseHTTP.Header().Set("Content-Type", "application/json")
+	responseHTTP.WriteHeader(code)
+	responseHTTP.Write(responseJSON)
+}
+
+func agentsStatusEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	requestLog(r).infof("show agents status request")
+
+	stateLock.RLock()
+	agentsJSON, _ := json.Marshal(agentsArray)
+	stateLock.RUnlock()
+
+	respondWithJSON(responseHTTP, http.StatusOK, json.RawMessage(agentsJSON))
+}
+
+// agentPortEndPoint registers an agent, an agent that registers again with a known ID is a reconnect
+// and keeps its containers
+func agentPortEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&body); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	// agents from before the agent ID send only their port, they always register as new agents
+	var registration AgentRegistration
+	var portAgent string
+	if err := json.Unmarshal(body, &portAgent); err == nil {
+		port, err := strconv.Atoi(portAgent)
+		if err != nil {
+			respondWithError(responseHTTP, invalidRequestError(err.Error()))
+			return
+		}
+		id, err := newUUID()
+		if err != nil {
+			respondWithError(responseHTTP, newAPIError(http.StatusInternalServerError, ERROR_INTERNAL, err.Error()))
+			return
+		}
+		registration = AgentRegistration{ID: id, Port: port}
+
+	} else if err := json.Unmarshal(body, &registration); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+
+	if registration.ID == "" || registration.Port <= 0 {
+		respondWithError(responseHTTP, invalidRequestError("an agent registers with its ID and port"))
+		return
+	}
+	if apiError := checkAgentIdentity(r, registration.ID); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	stateLock.Lock()
+	status := http.StatusOK
+	agent := agentByID(registration.ID)
+	if agent != nil {
+		agent.Port = registration.Port
+		agent.LastHeartbeat = time.Now().UTC()
+		setAgentState(agent, AGENT_READY, "")
+		serverLog.infof("agent %s reconnected on port %d", agent.ID, agent.Port)
+	} else {
+		status = http.StatusCreated
+		agent = new(Agent)
+		agent.ID = registration.ID
+		agent.Port = registration.Port
+		agent.MapContainerName = make(map[string]*Container)
+		agent.LastHeartbeat = time.Now().UTC()
+		setAgentState(agent, AGENT_READY, "")
+
+		agentsArray = append(agentsArray, agent)
+		serverLog.infof("agent %s created on port %d", agent.ID, agent.Port)
+	}
+
+	// the port was free for the agent to take it, whoever had it before is gone
+	for _, other := range agentsArray {
+		if other != agent && other.Port == agent.Port && other.Active {
+			setAgentState(other, AGENT_UNKNOWN, fmt.Sprintf("its port was taken by agent %s", agent.ID))
+		}
+	}
+	agentJSON, _ := json.Marshal(agent)
+	stateLock.Unlock()
+
+	writeDataToJSON()
+	respondWithJSON(responseHTTP, status, json.RawMessage(agentJSON))
+}
+
+func envNameStatusEndPoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var configurationName string
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&configurationName); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+
+	defer r.Body.Close()
+
+	namespace, apiError := requestNamespace(r, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	respondWithConfigurationStatus(responseHTTP, namespace, configurationName)
+}
+
+func respondWithConfigurationStatus(responseHTTP http.ResponseWriter, namespace string, configurationName string) {
+	key := configurationKey(namespace, configurationName)
+	responseLog(responseHTTP).infof("env %s status request", key)
+	var status *ConfigurationAgent
+
+	stateLock.RLock()
+	found := getStatusByConfiguration(key, &status)
+	statusJSON, _ := json.Marshal(status)
+	stateLock.RUnlock()
+
+	if found {
+		responseLog(responseHTTP).debugf("%s", statusJSON)
+		respondWithJSON(responseHTTP, http.StatusOK, json.RawMessage(statusJSON))
+	} else {
+		respondWithError(responseHTTP, configurationNotFoundError(key))
+	}
+}
+
+func deleteEndPoint(responseHTTP http.ResponseWriter, request *http.Request) {
+
+	var configurationNameToDelete string
+	decoder := json.NewDecoder(request.Body)
+	if err := decoder.Decode(&configurationNameToDelete); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+
+	defer request.Body.Close()
+
+	namespace, apiError := requestNamespace(request, "")
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	startDeleteConfiguration(responseHTTP, request, namespace, configurationNameToDelete)
+}
+
+// startDeleteConfiguration, startCreateConfiguration and startUpdateConfiguration answer the checks that
+// don't need the agents right away, the operation repeats them once it holds the configuration lock
+func startDeleteConfiguration(responseHTTP http.ResponseWriter, r *http.Request, namespace string, configurationNameToDelete string) {
+	key := configurationKey(namespace, configurationNameToDelete)
+	requestLog(r).infof("delete %s request", key)
+	auditOf(r).target(namespace, configurationNameToDelete)
+	if !configurationExists(key) {
+		respondWithError(responseHTTP, configurationNotFoundError(key))
+		return
+	}
+
+	operation := newOperation(r, OPERATION_DELETE, namespace, configurationNameToDelete)
+	startOperation(r, operation, func(operation *Operation) *APIError {
+		return removeConfiguration(operation, key, 1)
+	})
+	respondWithOperation(responseHTTP, r, operation)
+}
+
+func createEndpoint(responseHTTP http.ResponseWriter, r *http.Request) {
+
+	var configuration Configuration
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&configuration); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	namespace, apiError := requestNamespace(r, configuration.Namespace)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	configuration.Namespace = namespace
+	startCreateConfiguration(responseHTTP, r, &configuration)
+}
+
+// startCreateConfiguration and startUpdateConfiguration expect the namespace of the configuration to be set
+func startCreateConfiguration(responseHTTP http.ResponseWriter, r *http.Request, configuration *Configuration) {
+	requestLog(r).infof("create %s request", configuration.key())
+	auditOf(r).target(configuration.Namespace, configuration.Name)
+	// only new names are checked, an update recreating the containers of a configuration created before keeps its name
+	if apiError := checkConfigurationNameValidity(configuration.Name); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	if apiError := checkCreateParamValidity(configuration, 1); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	if apiError := checkReferencesAccess(caller(r), configuration.Namespace, configuration.ConfigMaps, configuration.Secrets); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	operation := newOperation(r, OPERATION_CREATE, configuration.Namespace, configuration.Name)
+	startOperation(r, operation, func(operation *Operation) *APIError {
+		return createConfigurationToAgents(operation, configuration, 1)
+	})
+	respondWithOperation(responseHTTP, r, operation)
+}
+
+func updateEndpoint(responseHTTP http.ResponseWriter, r *http.Request) {
+	var configuration Configuration
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&configuration); err != nil {
+		respondWithError(responseHTTP, invalidRequestError(err.Error()))
+		return
+	}
+
+	defer r.Body.Close()
+
+	namespace, apiError := requestNamespace(r, configuration.Namespace)
+	if apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	configuration.Namespace = namespace
+	startUpdateConfiguration(responseHTTP, r, &configuration)
+}
+
+func startUpdateConfiguration(responseHTTP http.ResponseWriter, r *http.Request, configuration *Configuration) {
+	requestLog(r).infof("update %s request", configuration.key())
+	auditOf(r).target(configuration.Namespace, configuration.Name)
+	if apiError := checkAmountImageNameValdity(configuration); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+	if !configurationExists(configuration.key()) {
+		respondWithError(responseHTTP, configurationNotFoundError(configuration.key()))
+		return
+	}
+	if apiError := checkReferencesAccess(caller(r), configuration.Namespace, configuration.ConfigMaps, configuration.Secrets); apiError != nil {
+		respondWithError(responseHTTP, apiError)
+		return
+	}
+
+	operation := newOperation(r, OPERATION_UPDATE, configuration.Namespace, configuration.Name)
+	startOperation(r, operation, func(operation *Operation) *APIError {
+		return update(operation, configuration)
+	})
+	respondWithOperation(responseHTTP, r, operation)
+}
+
+//Server functions to Agent
+
+// runContainer and deleteContainer pass the request ID on so the agent logs the request under it
+func runContainer(container Container, port string, requestID string) *rest.Response {
+	serverLog.debugf("container send to agent request")
+
+	// the config maps are read when the container is created, a later change doesn't reach it
+	stateLock.RLock()
+	resolved, apiError := resolveContainer(container)
+	stateLock.RUnlock()
+	if apiError != nil {
+		return &rest.Response{Err: apiError}
+	}
+
+	rb := agentRequestBuilder(runContainerTimeout)
+	rb.Headers = http.Header{REQUEST_ID_HEADER: []string{requestID}}
+	resp := rb.Post(fmt.Sprintf("%s%s/runContainer", agentBaseURL(), port), resolved)
+	return resp
+}
+
+// deleteContainer waits for the agent through the grace period of the container on top of deleteContainerTimeout
+func deleteContainer(container Container, port string, requestID string) *rest.Response {
+	gracePeriod := container.TerminationGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DEFAULT_TERMINATION_GRACE_PERIOD
+	}
+
+	rb := agentRequestBuilder(deleteContainerTimeout + time.Duration(gracePeriod)*time.Second)
+	rb.Headers = http.Header{REQUEST_ID_HEADER: []string{requestID}}
+	resp := rb.Post(fmt.Sprintf("%s%s/deleteContainer", agentBaseURL(), port), containerName(&container))
+	return resp
+}
+
+func writeDataToJSON() {
+	persistLock.Lock()
+	stateLock.RLock()
+	mapFile, _ := json.MarshalIndent(mapConfigurationToAgents, "", " ")
+	agentsFile, _ := json.MarshalIndent(agentsArray, "", " ")
+	configMapsFile, _ := json.MarshalIndent(configMaps, "", " ")
+	quotasFile, _ := json.MarshalIndent(quotas, "", " ")
+	secretsFile, secretsErr := encryptSecrets()
+	stateLock.RUnlock()
+
+	_ = ioutil.WriteFile(PATH_MAP, mapFile, 0644)
+	_ = ioutil.WriteFile(PATH_AGENTARRAY, agentsFile, 0644)
+	_ = ioutil.WriteFile(PATH_CONFIGMAPS, configMapsFile, 0644)
+	_ = ioutil.WriteFile(PATH_QUOTAS, quotasFile, 0644)
+	if secretsErr != nil {
+		serverLog.errorf("secrets not written: %s", secretsErr)
+	} else {
+		_ = ioutil.WriteFile(PATH_SECRETS, secretsFile, 0600)
+	}
+	persistLock.Unlock()
+
+	// every change of the state ends with writing it, watchers hear about it from here
+	publishWatchEvents()
+}
+
+// readJSONToStructs fills variable from the json file at path, false if there is no such file
+func readJSONToStructs(variable interface{}, path string) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	if err := json.Unmarshal(content, variable); err != nil {
+		serverLog.errorf("parsing %s failed: %s", path, err)
+		return false
+	}
+	return true
+}
+
+// initalizeParams loads the state the previous server left behind, its agents may still be running
+// and are Unknown until their next heartbeat
+func initalizeParams() {
+	mapConfigurationToAgents = make(map[string]*ConfigurationAgent)
+	agentsArray = make([]*Agent, 0)
+
+	configMaps = make(map[string]*ConfigMap)
+	readJSONToStructs(&configMaps, PATH_CONFIGMAPS)
+	quotas = make(map[string]*Quota)
+	readJSONToStructs(&quotas, PATH_QUOTAS)
+
+	var err error
+	if secrets, err = readSecrets(); err != nil {
+		serverLog.fatalf("can't decrypt %s with the key in %s: %s", PATH_SECRETS, secretKeyFile, err)
+	}
+
+	var agents []*Agent
+	var configurations map[string]*ConfigurationAgent
+	if !readJSONToStructs(&agents, PATH_AGENTARRAY) || !readJSONToStructs(&configurations, PATH_MAP) {
+		return
+	}
+
+	agentsByID := make(map[string]*Agent)
+	for _, agent := range agents {
+		if agent.ID == "" {
+			continue
+		}
+		if agent.MapContainerName == nil {
+			agent.MapContainerName = make(map[string]*Container)
+		}
+		agent.LastHeartbeat = time.Now().UTC()
+		setAgentState(agent, AGENT_UNKNOWN, "waiting for the agent after a server restart")
+		agentsByID[agent.ID] = agent
+		agentsArray = append(agentsArray, agent)
+	}
+
+	// the files hold copies of the agents for every configuration, they point to the same agents again
+	for _, configurationAgent := range configurations {
+		if configurationAgent == nil || configurationAgent.Configuration == nil {
+			continue
+		}
+		// the files of a server from before namespaces are keyed by name only
+		if configurationAgent.Configuration.Namespace == "" {
+			configurationAgent.Configuration.Namespace = DEFAULT_NAMESPACE
+		}
+		agentArray := make([]*Agent, 0, len(configurationAgent.AgentArray))
+		for _, agent := range configurationAgent.AgentArray {
+			if known, ok := agentsByID[agent.ID]; ok {
+				agentArray = append(agentArray, known)
+			}
+		}
+		configurationAgent.AgentArray = agentArray
+		mapConfigurationToAgents[configurationAgent.Configuration.key()] = configurationAgent
+	}
+
+	serverLog.infof("loaded %d configurations and %d agents from the previous run", len(mapConfigurationToAgents), len(agentsArray))
+}
+
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(withRequestID, measureRequests)
+	registerAPIv1Routes(r.PathPrefix(API_V1_PREFIX).Subrouter())
+
+	api := r.PathPrefix("/").Subrouter()
+	api.HandleFunc("/agentPort", agentOnly(agentPortEndPoint)).Methods(http.MethodPost)
+	api.HandleFunc("/agentHeartbeat", agentOnly(heartbeatEndPoint)).Methods(http.MethodPost)
+	api.HandleFunc("/agentCertificate", agentOnly(agentCertificateEndPoint)).Methods(http.MethodPost)
+	api.HandleFunc("/metrics", allow(ROLE_VIEWER, metricsEndPoint)).Methods(http.MethodGet)
+
+	// the routes from before /api/v1, kept so existing scripts keep working
+	api.HandleFunc("/envStatus", deprecated(allow(ROLE_VIEWER, listConfigurationsEndPoint), API_V1_PREFIX+"/configurations")).Methods(http.MethodGet)
+	api.HandleFunc("/agentsStatus", deprecated(allow(ROLE_VIEWER, agentsStatusEndPoint), API_V1_PREFIX+"/agents")).Methods(http.MethodGet)
+	api.HandleFunc("/create", deprecated(audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, createEndpoint)), API_V1_PREFIX+"/configurations")).Methods(http.MethodPost)
+	api.HandleFunc("/delete", deprecated(audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, deleteEndPoint)), API_V1_PREFIX+"/configurations/{name}")).Methods(http.MethodPost)
+	api.HandleFunc("/update", deprecated(audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, updateEndpoint)), API_V1_PREFIX+"/configurations/{name}")).Methods(http.MethodPost)
+	api.HandleFunc("/envNameStatus", deprecated(allow(ROLE_VIEWER, envNameStatusEndPoint), API_V1_PREFIX+"/configurations/{name}")).Methods(http.MethodPost)
+	api.HandleFunc("/logs/{name}", deprecated(allow(ROLE_VIEWER, containerLogsEndPoint), API_V1_PREFIX+"/configurations/{name}/logs")).Methods(http.MethodGet)
+	api.HandleFunc("/exec/{name}", deprecated(audited(AUDIT_KIND_CONFIGURATION, allow(ROLE_EDITOR, execEndPoint)), API_V1_PREFIX+"/configurations/{name}/exec")).Methods(http.MethodGet)
+
+	return r
+}
+
+func main() {
+	flag.IntVar(&agentConcurrency, "agent-concurrency", DEFAULT_AGENT_CONCURRENCY, "maximum requests sent to agents at once by a single operation")
+	flag.DurationVar(&runContainerTimeout, "run-timeout", DEFAULT_RUN_CONTAINER_TIMEOUT, "deadline of a single container creation, image pull included")
+	flag.DurationVar(&deleteContainerTimeout, "delete-timeout", DEFAULT_DELETE_CONTAINER_TIMEOUT, "deadline of a single container deletion")
+	flag.DurationVar(&agentLease, "agent-lease", DEFAULT_AGENT_LEASE, "an agent without heartbeat for this long is Unknown and gets no new containers")
+	flag.DurationVar(&agentGracePeriod, "agent-grace-period", DEFAULT_AGENT_GRACE_PERIOD, "an Unknown agent is Lost after this long, it is replaced and its containers rescheduled")
+	agentsAmount := flag.Int("agents", DEFAULT_AGENTS_AMOUNT, "number of local agents the server keeps running")
+	flag.StringVar(&secretKeyFile, "secret-key-file", DEFAULT_SECRET_KEY_FILE, "key encrypting the secrets on disk, created when missing")
+	flag.StringVar(&tokensFile, "tokens-file", DEFAULT_TOKENS_FILE, "API tokens of the CLI users and their roles, created with an admin token when missing, empty turns authentication off")
+	flag.StringVar(&bootstrapTokenFile, "bootstrap-token-file", DEFAULT_BOOTSTRAP_TOKEN_FILE, "token the agents register with, created when missing")
+	flag.StringVar(&pkiDir, "pki-dir", DEFAULT_PKI_DIR, "directory of the CA and the server certificate, created when missing, empty turns TLS off")
+	flag.DurationVar(&certValidity, "cert-validity", DEFAULT_CERT_VALIDITY, "lifetime of the server and agent certificates, they are renewed after two thirds of it")
+	flag.StringVar(&tlsHosts, "tls-hosts", DEFAULT_TLS_HOSTS, "comma separated names and addresses the server certificate is valid for")
+	flag.StringVar(&auditLogFile, "audit-log", DEFAULT_AUDIT_LOG, "file the mutating requests are appended to as JSON lines, empty turns the audit log off")
+	flag.StringVar(&shutdownMode, "on-shutdown", SHUTDOWN_LEAVE, "what happens to the agents and their containers when the server stops: leave or teardown")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", DEFAULT_SHUTDOWN_TIMEOUT, "how long a stopping server waits for running requests and operations")
+	flag.StringVar(&agentPath, "agent-path", AGENT_PATH, "the agent executable")
+	flag.StringVar(&agentLogDir, "agent-log-dir", DEFAULT_AGENT_LOG_DIR, "directory of the agent log files, one per agent")
+	flag.StringVar(&logLevel, "log-level", LEVEL_INFO, "lowest level logged: debug, info, warn or error")
+	flag.StringVar(&logFile, "log-file", DEFAULT_LOG_FILE, "file the server log is appended to as JSON lines, stderr when empty")
+	flag.Parse()
+
+	if err := checkLogLevel(logLevel); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	if err := openLogFile(logFile); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	// what is still logged through the log package, by net/http for one, ends up in the same log
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(stdLogWriter{})
+
+	if shutdownMode != SHUTDOWN_LEAVE && shutdownMode != SHUTDOWN_TEARDOWN {
+		serverLog.fatalf("-on-shutdown must be %s or %s", SHUTDOWN_LEAVE, SHUTDOWN_TEARDOWN)
+	}
+
+	var err error
+	if secretKey, err = loadSecretKey(secretKeyFile); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	if tokensFile == "" {
+		serverLog.infof("authentication is off, anyone reaching the server is an admin")
+	} else if apiTokens, err = loadTokens(tokensFile); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	if bootstrapToken, err = loadBootstrapToken(bootstrapTokenFile); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	if pkiDir == "" {
+		serverLog.infof("TLS is off, the API and the agents are served over plain HTTP")
+	} else if err := enableTLS(); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	if auditLogFile == "" {
+		serverLog.infof("the audit log is off")
+	} else if err := openAuditLog(auditLogFile); err != nil {
+		serverLog.fatalf("%s", err)
+	}
+	initalizeParams()
+	agentSupervisor.adoptDetached()
+	agentSupervisor.scale(*agentsAmount)
+
+	r := newRouter()
+
+	go watchAgentLeases()
+
+	httpServer := &http.Server{Addr: ":" + PORT, Handler: r}
+	if ca != nil {
+		httpServer.TLSConfig = serverTLSConfig()
+		go renewServerCertificate()
+	}
+
+	serverLog.infof("Server is waiting for connections on port %s", PORT)
+
+	serveUntilSignal(httpServer)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// UNKNOWN_IMAGE is an image the fake agents fail to pull
+const UNKNOWN_IMAGE = "no-such-image"
+
+// fakeAgent answers the server like a real agent and remembers the containers it runs
+type fakeAgent struct {
+	server     *httptest.Server
+	lock       sync.Mutex
+	containers map[string]agentContainer
+	// the request IDs of the runContainer and deleteContainer requests
+	requestIDs []string
+}
+
+func newFakeAgent(t *testing.T) *fakeAgent {
+	agent := &fakeAgent{containers: make(map[string]agentContainer)}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/runContainer", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		var container agentContainer
+		json.NewDecoder(r.Body).Decode(&container)
+		time.Sleep(time.Millisecond)
+
+		// like docker for an image missing from the registry
+		if container.Image == UNKNOWN_IMAGE {
+			apiError := newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, "could not pull image "+container.Image)
+			apiError.Details = "No such image"
+			respondWithError(responseHTTP, apiError)
+			return
+		}
+
+		agent.lock.Lock()
+		agent.containers[containerName(&container.Container)] = container
+		agent.requestIDs = append(agent.requestIDs, r.Header.Get(REQUEST_ID_HEADER))
+		agent.lock.Unlock()
+		respondWithJSON(responseHTTP, http.StatusCreated, "container created")
+	})
+	handler.HandleFunc("/deleteContainer", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		var name string
+		json.NewDecoder(r.Body).Decode(&name)
+		time.Sleep(time.Millisecond)
+
+		agent.lock.Lock()
+		delete(agent.containers, name)
+		agent.requestIDs = append(agent.requestIDs, r.Header.Get(REQUEST_ID_HEADER))
+		agent.lock.Unlock()
+		respondWithJSON(responseHTTP, http.StatusOK, name)
+	})
+	handler.HandleFunc("/isAgentActive", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		responseHTTP.WriteHeader(http.StatusOK)
+	})
+	handler.HandleFunc("/metrics", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		responseHTTP.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		fmt.Fprintf(responseHTTP, "minikube_agent_containers{state=\"running\"} %d\n", len(agent.containerNames()))
+	})
+
+	handler.HandleFunc("/containerStats", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		agent.lock.Lock()
+		defer agent.lock.Unlock()
+
+		statuses := make([]AgentContainerStatus, 0)
+		for _, name := range r.URL.Query()["name"] {
+			status := AgentContainerStatus{Name: name, State: "missing"}
+			if _, ok := agent.containers[name]; ok {
+				status = AgentContainerStatus{Name: name, State: "running", Restarts: 1, Usage: &ContainerUsage{CPUPercent: 12.5, MemoryUsage: 64 << 20, MemoryLimit: 1 << 30}}
+			}
+			statuses = append(statuses, status)
+		}
+		respondWithJSON(responseHTTP, http.StatusOK, statuses)
+	})
+
+	// the logs of a container are its name and the query the server passed on
+	handler.HandleFunc("/containerLogs/", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/containerLogs/")
+		if !agent.runs(name) {
+			respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("container %s is not managed by this agent", name)))
+			return
+		}
+		responseHTTP.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(responseHTTP, "%s %s\n", name, r.URL.RawQuery)
+	})
+	// an exec session echoes every message prefixed with the container name
+	handler.HandleFunc("/exec/", func(responseHTTP http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/exec/")
+		if !agent.runs(name) {
+			respondWithError(responseHTTP, newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, fmt.Sprintf("container %s is not managed by this agent", name)))
+			return
+		}
+		conn, err := upgrader.Upgrade(responseHTTP, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, append([]byte(name+": "), data...))
+		}
+	})
+
+	agent.server = httptest.NewUnstartedServer(handler)
+	if ca != nil {
+		agent.server.TLS = fakeAgentTLSConfig(t)
+		agent.server.StartTLS()
+	} else {
+		agent.server.Start()
+	}
+	t.Cleanup(agent.server.Close)
+	return agent
+}
+
+func (agent *fakeAgent) port() int {
+	serverURL, _ := url.Parse(agent.server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+	return port
+}
+
+func (agent *fakeAgent) runs(name string) bool {
+	agent.lock.Lock()
+	defer agent.lock.Unlock()
+
+	_, ok := agent.containers[name]
+	return ok
+}
+
+func (agent *fakeAgent) containerNames() []string {
+	agent.lock.Lock()
+	defer agent.lock.Unlock()
+
+	names := make([]string, 0, len(agent.containers))
+	for name := range agent.containers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// newTestServer resets the server state to the given agents and serves the server routes
+func newTestServer(t *testing.T, agents ...*fakeAgent) *httptest.Server {
+	stateLock.Lock()
+	mapConfigurationToAgents = make(map[string]*ConfigurationAgent)
+	agentsArray = make([]*Agent, 0)
+	configMaps = make(map[string]*ConfigMap)
+	quotas = make(map[string]*Quota)
+	secrets = make(map[string]*Secret)
+	for i, agent := range agents {
+		agentsArray = append(agentsArray, &Agent{ID: fmt.Sprintf("agent-%d", i), Port: agent.port(), Active: true, State: AGENT_READY, LastHeartbeat: time.Now().UTC(), MapContainerName: make(map[string]*Container)})
+	}
+	stateLock.Unlock()
+
+	server := httptest.NewUnstartedServer(newRouter())
+	if ca == nil {
+		server.Start()
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	// StartTLS would serve its own certificate, the server serves the one of the CA like main does
+	server.Listener = tls.NewListener(server.Listener, serverTLSConfig())
+	server.Start()
+	server.URL = strings.Replace(server.URL, "http://", "https://", 1)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testClient sends the requests of the tests, it trusts the CA of the server while TLS is on
+var testClient = http.DefaultClient
+
+// testToken is the bearer token of doRequest, none when it is empty
+var testToken string
+
+func doRequest(t *testing.T, method string, requestURL string, payload interface{}) (int, []byte) {
+	return doRequestWithToken(t, testToken, method, requestURL, payload)
+}
+
+// doRequestWithToken sends the request with the token as bearer token, without any when it is empty
+func doRequestWithToken(t *testing.T, token string, method string, requestURL string, payload interface{}) (int, []byte) {
+	return doRequestWithClient(t, testClient, token, method, requestURL, payload)
+}
+
+func doRequestWithClient(t *testing.T, client *http.Client, token string, method string, requestURL string, payload interface{}) (int, []byte) {
+	var body bytes.Buffer
+	if payload != nil {
+		json.NewEncoder(&body).Encode(payload)
+	}
+
+	request, err := http.NewRequest(method, requestURL, &body)
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, responseBody
+}
+
+// waitForOperation polls the operation a mutating /api/v1 request answered with until it ends
+func waitForOperation(t *testing.T, serverURL string, status int, body []byte) Operation {
+	var operation Operation
+	if status != http.StatusAccepted {
+		t.Errorf("expected an operation, got status %d %s", status, body)
+		return operation
+	}
+	json.Unmarshal(body, &operation)
+
+	for operation.Status == OPERATION_RUNNING {
+		time.Sleep(5 * time.Millisecond)
+		status, body = doRequest(t, http.MethodGet, serverURL+API_V1_PREFIX+"/operations/"+operation.ID, nil)
+		if status != http.StatusOK {
+			t.Errorf("operation %s: status %d %s", operation.ID, status, body)
+			return operation
+		}
+		json.Unmarshal(body, &operation)
+	}
+	return operation
+}
+
+// checkConsistency verifies the server state against what the fake agents actually run
+func checkConsistency(t *testing.T, agents ...*fakeAgent) {
+	running := make([]string, 0)
+	for _, agent := range agents {
+		running = append(running, agent.containerNames()...)
+	}
+
+	stateLock.RLock()
+	expected := make([]string, 0)
+	for _, configurationAgent := range mapConfigurationToAgents {
+		for i := 1; i <= configurationAgent.Configuration.Amount; i++ {
+			expected = append(expected, configurationAgent.Configuration.containerName(i))
+		}
+	}
+	known := make([]string, 0)
+	for _, agent := range agentsArray {
+		for name := range agent.MapContainerName {
+			known = append(known, name)
+		}
+	}
+	stateLock.RUnlock()
+
+	sort.Strings(running)
+	sort.Strings(expected)
+	sort.Strings(known)
+	if fmt.Sprint(running) != fmt.Sprint(expected) || fmt.Sprint(known) != fmt.Sprint(expected) {
+		t.Fatalf("state is inconsistent\nexpected: %v\nserver:   %v\nagents:   %v", expected, known, running)
+	}
+}
+
+func TestMain(m *testing.M) {
+	// the supervisor tests start the test binary itself as agent process
+	if os.Getenv("FAKE_AGENT_PROCESS") == "1" {
+		time.Sleep(time.Hour)
+		os.Exit(0)
+	}
+
+	// writeDataToJSON writes into the working directory
+	directory, err := ioutil.TempDir("", "server-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Chdir(directory)
+	secretKey = make([]byte, SECRET_KEY_SIZE)
+	log.SetOutput(ioutil.Discard)
+	logOutput = ioutil.Discard
+
+	code := m.Run()
+	os.RemoveAll(directory)
+	os.Exit(code)
+}
+
+func TestAgentRegistration(t *testing.T) {
+	agents := []*fakeAgent{newFakeAgent(t), newFakeAgent(t)}
+	server := newTestServer(t, agents...)
+
+	status, body := doRequest(t, http.MethodPost, server.URL+API_V1_PREFIX+"/configurations", Configuration{Name: "web", Amount: 4, Image: "alpine"})
+	if operation := waitForOperation(t, server.URL, status, body); operation.Status != OPERATION_SUCCEEDED {
+		t.Fatalf("create: %+v", operation)
+	}
+
+	stateLock.Lock()
+	for _, agent := range agentsArray {
+		agent.Active = false
+	}
+	stateLock.Unlock()
+
+	// a new agent is added next to the inactive ones instead of taking their place
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/agentPort", AgentRegistration{ID: "agent-new", Port: 41000}); status != http.StatusCreated {
+		t.Fatalf("register new agent: status %d %s", status, body)
+	}
+
+	stateLock.RLock()
+	replaced := len(agentsArray) != 3 || agentsArray[0].Active || agentsArray[1].Active || agentsArray[0].Port != agents[0].port()
+	stateLock.RUnlock()
+	if replaced {
+		t.Fatalf("inactive agents were changed by a new registration")
+	}
+
+	// a known agent reconnects and keeps its containers
+	agentPort := agents[1].port()
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/agentPort", AgentRegistration{ID: "agent-1", Port: agentPort}); status != http.StatusOK {
+		t.Fatalf("reconnect agent: status %d %s", status, body)
+	}
+
+	stateLock.RLock()
+	reconnected := agentByID("agent-1")
+	lost := len(agentsArray) != 3 || !reconnected.Active || len(reconnected.MapContainerName) != 2
+	stateLock.RUnlock()
+	if lost {
+		t.Fatalf("reconnected agent lost its state")
+	}
+
+	if status, body := doRequest(t, http.MethodPost, server.URL+"/agentPort", AgentRegistration{Port: agentPort}); status != http.StatusBadRequest {
+		t.Fatalf("expected a registration without ID to fail, got status %d %s", status, body)
+	}
+}